@@ -5,57 +5,91 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcestrategy"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
 	restbuilder "github.com/henderiw/apiserver-builder/pkg/builder/rest"
+	"github.com/henderiw/apiserver-builder/pkg/openapi"
+	"github.com/henderiw/apiserver-builder/pkg/util/loopback"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/managedfields"
 	"k8s.io/apimachinery/pkg/util/sets"
 	genericregistry "k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/server"
+	restclient "k8s.io/client-go/rest"
 	basecompatibility "k8s.io/component-base/compatibility"
 )
 
-var (
-	// Scheme defines methods for serializing and deserializing API objects.
-	Scheme = runtime.NewScheme()
-	// Codecs provides methods for retrieving codecs and serializers for specific
-	// versions and content types.
-	Codecs = serializer.NewCodecFactory(Scheme)
-
-	ParameterScheme = runtime.NewScheme()
-	ParameterCodec  = runtime.NewParameterCodec(ParameterScheme)
-
-	APIs                = map[schema.GroupVersionResource]*restbuilder.StorageProvider{}
-	GenericAPIServerFns []func(*server.GenericAPIServer) *server.GenericAPIServer
-)
-
-func init() {
-	err := metav1.AddMetaToScheme(ParameterScheme)
-	if err != nil {
-		panic(err)
-	}
-
+// NewScheme returns a scheme with the unversioned meta types served by the generic apiserver, the types of
+// the resources are added by the builder.
+func NewScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
 	// we need to add the options to empty v1
 	// TODO fix the server code to avoid this
-	metav1.AddToGroupVersion(Scheme, schema.GroupVersion{Version: "v1"})
+	metav1.AddToGroupVersion(scheme, schema.GroupVersion{Version: "v1"})
 
 	// TODO: keep the generic API server from wanting this
 	unversioned := schema.GroupVersion{Group: "", Version: "v1"}
-	Scheme.AddUnversionedTypes(unversioned,
+	scheme.AddUnversionedTypes(unversioned,
 		&metav1.Status{},
 		&metav1.APIVersions{},
 		&metav1.APIGroupList{},
 		&metav1.APIGroup{},
 		&metav1.APIResourceList{},
 	)
+	return scheme
+}
+
+// NewParameterScheme returns a scheme with the meta types used to convert the query parameters of the requests.
+func NewParameterScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		panic(err)
+	}
+	return scheme
 }
 
 // ExtraConfig holds custom apiserver config
 type ExtraConfig struct {
 	ServerName string
+	// Scheme defines methods for serializing and deserializing API objects.
+	Scheme *runtime.Scheme
+	// Codecs provides methods for retrieving codecs and serializers for specific
+	// versions and content types.
+	Codecs serializer.CodecFactory
+	// ParameterScheme and ParameterCodec convert the query parameters of the requests.
+	ParameterScheme *runtime.Scheme
+	ParameterCodec  runtime.ParameterCodec
+	// APIs holds the storage providers of the resources served by the apiserver.
+	APIs map[schema.GroupVersionResource]*restbuilder.StorageProvider
+	// GenericAPIServerFns customize the GenericAPIServer.
+	GenericAPIServerFns []func(*server.GenericAPIServer) *server.GenericAPIServer
+	// Loopback is filled with the loopback configs of the apiserver when it is created.
+	Loopback *loopback.Config
+	// ValidationRules holds the compiled x-kubernetes-validations rules of the resources, they are evaluated by the
+	// create and update strategies of the storage of the resources.
+	ValidationRules map[schema.GroupResource]*restbuilder.ValidationRules
+	// MigrateStorageVersions rewrites the objects of every resource in its storage version when the apiserver
+	// starts, see Server.MigrateStorageVersions.
+	MigrateStorageVersions bool
+}
+
+// NewExtraConfig returns an ExtraConfig with its own schemes, codecs and APIs, so several apiservers can be
+// built in one process.
+func NewExtraConfig() ExtraConfig {
+	scheme := NewScheme()
+	parameterScheme := NewParameterScheme()
+	return ExtraConfig{
+		Scheme:          scheme,
+		Codecs:          serializer.NewCodecFactory(scheme),
+		ParameterScheme: parameterScheme,
+		ParameterCodec:  runtime.NewParameterCodec(parameterScheme),
+		APIs:            map[schema.GroupVersionResource]*restbuilder.StorageProvider{},
+		ValidationRules: map[schema.GroupResource]*restbuilder.ValidationRules{},
+		Loopback:        &loopback.Config{},
+	}
 }
 
 type Config struct {
@@ -66,11 +100,19 @@ type Config struct {
 // Server contains state for a Kubernetes cluster master/api server.
 type Server struct {
 	GenericAPIServer *server.GenericAPIServer
+	// TypeConverter converts the resources to the typed values of server-side apply and the managed fields,
+	// it is built from the schemas the handlers of the API groups are installed with
+	TypeConverter managedfields.TypeConverter
+	// storageVersions holds the storage versions of the resources served at StorageVersionsPath
+	storageVersions *storageVersions
 }
 
 type completedConfig struct {
 	GenericConfig server.CompletedConfig
 	ExtraConfig   *ExtraConfig
+	// masterClientConfig is the client config of the master kube-apiserver, it is not part of the
+	// completed generic config
+	masterClientConfig *restclient.Config
 }
 
 // CompletedConfig embeds a private pointer that cannot be instantiated outside of this package.
@@ -81,9 +123,16 @@ type CompletedConfig struct {
 // Complete fills in any fields not set that are required to have valid data. It's mutating the receiver.
 func (cfg *Config) Complete() CompletedConfig {
 	cfg.GenericConfig.EffectiveVersion = basecompatibility.NewEffectiveVersionFromString("", "", "")
+	// the generic apiserver requires the OpenAPI v3 config to install the API groups, without registered
+	// definitions the resources are served without a schema and no OpenAPI document is served
+	if cfg.GenericConfig.OpenAPIV3Config == nil {
+		cfg.GenericConfig.OpenAPIV3Config = openapi.NewDeducedV3Config(cfg.ExtraConfig.Scheme)
+		cfg.GenericConfig.SkipOpenAPIInstallation = cfg.GenericConfig.OpenAPIConfig == nil
+	}
 	c := completedConfig{
 		cfg.GenericConfig.Complete(),
 		&cfg.ExtraConfig,
+		cfg.GenericConfig.ClientConfig,
 	}
 
 	return CompletedConfig{&c}
@@ -96,47 +145,91 @@ func (c completedConfig) New(ctx context.Context) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	genericServer = ApplyGenericAPIServerFns(genericServer)
+	genericServer = c.ExtraConfig.ApplyGenericAPIServerFns(genericServer)
 
 	s := &Server{
 		GenericAPIServer: genericServer,
 	}
+	if c.ExtraConfig.Loopback != nil {
+		*c.ExtraConfig.Loopback = loopback.Config{
+			ClientConfig:       c.GenericConfig.LoopbackClientConfig,
+			MasterClientConfig: c.masterClientConfig,
+			Authorizer:         c.GenericConfig.Authorization.Authorizer,
+		}
+	}
 
 	// Add new APIs through inserting into APIs
-	apiGroups, err := BuildAPIGroupInfos(ctx, Scheme, c.GenericConfig.RESTOptionsGetter)
+	apiGroups, err := c.ExtraConfig.BuildAPIGroupInfos(ctx, c.GenericConfig.RESTOptionsGetter)
 	if err != nil {
 		return nil, err
 	}
-	for _, apiGroupInfo := range apiGroups {
-		if err := s.GenericAPIServer.InstallAPIGroup(apiGroupInfo); err != nil {
+	if len(apiGroups) != 0 {
+		if err := s.GenericAPIServer.InstallAPIGroups(apiGroups...); err != nil {
 			return nil, err
 		}
-
-		// After install, check what StaticOpenAPISpec contains
-		fmt.Printf("DEBUG: StaticOpenAPISpec keys count: %d\n", len(apiGroupInfo.StaticOpenAPISpec))
-		for k := range apiGroupInfo.StaticOpenAPISpec {
-			if strings.Contains(k, "config") || strings.Contains(k, "sdcio") {
-				fmt.Printf("DEBUG: spec key: %s\n", k)
-			}
+		// the generic apiserver installs a single TypeConverter for the handlers of all the groups, it is
+		// built from the schemas recorded as the static OpenAPI spec of every group
+		s.TypeConverter, err = managedfields.NewTypeConverter(apiGroups[0].StaticOpenAPISpec, false)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build the TypeConverter: %w", err)
 		}
+		// fail fast when a resource has no schema for server-side apply
+		if err := openapi.CheckTypeConverter(s.TypeConverter, apiGroups...); err != nil {
+			return nil, err
+		}
+	}
+	s.GenericAPIServer.Handler.GoRestfulContainer.Filter(withParentStorage(apiGroups...))
+	s.storageVersions, err = newStorageVersions(c.ExtraConfig.Scheme, apiGroups...)
+	if err != nil {
+		return nil, err
+	}
+	s.GenericAPIServer.Handler.NonGoRestfulMux.Handle(StorageVersionsPath, s.storageVersions)
+	if c.ExtraConfig.MigrateStorageVersions {
+		// the migration runs in the background, the apiserver is ready before its objects are migrated
+		s.GenericAPIServer.AddPostStartHookOrDie(fmt.Sprintf("%s-storage-version-migration", c.ExtraConfig.ServerName),
+			func(ctx server.PostStartHookContext) error {
+				go s.MigrateStorageVersions(ctx)
+				return nil
+			})
 	}
 	return s, nil
 }
 
-func BuildAPIGroupInfos(ctx context.Context, s *runtime.Scheme, g genericregistry.RESTOptionsGetter) ([]*server.APIGroupInfo, error) {
+// StorageVersions returns the storage versions of the resources served by the apiserver together with the
+// status of their last migration, they are also served at StorageVersionsPath.
+func (s *Server) StorageVersions() []StorageVersion {
+	return s.storageVersions.list()
+}
+
+// MigrateStorageVersions rewrites the objects of every resource persisted by the apiserver in the storage version
+// of the resource, e.g. after the storage version of the resource changed. The objects are updated without
+// changes through the storage of the resource, so the storage encodes them in the current storage version.
+// The progress and the failures are logged and reported by StorageVersions, the returned error aggregates the
+// failed resources.
+func (s *Server) MigrateStorageVersions(ctx context.Context) error {
+	return s.storageVersions.migrate(ctx)
+}
+
+// BuildAPIGroupInfos returns the API groups of the resources of the APIs, the storage of the resources is
+// created with the RESTOptionsGetter.
+func (e *ExtraConfig) BuildAPIGroupInfos(ctx context.Context, g genericregistry.RESTOptionsGetter) ([]*server.APIGroupInfo, error) {
+	s := e.Scheme
 	resourcesByGroupVersion := make(map[schema.GroupVersion]sets.Set[string])
 	groups := sets.New[string]()
-	for gvr := range APIs {
+	for gvr := range e.APIs {
 		groups.Insert(gvr.Group)
 		if resourcesByGroupVersion[gvr.GroupVersion()] == nil {
 			resourcesByGroupVersion[gvr.GroupVersion()] = sets.New[string]()
 		}
 		resourcesByGroupVersion[gvr.GroupVersion()].Insert(gvr.Resource)
 	}
+	// the versions of a group-resource share the storage created for the first of them, the storage is
+	// converted to and from the requested version by the codecs
+	stores := map[schema.GroupResource]rest.Storage{}
 	apiGroups := []*server.APIGroupInfo{}
 	for _, group := range sets.List[string](groups) {
 		apis := map[string]map[string]rest.Storage{}
-		for gvr, storageHandler := range APIs {
+		for gvr, storageHandler := range e.APIs {
 			if gvr.Group != group {
 				continue
 			}
@@ -148,19 +241,27 @@ func BuildAPIGroupInfos(ctx context.Context, s *runtime.Scheme, g genericregistr
 			if storageHandler.ResourceStorageProviderFn == nil {
 				return nil, fmt.Errorf("gvr %s has no storageprovider registered", gvr.String())
 			}
-			storage, err := storageHandler.ResourceStorageProviderFn(s, g)
-			if err != nil {
-				return nil, err
+			storage, found := stores[gvr.GroupResource()]
+			if !found {
+				var err error
+				if storage, err = storageHandler.ResourceStorageProviderFn(s, g); err != nil {
+					return nil, err
+				}
+				// Build rejects the resources with rules whose storage provider has no ValidationRulesStorageFn
+				if rules := e.ValidationRules[gvr.GroupResource()]; rules != nil && !strings.Contains(gvr.Resource, "/") {
+					if storage, err = storageHandler.ValidationRulesStorageFn(storage, rules); err != nil {
+						return nil, fmt.Errorf("%s: %w", gvr.GroupResource(), err)
+					}
+				}
+				if !strings.Contains(gvr.Resource, "/") {
+					stores[gvr.GroupResource()] = storage
+				}
 			}
-
 			apis[gvr.Version][gvr.Resource] = storage
-			// add the defaulting function for this version to the scheme
-			if _, ok := storage.(resourcestrategy.Defaulter); ok {
-				if obj, ok := storage.(runtime.Object); ok {
-					s.AddTypeDefaultingFunc(obj, func(obj interface{}) {
-						obj.(resourcestrategy.Defaulter).Default()
-					})
-				}
+			// advertise the short names and the categories of the resource in discovery and call its delete
+			// hooks, the subresources are served on top of the unwrapped storage
+			if obj, ok := storage.New().(resource.InternalObject); ok {
+				apis[gvr.Version][gvr.Resource] = restbuilder.WithInternalObjectHooks(storage, obj)
 			}
 			// register the status subresource store if exists
 			if storageHandler.StatusSubResourceStorageProviderFn != nil {
@@ -171,6 +272,14 @@ func BuildAPIGroupInfos(ctx context.Context, s *runtime.Scheme, g genericregistr
 				apis[gvr.Version][gvr.Resource+"/"+"status"] = statusstorage
 
 			}
+			// register the scale subresource store if the resource supports it
+			if _, ok := storage.New().(resource.ObjectWithScaleSubResource); ok {
+				scaleStorage, err := restbuilder.NewScaleSubResourceStorage(storage)
+				if err != nil {
+					return nil, err
+				}
+				apis[gvr.Version][gvr.Resource+"/"+"scale"] = scaleStorage
+			}
 			// register the arbitray subresource stores if exists
 			for subResourcename, storageProviderFn := range storageHandler.ArbitrarySubresourceHandlerProviders {
 				if storageProviderFn != nil {
@@ -178,21 +287,22 @@ func BuildAPIGroupInfos(ctx context.Context, s *runtime.Scheme, g genericregistr
 					if err != nil {
 						return nil, err
 					}
+					// the parent storage is plumbed into the context of the requests, see withParentStorage
 					apis[gvr.Version][gvr.Resource+"/"+subResourcename] = subResourceStorage
 				}
 			}
 
 		}
-		apiGroupInfo := server.NewDefaultAPIGroupInfo(group, Scheme, ParameterCodec, Codecs)
+		apiGroupInfo := server.NewDefaultAPIGroupInfo(group, e.Scheme, e.ParameterCodec, e.Codecs)
 		apiGroupInfo.VersionedResourcesStorageMap = apis
 		apiGroups = append(apiGroups, &apiGroupInfo)
 	}
 	return apiGroups, nil
 }
 
-func ApplyGenericAPIServerFns(in *server.GenericAPIServer) *server.GenericAPIServer {
-	for i := range GenericAPIServerFns {
-		in = GenericAPIServerFns[i](in)
+func (e *ExtraConfig) ApplyGenericAPIServerFns(in *server.GenericAPIServer) *server.GenericAPIServer {
+	for i := range e.GenericAPIServerFns {
+		in = e.GenericAPIServerFns[i](in)
 	}
 	return in
 }
@@ -0,0 +1,58 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// OpenAPISpecs holds the OpenAPI documents served by the apiserver.
+type OpenAPISpecs struct {
+	// V2 is the OpenAPI v2 document served at /openapi/v2
+	V2 []byte
+	// V3 holds the OpenAPI v3 documents served at /openapi/v3/<path> by path, e.g. apis/example.com/v1alpha1
+	V3 map[string][]byte
+}
+
+// OpenAPISpecs returns the OpenAPI documents served by the server. The server must be prepared to run, the
+// documents are requested from the handler of the server without running it.
+func (s *Server) OpenAPISpecs() (*OpenAPISpecs, error) {
+	specs := &OpenAPISpecs{V3: map[string][]byte{}}
+	var err error
+	if specs.V2, err = s.get("/openapi/v2"); err != nil {
+		return nil, err
+	}
+	data, err := s.get("/openapi/v3")
+	if err != nil {
+		return nil, err
+	}
+	discovery := struct {
+		Paths map[string]struct {
+			ServerRelativeURL string `json:"serverRelativeURL"`
+		} `json:"paths"`
+	}{}
+	if err := json.Unmarshal(data, &discovery); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI v3 discovery: %w", err)
+	}
+	for path, item := range discovery.Paths {
+		if specs.V3[path], err = s.get(item.ServerRelativeURL); err != nil {
+			return nil, err
+		}
+	}
+	return specs, nil
+}
+
+// get returns the JSON body of the response of the server to the GET request of the path, the request
+// bypasses the authentication and authorization filters.
+func (s *Server) get(path string) ([]byte, error) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept", "application/json")
+	resp := httptest.NewRecorder()
+	s.GenericAPIServer.Handler.Director.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %d %s", strings.SplitN(path, "?", 2)[0], resp.Code, resp.Body.String())
+	}
+	return resp.Body.Bytes(), nil
+}
@@ -0,0 +1,287 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// StorageVersionsPath is the path the storage versions of the resources are served at
+	StorageVersionsPath = "/storageversions"
+	// storageVersionMigrationChunkSize is the number of objects listed at once by the migration
+	storageVersionMigrationChunkSize = 500
+	// storageVersionMigrationMaxErrors is the number of failures reported by the status of a migration
+	storageVersionMigrationMaxErrors = 10
+)
+
+// StorageVersionMigrationState is the state of the storage version migration of a resource.
+type StorageVersionMigrationState string
+
+const (
+	StorageVersionMigrationRunning   StorageVersionMigrationState = "Running"
+	StorageVersionMigrationSucceeded StorageVersionMigrationState = "Succeeded"
+	StorageVersionMigrationFailed    StorageVersionMigrationState = "Failed"
+)
+
+// StorageVersion reports the version the objects of a resource are persisted in, it is served at
+// StorageVersionsPath.
+type StorageVersion struct {
+	Group    string `json:"group"`
+	Resource string `json:"resource"`
+	// StorageVersion is the version the objects are encoded in when they are written
+	StorageVersion string `json:"storageVersion"`
+	// ServedVersions are the versions the resource is served in, they share the storage
+	ServedVersions []string `json:"servedVersions"`
+	// Migration is the status of the last storage version migration, nil if the objects were not migrated
+	Migration *StorageVersionMigration `json:"migration,omitempty"`
+}
+
+// StorageVersionMigration reports the progress of the migration of the objects of a resource to the storage
+// version.
+type StorageVersionMigration struct {
+	State StorageVersionMigrationState `json:"state"`
+	// Migrated is the number of objects rewritten in the storage version
+	Migrated int `json:"migrated"`
+	// Failed is the number of objects that could not be rewritten, e.g. because they are no longer valid
+	Failed int `json:"failed"`
+	// Errors holds the first failures of the migration
+	Errors         []string     `json:"errors,omitempty"`
+	StartTime      metav1.Time  `json:"startTime"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// storageVersions holds the storage versions of the resources of the apiserver together with their storage.
+type storageVersions struct {
+	mu       sync.RWMutex
+	versions []*StorageVersion
+	storages []rest.Storage
+}
+
+// newStorageVersions returns the storage versions of the resources of the API groups. The storage version is
+// the version the storage encodes the objects in or, if the storage does not report it, the version the type
+// of the storage is registered in.
+func newStorageVersions(scheme *runtime.Scheme, apiGroupInfos ...*server.APIGroupInfo) (*storageVersions, error) {
+	s := &storageVersions{}
+	byGroupResource := map[schema.GroupResource]*StorageVersion{}
+	for _, apiGroupInfo := range apiGroupInfos {
+		for _, gv := range apiGroupInfo.PrioritizedVersions {
+			for _, resource := range sortedResources(apiGroupInfo.VersionedResourcesStorageMap[gv.Version]) {
+				gr := gv.WithResource(resource).GroupResource()
+				if version, found := byGroupResource[gr]; found {
+					version.ServedVersions = append(version.ServedVersions, gv.Version)
+					continue
+				}
+				storage := apiGroupInfo.VersionedResourcesStorageMap[gv.Version][resource]
+				gvk, err := storageVersionKind(scheme, gv, storage)
+				if err != nil {
+					return nil, fmt.Errorf("unable to find the storage version of %s: %w", gr, err)
+				}
+				version := &StorageVersion{
+					Group:          gr.Group,
+					Resource:       gr.Resource,
+					StorageVersion: gvk.Version,
+					ServedVersions: []string{gv.Version},
+				}
+				byGroupResource[gr] = version
+				s.versions = append(s.versions, version)
+				s.storages = append(s.storages, storage)
+			}
+		}
+	}
+	return s, nil
+}
+
+// storageVersionKind returns the kind the storage of a resource of the GroupVersion encodes the objects in.
+func storageVersionKind(scheme *runtime.Scheme, gv schema.GroupVersion, storage rest.Storage) (schema.GroupVersionKind, error) {
+	kinds, _, err := scheme.ObjectKinds(storage.New())
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	if provider, ok := storage.(rest.StorageVersionProvider); ok && provider.StorageVersion() != nil {
+		if gvk, ok := provider.StorageVersion().KindForGroupVersionKinds(kinds); ok {
+			return gvk, nil
+		}
+	}
+	for _, gvk := range kinds {
+		if gvk.Group == gv.Group && gvk.Version != runtime.APIVersionInternal {
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("%T is not registered for the group %s", storage.New(), gv.Group)
+}
+
+// list returns a copy of the storage versions.
+func (s *storageVersions) list() []StorageVersion {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	versions := make([]StorageVersion, 0, len(s.versions))
+	for _, version := range s.versions {
+		v := *version
+		v.ServedVersions = append([]string{}, version.ServedVersions...)
+		if version.Migration != nil {
+			migration := *version.Migration
+			migration.Errors = append([]string{}, version.Migration.Errors...)
+			v.Migration = &migration
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// ServeHTTP serves the storage versions as JSON.
+func (s *storageVersions) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(s.list())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// migrate rewrites the objects of every resource in its storage version one resource after the other, see
+// migrateStorageVersion. The progress and the failures are logged and reported by the storage versions, the
+// resources whose objects are not persisted are skipped.
+func (s *storageVersions) migrate(ctx context.Context) error {
+	errs := []error{}
+	for i := range s.versions {
+		version, storage := s.versions[i], s.storages[i]
+		gr := schema.GroupResource{Group: version.Group, Resource: version.Resource}
+		if !persisted(storage) {
+			klog.Infof("skipping the storage version migration of %s, its objects are not persisted", gr)
+			continue
+		}
+		migration := &StorageVersionMigration{State: StorageVersionMigrationRunning, StartTime: metav1.Now()}
+		s.update(version, migration)
+		klog.Infof("migrating the objects of %s to the storage version %s", gr, version.StorageVersion)
+		err := migrateStorageVersion(ctx, storage, func(obj string, err error) {
+			if err != nil {
+				klog.Errorf("cannot migrate %s %s to the storage version %s: %v", gr, obj, version.StorageVersion, err)
+				migration.Failed++
+				if len(migration.Errors) < storageVersionMigrationMaxErrors {
+					migration.Errors = append(migration.Errors, fmt.Sprintf("%s: %v", obj, err))
+				}
+			} else {
+				migration.Migrated++
+			}
+			s.update(version, migration)
+		})
+		if err != nil {
+			migration.Errors = append(migration.Errors, err.Error())
+		}
+		migration.State = StorageVersionMigrationSucceeded
+		if err != nil || migration.Failed != 0 {
+			migration.State = StorageVersionMigrationFailed
+			errs = append(errs, fmt.Errorf("the migration of %s to the storage version %s failed for %d objects: %v",
+				gr, version.StorageVersion, migration.Failed, migration.Errors))
+		}
+		now := metav1.Now()
+		migration.CompletionTime = &now
+		s.update(version, migration)
+		klog.Infof("migrated %d objects of %s to the storage version %s, %d failed",
+			migration.Migrated, gr, version.StorageVersion, migration.Failed)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// persisted returns false if the storage reports that it does not persist the objects, e.g. the memory store,
+// the objects of these storages are never encoded in an older version.
+func persisted(storage rest.Storage) bool {
+	provider, ok := storage.(rest.StorageVersionProvider)
+	return !ok || provider.StorageVersion() != nil
+}
+
+// update sets a copy of the migration as the migration of the storage version.
+func (s *storageVersions) update(version *StorageVersion, migration *StorageVersionMigration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := *migration
+	m.Errors = append([]string{}, migration.Errors...)
+	version.Migration = &m
+}
+
+// migrateStorageVersion rewrites every object of the storage by updating it without changes, the storage
+// encodes the updated objects in its storage version. The objects are listed in chunks and report is called
+// for every object with the error of its update. An object updated or deleted since it was listed is already
+// stored in the storage version or gone, it is not updated again.
+func migrateStorageVersion(ctx context.Context, storage rest.Storage, report func(obj string, err error)) error {
+	lister, ok := storage.(rest.Lister)
+	if !ok {
+		return fmt.Errorf("%T does not support listing the objects", storage)
+	}
+	updater, ok := storage.(rest.Updater)
+	if !ok {
+		return fmt.Errorf("%T does not support updating the objects", storage)
+	}
+	options := &metainternalversion.ListOptions{Limit: storageVersionMigrationChunkSize}
+	for {
+		list, err := lister.List(genericapirequest.WithNamespace(ctx, metav1.NamespaceAll), options)
+		if err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return err
+			}
+			key := accessor.GetName()
+			if accessor.GetNamespace() != "" {
+				key = accessor.GetNamespace() + "/" + key
+			}
+			_, _, err = updater.Update(genericapirequest.WithNamespace(ctx, accessor.GetNamespace()), accessor.GetName(),
+				rest.DefaultUpdatedObjectInfo(item), rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc,
+				false, &metav1.UpdateOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if apierrors.IsConflict(err) {
+				err = nil
+			}
+			report(key, err)
+		}
+		listAccessor, err := meta.ListAccessor(list)
+		if err != nil {
+			return err
+		}
+		if listAccessor.GetContinue() == "" {
+			return nil
+		}
+		options = &metainternalversion.ListOptions{Limit: storageVersionMigrationChunkSize, Continue: listAccessor.GetContinue()}
+	}
+}
+
+// sortedResources returns the sorted resources of the storage map, the subresources are not included.
+func sortedResources(storages map[string]rest.Storage) []string {
+	resources := make([]string, 0, len(storages))
+	for resource := range storages {
+		if !strings.Contains(resource, "/") {
+			resources = append(resources, resource)
+		}
+	}
+	sort.Strings(resources)
+	return resources
+}
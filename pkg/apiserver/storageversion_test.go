@@ -0,0 +1,82 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// pagedStorage lists the config maps in pages of two and fails the updates of the config maps with the
+// errors by name.
+type pagedStorage struct {
+	rest.TableConvertor
+	items   []corev1.ConfigMap
+	errs    map[string]error
+	limits  []int64
+	updated []string
+}
+
+func (s *pagedStorage) New() runtime.Object     { return &corev1.ConfigMap{} }
+func (s *pagedStorage) NewList() runtime.Object { return &corev1.ConfigMapList{} }
+func (s *pagedStorage) Destroy()                {}
+
+func (s *pagedStorage) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	s.limits = append(s.limits, options.Limit)
+	start := 0
+	if options.Continue != "" {
+		fmt.Sscanf(options.Continue, "%d", &start)
+	}
+	list := &corev1.ConfigMapList{}
+	end := min(start+2, len(s.items))
+	list.Items = s.items[start:end]
+	if end < len(s.items) {
+		list.Continue = fmt.Sprintf("%d", end)
+	}
+	return list, nil
+}
+
+func (s *pagedStorage) Update(ctx context.Context, name string, objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc, updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool, options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	if err := s.errs[name]; err != nil {
+		return nil, false, err
+	}
+	s.updated = append(s.updated, name)
+	return nil, false, nil
+}
+
+func TestMigrateStorageVersion(t *testing.T) {
+	gr := schema.GroupResource{Resource: "configmaps"}
+	storage := &pagedStorage{
+		errs: map[string]error{
+			"conflict": apierrors.NewConflict(gr, "conflict", fmt.Errorf("updated")),
+			"deleted":  apierrors.NewNotFound(gr, "deleted"),
+			"invalid":  apierrors.NewBadRequest("invalid"),
+		},
+	}
+	for _, name := range []string{"a", "conflict", "deleted", "invalid", "b"} {
+		storage.items = append(storage.items, corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}})
+	}
+	reported := map[string]error{}
+	err := migrateStorageVersion(context.Background(), storage, func(obj string, err error) {
+		reported[obj] = err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, storage.updated)
+	assert.Equal(t, []int64{storageVersionMigrationChunkSize, storageVersionMigrationChunkSize, storageVersionMigrationChunkSize}, storage.limits)
+	assert.Equal(t, map[string]error{
+		"default/a":        nil,
+		"default/conflict": nil,
+		"default/invalid":  storage.errs["invalid"],
+		"default/b":        nil,
+	}, reported)
+}
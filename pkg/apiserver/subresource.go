@@ -0,0 +1,46 @@
+package apiserver
+
+import (
+	"strings"
+
+	"github.com/emicklei/go-restful/v3"
+	contextutil "github.com/henderiw/apiserver-builder/pkg/util/context"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/server"
+)
+
+// withParentStorage returns the go-restful filter plumbing the storage of the resource into the context of the
+// requests of its subresources, see contextutil.GetParentStorage. The storages of the subresources are installed
+// as is, so the installer sees every optional interface they implement, e.g. rest.Creater or rest.Patcher.
+func withParentStorage(apiGroups ...*server.APIGroupInfo) restful.FilterFunction {
+	parents := map[schema.GroupVersionResource]rest.Storage{}
+	for _, apiGroupInfo := range apiGroups {
+		if len(apiGroupInfo.PrioritizedVersions) == 0 {
+			continue
+		}
+		group := apiGroupInfo.PrioritizedVersions[0].Group
+		for version, storages := range apiGroupInfo.VersionedResourcesStorageMap {
+			for path := range storages {
+				resource, _, ok := strings.Cut(path, "/")
+				if !ok {
+					continue
+				}
+				if parent, found := storages[resource]; found {
+					parents[schema.GroupVersionResource{Group: group, Version: version, Resource: path}] = parent
+				}
+			}
+		}
+	}
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		ctx := req.Request.Context()
+		if info, ok := request.RequestInfoFrom(ctx); ok && info.IsResourceRequest && info.Subresource != "" {
+			gvr := schema.GroupVersionResource{Group: info.APIGroup, Version: info.APIVersion, Resource: info.Resource + "/" + info.Subresource}
+			if parent, found := parents[gvr]; found {
+				req.Request = req.Request.WithContext(contextutil.WithParentStorage(ctx, parent))
+			}
+		}
+		chain.ProcessFilter(req, resp)
+	}
+}
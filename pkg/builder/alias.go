@@ -1,12 +1,12 @@
 package builder
 
 import (
+	builderrest "github.com/henderiw/apiserver-builder/pkg/builder/rest"
 	"github.com/henderiw/apiserver-builder/pkg/cmd/apiserverbuilder/options"
 	"github.com/spf13/cobra"
 	"k8s.io/apiserver/pkg/registry/rest"
 	"k8s.io/apiserver/pkg/server"
 	"k8s.io/kube-openapi/pkg/common"
-	//builderrest "github.com/henderiw/apiserver-builder/pkg/builder/rest"
 )
 
 // GenericAPIServer is an alias for pkgserver.GenericAPIServer
@@ -26,4 +26,4 @@ type Storage = rest.Storage
 type Command = cobra.Command
 
 // DefaultStrategy is a default strategy that may be embedded into other strategies
-//type DefaultStrategy = builderrest.DefaultStrategy
+type DefaultStrategy = builderrest.DefaultStrategy
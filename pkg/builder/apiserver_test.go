@@ -0,0 +1,1178 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/henderiw/apiserver-builder/pkg/apiserver"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
+	v1 "github.com/henderiw/apiserver-builder/pkg/builder/testdata/apis/v1"
+	v2 "github.com/henderiw/apiserver-builder/pkg/builder/testdata/apis/v2"
+	"github.com/henderiw/apiserver-builder/pkg/builder/utils"
+	"github.com/henderiw/apiserver-builder/pkg/cmd/apiserverbuilder/options"
+	"github.com/henderiw/apiserver-builder/pkg/openapi"
+	contextutil "github.com/henderiw/apiserver-builder/pkg/util/context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/audit"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/generic"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/utils/ptr"
+)
+
+const testPackage = "github.com/henderiw/apiserver-builder/pkg/builder"
+
+var widgetGV = schema.GroupVersion{Group: "test.example.com", Version: "v1"}
+
+// Widget is the resource served by the test apiserver, it is stored in memory.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WidgetSpec `json:"spec,omitempty"`
+}
+
+type WidgetSpec struct {
+	Color string       `json:"color,omitempty"`
+	Ports []WidgetPort `json:"ports,omitempty"`
+}
+
+type WidgetPort struct {
+	Port     int32  `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+func (w *Widget) Default() {
+	if w.Spec.Color == "" {
+		w.Spec.Color = "blue"
+	}
+}
+
+func (p *WidgetPort) Default() {
+	if p.Protocol == "" {
+		p.Protocol = "TCP"
+	}
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Spec.Ports = append([]WidgetPort(nil), w.Spec.Ports...)
+	return &c
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := &WidgetList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return c
+}
+
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsStorageVersion() bool            { return true }
+func (w *Widget) GetSingularName() string           { return "widget" }
+func (w *Widget) GetShortNames() []string           { return []string{"wdg"} }
+func (w *Widget) GetCategories() []string           { return []string{"all"} }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return widgetGV.WithResource("widgets")
+}
+func (w *Widget) TableConvertor() func(gr schema.GroupResource) registryrest.TableConvertor {
+	return nil
+}
+func (w *Widget) FieldLabelConversion() runtime.FieldLabelConversionFunc { return nil }
+func (w *Widget) FieldSelector() func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	return utils.NewFieldSelector(w)
+}
+func (w *Widget) PrepareForCreate(ctx context.Context, obj runtime.Object)               {}
+func (w *Widget) ValidateCreate(ctx context.Context, obj runtime.Object) field.ErrorList { return nil }
+func (w *Widget) PrepareForUpdate(ctx context.Context, obj, old runtime.Object)          {}
+func (w *Widget) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return nil
+}
+func (w *Widget) ValidateDelete(ctx context.Context) field.ErrorList {
+	if w.Spec.Color == "gold" {
+		return field.ErrorList{field.Forbidden(field.NewPath("spec", "color"), "gold widgets are kept forever")}
+	}
+	return nil
+}
+func (w *Widget) IsEqual(ctx context.Context, obj, old runtime.Object) bool {
+	return false
+}
+
+func (w *Widget) TableColumns() []resource.TableColumn {
+	return []resource.TableColumn{
+		{Name: "Color", Type: "string", JSONPath: ".spec.color"},
+		{Name: "Port", Type: "integer", JSONPath: ".spec.ports[0].port", Priority: 1},
+	}
+}
+
+func widgetDefinitions(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+	str := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"string"}}}
+	object := func(properties map[string]spec.Schema, dependencies ...string) common.OpenAPIDefinition {
+		return common.OpenAPIDefinition{
+			Schema:       spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"object"}, Properties: properties}},
+			Dependencies: dependencies,
+		}
+	}
+	refTo := func(name string) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Default: map[string]interface{}{}, Ref: ref(name)}}
+	}
+	arrayOf := func(name string) spec.Schema {
+		return spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"array"},
+			Items: &spec.SchemaOrArray{Schema: &spec.Schema{SchemaProps: spec.SchemaProps{Ref: ref(name)}}}}}
+	}
+	ports := arrayOf(testPackage + ".WidgetPort")
+	ports.MaxItems = ptr.To[int64](16)
+	return map[string]common.OpenAPIDefinition{
+		testPackage + ".Widget": object(map[string]spec.Schema{
+			"apiVersion": str, "kind": str,
+			"metadata": refTo("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+			"spec":     refTo(testPackage + ".WidgetSpec"),
+		}, "k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", testPackage+".WidgetSpec"),
+		testPackage + ".WidgetSpec": withRules(object(map[string]spec.Schema{
+			"color": withRules(common.OpenAPIDefinition{Schema: str}, map[string]interface{}{
+				"rule": "self == oldSelf", "message": "color is immutable",
+			}).Schema,
+			"ports": ports,
+		}, testPackage+".WidgetPort"), map[string]interface{}{
+			"rule":              "!has(self.ports) || self.ports.all(p, !has(p.port) || p.port > 0)",
+			"messageExpression": "'ports of the ' + self.color + ' widget must be positive'",
+		}),
+		testPackage + ".WidgetPort": object(map[string]spec.Schema{
+			"port":     {SchemaProps: spec.SchemaProps{Type: []string{"integer"}, Format: "int32"}},
+			"protocol": str,
+		}),
+		testPackage + ".WidgetList": object(map[string]spec.Schema{
+			"apiVersion": str, "kind": str,
+			"metadata": refTo("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+			"items":    arrayOf(testPackage + ".Widget"),
+		}, "k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", testPackage+".Widget"),
+	}
+}
+
+// withRules adds the x-kubernetes-validations rules to the schema of the definition.
+func withRules(definition common.OpenAPIDefinition, rules ...map[string]interface{}) common.OpenAPIDefinition {
+	validations := make([]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		validations = append(validations, rule)
+	}
+	definition.Schema.AddExtension("x-kubernetes-validations", validations)
+	return definition
+}
+
+// Gadget is a resource with status and scale subresources served by the test apiserver, its schema is deduced.
+type Gadget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              GadgetSpec   `json:"spec,omitempty"`
+	Status            GadgetStatus `json:"status,omitempty"`
+}
+
+type GadgetSpec struct {
+	Color    string `json:"color,omitempty"`
+	Replicas int32  `json:"replicas,omitempty"`
+}
+
+type GadgetStatus struct {
+	Phase string `json:"phase,omitempty"`
+	// Message is informational, a change of the message alone is not stored, see IsStatusEqual
+	Message string `json:"message,omitempty"`
+}
+
+type GadgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Gadget `json:"items"`
+}
+
+func (g *Gadget) DeepCopyObject() runtime.Object {
+	c := *g
+	g.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (g *Gadget) DeepCopyInto(out *Gadget) {
+	*out = *g
+	g.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+}
+
+func (l *GadgetList) DeepCopyObject() runtime.Object {
+	c := &GadgetList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Gadget))
+	}
+	return c
+}
+
+func (g *Gadget) GetObjectMeta() *metav1.ObjectMeta { return &g.ObjectMeta }
+func (g *Gadget) NamespaceScoped() bool             { return true }
+func (g *Gadget) New() runtime.Object               { return &Gadget{} }
+func (g *Gadget) NewList() runtime.Object           { return &GadgetList{} }
+func (g *Gadget) IsStorageVersion() bool            { return true }
+func (g *Gadget) GetSingularName() string           { return "gadget" }
+func (g *Gadget) GetShortNames() []string           { return nil }
+func (g *Gadget) GetCategories() []string           { return nil }
+func (g *Gadget) GetGroupVersionResource() schema.GroupVersionResource {
+	return widgetGV.WithResource("gadgets")
+}
+func (g *Gadget) TableConvertor() func(gr schema.GroupResource) registryrest.TableConvertor {
+	return nil
+}
+func (g *Gadget) FieldLabelConversion() runtime.FieldLabelConversionFunc { return nil }
+func (g *Gadget) FieldSelector() func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	return utils.NewFieldSelector(g)
+}
+func (g *Gadget) PrepareForCreate(ctx context.Context, obj runtime.Object)               {}
+func (g *Gadget) ValidateCreate(ctx context.Context, obj runtime.Object) field.ErrorList { return nil }
+func (g *Gadget) PrepareForUpdate(ctx context.Context, obj, old runtime.Object)          {}
+func (g *Gadget) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return nil
+}
+func (g *Gadget) IsEqual(ctx context.Context, obj, old runtime.Object) bool {
+	return obj.(*Gadget).Spec == old.(*Gadget).Spec
+}
+func (g *Gadget) GetStatus() resource.StatusSubResource { return g.Status }
+func (g *Gadget) IsStatusEqual(ctx context.Context, obj, old runtime.Object) bool {
+	return obj.(*Gadget).Status.Phase == old.(*Gadget).Status.Phase
+}
+func (g *Gadget) PrepareForStatusUpdate(ctx context.Context, obj, old runtime.Object) {}
+func (g *Gadget) ValidateStatusUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	if phase := obj.(*Gadget).Status.Phase; phase != "Pending" && phase != "Running" {
+		return field.ErrorList{field.NotSupported(field.NewPath("status", "phase"), phase, []string{"Pending", "Running"})}
+	}
+	return nil
+}
+
+func (g *Gadget) GetScale() *autoscalingv1.Scale {
+	return &autoscalingv1.Scale{Spec: autoscalingv1.ScaleSpec{Replicas: g.Spec.Replicas}}
+}
+func (g *Gadget) SetScale(scale *autoscalingv1.Scale) { g.Spec.Replicas = scale.Spec.Replicas }
+
+func (g *Gadget) GetArbitrarySubResources() []resource.ArbitrarySubResource {
+	return []resource.ArbitrarySubResource{&gadgetLog{}}
+}
+
+func (s GadgetStatus) SubResourceName() string { return "status" }
+func (s GadgetStatus) CopyTo(parent resource.ObjectWithStatusSubResource) {
+	parent.(*Gadget).Status = s
+}
+
+// newTestHandler builds the server and returns the handler of an apiserver that is never run but whose post start
+// hooks are run, the requests are served by an admin and carry an audit context like behind the audit filter.
+func newTestHandler(t *testing.T, s *Server) http.Handler {
+	t.Helper()
+	_, err := s.Build(context.Background())
+	require.NoError(t, err)
+	o := options.NewServerOptions(os.Stdout, os.Stderr, s.ExtraConfig, "", s.orderedGroupVersions...)
+	o.ServerOptionsFns = s.serverOptionsFns
+	o.RecommendedConfigFns = s.recommendedConfigFns
+	config, err := o.OfflineConfig("test")
+	require.NoError(t, err)
+	server, err := config.Complete().New(context.Background())
+	require.NoError(t, err)
+	t.Cleanup(server.GenericAPIServer.Destroy)
+	server.GenericAPIServer.PrepareRun()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	server.GenericAPIServer.RunPostStartHooks(ctx)
+
+	handler := filters.WithRequestInfo(server.GenericAPIServer.Handler.Director, &request.RequestInfoFactory{
+		APIPrefixes:          sets.NewString("apis", "api"),
+		GrouplessAPIPrefixes: sets.NewString("api"),
+	})
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		admin := &user.DefaultInfo{Name: "admin", Groups: []string{user.SystemPrivilegedGroup}}
+		ctx := audit.WithAuditContext(request.WithUser(req.Context(), admin))
+		handler.ServeHTTP(w, req.WithContext(ctx))
+	})
+	// the apiserver is ready once its post start hooks are done
+	require.Eventually(t, func() bool {
+		return serve(h, http.MethodGet, "/readyz", "").Code == http.StatusOK
+	}, 10*time.Second, 10*time.Millisecond)
+	return h
+}
+
+// serve serves the JSON request and returns the response.
+func serve(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	return serveAs(h, "application/json", method, path, body)
+}
+
+// serveAs serves the JSON request and returns the response in the accepted media type.
+func serveAs(h http.Handler, accept, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Accept", accept)
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	h.ServeHTTP(resp, req)
+	return resp
+}
+
+// kindOf returns the kind of the JSON object.
+func kindOf(t *testing.T, data []byte) string {
+	t.Helper()
+	typeMeta := &metav1.TypeMeta{}
+	require.NoError(t, json.Unmarshal(data, typeMeta))
+	return typeMeta.Kind
+}
+
+func TestDumpOpenAPI(t *testing.T) {
+	for format, validate := range map[string]func(data []byte) []error{
+		"v2": openapi.ValidateV2,
+		"v3": openapi.ValidateV3,
+	} {
+		t.Run(format, func(t *testing.T) {
+			cmd, err := NewAPIServer().
+				WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+				WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+				Build(context.Background())
+			require.NoError(t, err)
+			dir := t.TempDir()
+			cmd.SetArgs([]string{"dump-openapi", "--format", format, "--output", dir})
+			cmd.SetOut(io.Discard)
+			require.NoError(t, cmd.Execute())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+			require.NoError(t, err)
+			names := []string{}
+			for _, file := range files {
+				names = append(names, filepath.Base(file))
+				data, err := os.ReadFile(file)
+				require.NoError(t, err)
+				assert.Empty(t, validate(data), file)
+				if format == "v3" && filepath.Base(file) == "apis__test.example.com__v1_openapi.json" {
+					assert.Contains(t, string(data), `"/apis/test.example.com/v1/namespaces/{namespace}/widgets"`)
+				}
+			}
+			if format == "v2" {
+				assert.Equal(t, []string{"swagger.json"}, names)
+				return
+			}
+			assert.Contains(t, names, "apis__test.example.com__v1_openapi.json")
+		})
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})))
+
+	resp := serve(h, http.MethodGet, "/apis/test.example.com/v1", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resources := &metav1.APIResourceList{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), resources))
+	var widgets *metav1.APIResource
+	for i := range resources.APIResources {
+		if resources.APIResources[i].Name == "widgets" {
+			widgets = &resources.APIResources[i]
+		}
+	}
+	require.NotNil(t, widgets, resp.Body.String())
+	assert.Equal(t, "widget", widgets.SingularName)
+	assert.Equal(t, []string{"wdg"}, widgets.ShortNames)
+	assert.Equal(t, []string{"all"}, widgets.Categories)
+	assert.Equal(t, "Widget", widgets.Kind)
+	assert.True(t, widgets.Namespaced)
+}
+
+func TestDefaulting(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"ports":[{"port":80},{"port":53,"protocol":"UDP"}]}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created := &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+	want := WidgetSpec{Color: "blue", Ports: []WidgetPort{{Port: 80, Protocol: "TCP"}, {Port: 53, Protocol: "UDP"}}}
+	assert.Equal(t, want, created.Spec)
+
+	// the defaults are stored
+	resp = serve(h, http.MethodGet, path+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	stored := &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stored))
+	assert.Equal(t, want, stored.Spec)
+
+	// the values set by the request are not defaulted
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"b"},"spec":{"color":"green"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created = &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+	assert.Equal(t, WidgetSpec{Color: "green"}, created.Spec)
+}
+
+func TestTableColumns(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"green","ports":[{"port":80}]}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	const asTable = "application/json;as=Table;v=v1;g=meta.k8s.io"
+	for _, p := range []string{path, path + "/a"} {
+		resp = serveAs(h, asTable, http.MethodGet, p, "")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		table := &metav1.Table{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), table))
+		names := []string{}
+		for _, column := range table.ColumnDefinitions {
+			names = append(names, column.Name)
+		}
+		assert.Equal(t, []string{"Name", "Color", "Port", "Age"}, names)
+		assert.Equal(t, int32(1), table.ColumnDefinitions[2].Priority)
+		require.Len(t, table.Rows, 1)
+		assert.Equal(t, []interface{}{"a", "green", float64(80)}, table.Rows[0].Cells[:3])
+		// the rows hold the metadata of the objects by default
+		assert.Equal(t, "PartialObjectMetadata", kindOf(t, table.Rows[0].Object.Raw))
+	}
+
+	resp = serveAs(h, asTable, http.MethodGet, path+"?includeObject=Object", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	table := &metav1.Table{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), table))
+	require.Len(t, table.Rows, 1)
+	assert.Equal(t, "Widget", kindOf(t, table.Rows[0].Object.Raw))
+
+	resp = serveAs(h, asTable, http.MethodGet, path+"?includeObject=None", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	table = &metav1.Table{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), table))
+	require.Len(t, table.Rows, 1)
+	assert.Empty(t, table.Rows[0].Object.Raw)
+}
+
+func TestValidationRules(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"ports":[{"port":80},{"port":-1}]}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "ports of the blue widget must be positive")
+
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"green","ports":[{"port":80}]}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created := &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+
+	// the transition rules compare the object to the stored object
+	resp = serve(h, http.MethodPut, path+"/a", fmt.Sprintf(
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a","resourceVersion":%q},"spec":{"color":"red","ports":[{"port":80}]}}`,
+		created.ResourceVersion))
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "color is immutable")
+
+	resp = serve(h, http.MethodPut, path+"/a", fmt.Sprintf(
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a","resourceVersion":%q},"spec":{"color":"green","ports":[{"port":8080}]}}`,
+		created.ResourceVersion))
+	assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+}
+
+func TestValidationRulesCompilation(t *testing.T) {
+	definitions := func(rule string) common.GetOpenAPIDefinitions {
+		return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+			defs := widgetDefinitions(ref)
+			defs[testPackage+".Widget"] = withRules(defs[testPackage+".Widget"], map[string]interface{}{"rule": rule})
+			return defs
+		}
+	}
+	cases := map[string]struct {
+		rule string
+		err  string
+	}{
+		"valid": {
+			rule: "self.metadata.name.startsWith('w')",
+		},
+		"compilation error": {
+			rule: "self.spec.colour == 'blue'",
+			err:  "undefined field 'colour'",
+		},
+		"cost exceeds budget": {
+			rule: "self.metadata.name.split('').all(a, self.metadata.name.split('').all(b, self.metadata.name.split('').all(c, a < b || b < c)))",
+			err:  "estimated rule cost exceeds budget",
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewAPIServer().
+				WithOpenAPIDefinitions("Test", "v1", definitions(tc.rule)).
+				WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+				Build(context.Background())
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, "test.example.com/v1, Resource=widgets: invalid x-kubernetes-validations rules")
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestValidationRulesStorageProvider(t *testing.T) {
+	memory := rest.NewMemoryStorageProvider(&Widget{})
+	// the storage of the provider cannot evaluate the rules
+	sp := &rest.StorageProvider{ResourceStorageProviderFn: memory.ResourceStorageProviderFn}
+	_, err := NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, sp).
+		Build(context.Background())
+	assert.ErrorContains(t, err, "test.example.com/v1, Resource=widgets: x-kubernetes-validations rules require a storage provider with a ValidationRulesStorageFn")
+}
+
+func TestAdmissionPlugins(t *testing.T) {
+	calls := []string{}
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+		WithAdmissionPlugins(
+			AdmissionPlugin{
+				Name:       "WidgetColor",
+				Operations: []admission.Operation{admission.Create, admission.Update},
+				Validate: func(ctx context.Context, a admission.Attributes, obj, old resource.Object) error {
+					calls = append(calls, "WidgetColor")
+					if obj.(*Widget).Spec.Color == "black" {
+						return fmt.Errorf("black widgets are not allowed")
+					}
+					return nil
+				},
+			},
+			AdmissionPlugin{
+				Name: "WidgetLabels",
+				Mutate: func(ctx context.Context, a admission.Attributes, obj, old resource.Object) error {
+					calls = append(calls, "WidgetLabels")
+					if a.GetOperation() == admission.Create {
+						obj.GetObjectMeta().Labels = map[string]string{"color": obj.(*Widget).Spec.Color}
+					}
+					return nil
+				},
+			},
+		).
+		WithAdmissionPlugins(
+			AdmissionPlugin{
+				Name:       "WidgetProtection",
+				Operations: []admission.Operation{admission.Delete},
+				Resources:  []schema.GroupResource{{Group: "test.example.com", Resource: "widgets"}},
+				Validate: func(ctx context.Context, a admission.Attributes, obj, old resource.Object) error {
+					calls = append(calls, "WidgetProtection")
+					if old.GetObjectMeta().Labels["color"] == "red" {
+						return apierrors.NewConflict(a.GetResource().GroupResource(), a.GetName(), fmt.Errorf("red widgets are protected"))
+					}
+					return nil
+				},
+			},
+			AdmissionPlugin{
+				Name:       "Disabled",
+				DefaultOff: true,
+				Validate: func(ctx context.Context, a admission.Attributes, obj, old resource.Object) error {
+					return fmt.Errorf("disabled")
+				},
+			},
+		))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"black"}}`)
+	assert.Equal(t, http.StatusForbidden, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "black widgets are not allowed")
+	// the mutating plugins are called before the validating plugins
+	assert.Equal(t, []string{"WidgetLabels", "WidgetColor"}, calls)
+
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"red"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created := &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+	assert.Equal(t, map[string]string{"color": "red"}, created.Labels)
+
+	// the API status errors are returned as they are
+	calls = nil
+	resp = serve(h, http.MethodDelete, path+"/a", "")
+	assert.Equal(t, http.StatusConflict, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "red widgets are protected")
+	// the plugins admitting the deletes are called with the stored object
+	assert.Equal(t, []string{"WidgetLabels", "WidgetProtection"}, calls)
+}
+
+// widgetColorPolicy denies the black widgets.
+const widgetColorPolicy = `
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingAdmissionPolicy
+metadata:
+  name: widget-color
+spec:
+  failurePolicy: Fail
+  matchConstraints:
+    resourceRules:
+    - apiGroups: ["test.example.com"]
+      apiVersions: ["v1"]
+      operations: ["CREATE", "UPDATE"]
+      resources: ["widgets"]
+  validations:
+  - expression: "object.spec.color != 'black'"
+    message: black widgets are not allowed
+---
+apiVersion: admissionregistration.k8s.io/v1
+kind: ValidatingAdmissionPolicyBinding
+metadata:
+  name: widget-color
+spec:
+  policyName: widget-color
+  validationActions: ["Deny"]
+`
+
+func TestValidatingAdmissionPolicies(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(widgetColorPolicy), 0o600))
+	s := NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+		WithValidatingAdmissionPolicies()
+	s.admissionPolicyDir = dir
+	h := newTestHandler(t, s)
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"black"}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "black widgets are not allowed")
+
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"red"}}`)
+	assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+}
+
+func TestValidatingAdmissionPoliciesMaster(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policy.yaml"), []byte(widgetColorPolicy), 0o600))
+	policies, bindings, err := readAdmissionPolicies(dir)
+	require.NoError(t, err)
+	policies.TypeMeta = metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "ValidatingAdmissionPolicyList"}
+	bindings.TypeMeta = metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "ValidatingAdmissionPolicyBindingList"}
+	namespaces := &corev1.NamespaceList{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "NamespaceList"},
+		Items:    []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "default"}}},
+	}
+	lists := map[string]runtime.Object{
+		"/apis/admissionregistration.k8s.io/v1/validatingadmissionpolicies":       policies,
+		"/apis/admissionregistration.k8s.io/v1/validatingadmissionpolicybindings": bindings,
+		"/api/v1/namespaces": namespaces,
+	}
+	// the master kube-apiserver lists the policies, their bindings and the namespaces, its watches return no events
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		list, ok := lists[req.URL.Path]
+		switch {
+		case !ok:
+			http.NotFound(w, req)
+		case req.URL.Query().Get("sendInitialEvents") == "true":
+			// the informers fall back to listing the objects
+			http.Error(w, "watch list is not supported", http.StatusBadRequest)
+		case req.URL.Query().Get("watch") == "true":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-req.Context().Done()
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			assert.NoError(t, json.NewEncoder(w).Encode(list))
+		}
+	}))
+	t.Cleanup(func() {
+		master.CloseClientConnections()
+		master.Close()
+	})
+	s := NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+		WithValidatingAdmissionPolicies()
+	s.ExtraConfig.Loopback.MasterClientConfig = &restclient.Config{Host: master.URL}
+	h := newTestHandler(t, s)
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"black"}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "black widgets are not allowed")
+
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"red"}}`)
+	assert.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+}
+
+func TestFinalizers(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+	get := func(name string) *Widget {
+		t.Helper()
+		resp := serve(h, http.MethodGet, path+"/"+name, "")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		w := &Widget{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), w))
+		return w
+	}
+
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a","finalizers":["test.example.com/cleanup"]}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	// the object waits for its finalizers
+	resp = serve(h, http.MethodDelete, path+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	deleting := get("a")
+	require.NotNil(t, deleting.DeletionTimestamp)
+	assert.Equal(t, ptr.To[int64](0), deleting.DeletionGracePeriodSeconds)
+	assert.Equal(t, int64(2), deleting.Generation)
+
+	// removing the last finalizer deletes the object
+	resp = serve(h, http.MethodPut, path+"/a", fmt.Sprintf(
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a","resourceVersion":%q}}`,
+		deleting.ResourceVersion))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodGet, path+"/a", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code, resp.Body.String())
+
+	// the propagation policy adds the finalizer of the garbage collector
+	resp = serve(h, http.MethodPost, path, `{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"b"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodDelete, path+"/b", `{"propagationPolicy":"Orphan"}`)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	deleting = get("b")
+	assert.NotNil(t, deleting.DeletionTimestamp)
+	assert.Equal(t, []string{metav1.FinalizerOrphanDependents}, deleting.Finalizers)
+
+	// ValidateDelete rejects the deletion
+	resp = serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"c"},"spec":{"color":"gold"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodDelete, path+"/c", "")
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "gold widgets are kept forever")
+	assert.Nil(t, get("c").DeletionTimestamp)
+}
+
+var _ registryrest.Creater = &widgetEchoStorage{}
+
+// widgetEchoStorage serves the echo subresource of the widgets, a create returns the widget read from the parent
+// storage in the request context.
+type widgetEchoStorage struct{}
+
+func (s *widgetEchoStorage) New() runtime.Object   { return &Widget{} }
+func (s *widgetEchoStorage) Destroy()              {}
+func (s *widgetEchoStorage) NamespaceScoped() bool { return true }
+func (s *widgetEchoStorage) Create(ctx context.Context, obj runtime.Object, createValidation registryrest.ValidateObjectFunc, options *metav1.CreateOptions) (runtime.Object, error) {
+	parent, ok := contextutil.GetParentStorageGetter(ctx)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("no parent storage in the context"))
+	}
+	return parent.Get(ctx, obj.(*Widget).Name, &metav1.GetOptions{})
+}
+
+func TestSubResourceParentStorage(t *testing.T) {
+	sp := rest.NewMemoryStorageProvider(&Widget{})
+	sp.ArbitrarySubresourceHandlerProviders = map[string]rest.SubResourceStorageProviderFn{
+		"echo": func(scheme *runtime.Scheme, store registryrest.Storage) (registryrest.Storage, error) {
+			return &widgetEchoStorage{}, nil
+		},
+	}
+	h := newTestHandler(t, NewAPIServer().
+		WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+		WithResourceAndHandler(&Widget{}, sp))
+	path := "/apis/test.example.com/v1/namespaces/default/widgets"
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"green"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	// the subresource is installed with its create verb and receives the parent storage
+	resp = serve(h, http.MethodPost, path+"/a/echo", `{"apiVersion":"test.example.com/v1","kind":"Widget","metadata":{"name":"a"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	echoed := &Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), echoed))
+	assert.Equal(t, "green", echoed.Spec.Color)
+}
+
+func TestStatusSubResource(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithResourceAndStorageProvider(&Gadget{}, rest.NewMemoryStorageProvider(&Gadget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/gadgets"
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Gadget","metadata":{"name":"a"},"spec":{"color":"red"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created := &Gadget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+
+	// the status is stored, the change of the spec is dropped
+	body := func(rv, color, phase, message string) string {
+		return fmt.Sprintf(`{"apiVersion":"test.example.com/v1","kind":"Gadget","metadata":{"name":"a","resourceVersion":%q},`+
+			`"spec":{"color":%q},"status":{"phase":%q,"message":%q}}`, rv, color, phase, message)
+	}
+	resp = serve(h, http.MethodPut, path+"/a/status", body(created.ResourceVersion, "blue", "Running", "started"))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	updated := &Gadget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), updated))
+	assert.Equal(t, GadgetSpec{Color: "red"}, updated.Spec)
+	assert.Equal(t, GadgetStatus{Phase: "Running", Message: "started"}, updated.Status)
+	assert.NotEqual(t, created.ResourceVersion, updated.ResourceVersion)
+	resp = serve(h, http.MethodGet, path+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	stored := &Gadget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stored))
+	assert.Equal(t, updated.Spec, stored.Spec)
+	assert.Equal(t, updated.Status, stored.Status)
+
+	// a status equal to the stored status is not stored
+	resp = serve(h, http.MethodPut, path+"/a/status", body(updated.ResourceVersion, "red", "Running", "still running"))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	noop := &Gadget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), noop))
+	assert.Equal(t, updated.ResourceVersion, noop.ResourceVersion)
+	assert.Equal(t, "started", noop.Status.Message)
+
+	// the errors of ValidateStatusUpdate reject the update
+	resp = serve(h, http.MethodPut, path+"/a/status", body(updated.ResourceVersion, "red", "Broken", ""))
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, resp.Body.String())
+	assert.Contains(t, resp.Body.String(), "status.phase")
+}
+
+func TestScaleSubResource(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithResourceAndStorageProvider(&Gadget{}, rest.NewMemoryStorageProvider(&Gadget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/gadgets"
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Gadget","metadata":{"name":"a"},"spec":{"color":"red","replicas":1}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	resp = serve(h, http.MethodGet, path+"/a/scale", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	scale := &autoscalingv1.Scale{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), scale))
+	assert.Equal(t, "Scale", scale.Kind)
+	assert.Equal(t, "a", scale.Name)
+	assert.Equal(t, int32(1), scale.Spec.Replicas)
+
+	// the replicas are written to the parent object
+	body := func(rv string, replicas int) string {
+		return fmt.Sprintf(`{"apiVersion":"autoscaling/v1","kind":"Scale","metadata":{"name":"a","namespace":"default",`+
+			`"resourceVersion":%q},"spec":{"replicas":%d}}`, rv, replicas)
+	}
+	resp = serve(h, http.MethodPut, path+"/a/scale", body(scale.ResourceVersion, 3))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	scaled := &autoscalingv1.Scale{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), scaled))
+	assert.Equal(t, int32(3), scaled.Spec.Replicas)
+	assert.NotEqual(t, scale.ResourceVersion, scaled.ResourceVersion)
+	resp = serve(h, http.MethodGet, path+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	stored := &Gadget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stored))
+	assert.Equal(t, GadgetSpec{Color: "red", Replicas: 3}, stored.Spec)
+	assert.Equal(t, scaled.ResourceVersion, stored.ResourceVersion)
+
+	// the resourceVersion of the scale is a precondition on the parent object
+	resp = serve(h, http.MethodPut, path+"/a/scale", body(scale.ResourceVersion, 5))
+	assert.Equal(t, http.StatusConflict, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodGet, path+"/a/scale", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), scale))
+	assert.Equal(t, int32(3), scale.Spec.Replicas)
+}
+
+// GadgetLogOptions are the query parameters of the log subresource of the gadgets.
+type GadgetLogOptions struct {
+	metav1.TypeMeta `json:",inline"`
+	Lines           int64 `json:"lines,omitempty"`
+}
+
+func (o *GadgetLogOptions) DeepCopyObject() runtime.Object {
+	c := *o
+	return &c
+}
+
+func (o *GadgetLogOptions) ConvertFromUrlValues(values *url.Values) error {
+	if lines := values.Get("lines"); lines != "" {
+		n, err := strconv.ParseInt(lines, 10, 64)
+		if err != nil {
+			return err
+		}
+		o.Lines = n
+	}
+	return nil
+}
+
+var _ resource.ConnectorSubResource = &gadgetLog{}
+
+// gadgetLog serves the log subresource of the gadgets, the connection writes the color of the gadget read from
+// the parent storage in the request context once per requested line.
+type gadgetLog struct{}
+
+func (l *gadgetLog) SubResourceName() string { return "log" }
+func (l *gadgetLog) New() runtime.Object     { return &Gadget{} }
+func (l *gadgetLog) Destroy()                {}
+func (l *gadgetLog) NewStorage(scheme *runtime.Scheme, parentStorage registryrest.Storage) (registryrest.Storage, error) {
+	return l, nil
+}
+func (l *gadgetLog) NewConnectOptions() (runtime.Object, bool, string) {
+	return &GadgetLogOptions{}, false, ""
+}
+func (l *gadgetLog) ConnectMethods() []string { return []string{http.MethodGet} }
+func (l *gadgetLog) Connect(ctx context.Context, id string, options runtime.Object, r registryrest.Responder) (http.Handler, error) {
+	parent, ok := contextutil.GetParentStorageGetter(ctx)
+	if !ok {
+		return nil, apierrors.NewInternalError(fmt.Errorf("no parent storage in the context"))
+	}
+	obj, err := parent.Get(ctx, id, &metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	color, lines := obj.(*Gadget).Spec.Color, options.(*GadgetLogOptions).Lines
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for range lines {
+			fmt.Fprintln(w, color)
+		}
+	}), nil
+}
+
+func TestConnectorSubResource(t *testing.T) {
+	h := newTestHandler(t, NewAPIServer().
+		WithResourceAndStorageProvider(&Gadget{}, rest.NewMemoryStorageProvider(&Gadget{})))
+	path := "/apis/test.example.com/v1/namespaces/default/gadgets"
+	resp := serve(h, http.MethodPost, path,
+		`{"apiVersion":"test.example.com/v1","kind":"Gadget","metadata":{"name":"a"},"spec":{"color":"red"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+
+	// the query parameters are converted to the connect options, the connector reads the parent object
+	resp = serve(h, http.MethodGet, path+"/a/log?lines=2", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	assert.Equal(t, "red\nred\n", resp.Body.String())
+
+	resp = serve(h, http.MethodGet, path+"/a/log?lines=many", "")
+	assert.Equal(t, http.StatusBadRequest, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodGet, path+"/b/log?lines=1", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code, resp.Body.String())
+}
+
+func TestServerSideApply(t *testing.T) {
+	for name, s := range map[string]*Server{
+		"Definitions": NewAPIServer().
+			WithOpenAPIDefinitions("Test", "v1", widgetDefinitions).
+			WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})),
+		// the fields are deduced from the objects without definitions
+		"NoDefinitions": NewAPIServer().
+			WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})),
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHandler(t, s)
+			path := "/apis/test.example.com/v1/namespaces/default/widgets"
+			patch := func(contentType, query, body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPatch, path+"/a?"+query, strings.NewReader(body))
+				req.Header.Set("Accept", "application/json")
+				req.Header.Set("Content-Type", contentType)
+				resp := httptest.NewRecorder()
+				h.ServeHTTP(resp, req)
+				return resp
+			}
+			managers := func(resp *httptest.ResponseRecorder) map[string]metav1.ManagedFieldsOperationType {
+				t.Helper()
+				w := &Widget{}
+				require.NoError(t, json.Unmarshal(resp.Body.Bytes(), w))
+				result := map[string]metav1.ManagedFieldsOperationType{}
+				for _, entry := range w.ManagedFields {
+					result[entry.Manager] = entry.Operation
+				}
+				return result
+			}
+
+			resp := patch("application/apply-patch+yaml", "fieldManager=alice",
+				"apiVersion: test.example.com/v1\nkind: Widget\nmetadata:\n  name: a\nspec:\n  color: green\n  ports:\n  - port: 80\n")
+			require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+			assert.Equal(t, map[string]metav1.ManagedFieldsOperationType{"alice": metav1.ManagedFieldsOperationApply}, managers(resp))
+
+			// the ports are owned by alice
+			resp = patch("application/apply-patch+yaml", "fieldManager=bob",
+				"apiVersion: test.example.com/v1\nkind: Widget\nmetadata:\n  name: a\nspec:\n  ports:\n  - port: 81\n")
+			require.Equal(t, http.StatusConflict, resp.Code, resp.Body.String())
+			assert.Contains(t, resp.Body.String(), ".spec.ports")
+
+			resp = patch("application/merge-patch+json", "fieldManager=carol", `{"spec":{"ports":[{"port":82}]}}`)
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			assert.Equal(t, map[string]metav1.ManagedFieldsOperationType{
+				"alice": metav1.ManagedFieldsOperationApply,
+				"carol": metav1.ManagedFieldsOperationUpdate,
+			}, managers(resp))
+
+			// the forced apply takes the ownership of the ports
+			resp = patch("application/apply-patch+yaml", "fieldManager=bob&force=true",
+				"apiVersion: test.example.com/v1\nkind: Widget\nmetadata:\n  name: a\nspec:\n  ports:\n  - port: 81\n")
+			require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+			w := &Widget{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), w))
+			assert.Equal(t, WidgetSpec{Color: "green", Ports: []WidgetPort{{Port: 81, Protocol: "TCP"}}}, w.Spec)
+			assert.Equal(t, metav1.ManagedFieldsOperationApply, managers(resp)["bob"])
+			assert.NotContains(t, managers(resp), "carol")
+		})
+	}
+}
+
+func TestMultiVersion(t *testing.T) {
+	stores := 0
+	sp := rest.NewMemoryStorageProvider(&v1.Widget{})
+	newStore := sp.ResourceStorageProviderFn
+	sp.ResourceStorageProviderFn = func(scheme *runtime.Scheme, getter generic.RESTOptionsGetter) (registryrest.Storage, error) {
+		stores++
+		return newStore(scheme, getter)
+	}
+	h := newTestHandler(t, NewAPIServer().
+		WithResourceAndHandler(&v1.Widget{}, sp).
+		WithResourceAndHandler(&v2.Widget{}, &rest.StorageProvider{}))
+	// the versions share the store of the storage version
+	assert.Equal(t, 1, stores)
+	pathV1 := "/apis/versions.example.com/v1/namespaces/default/widgets"
+	pathV2 := "/apis/versions.example.com/v2/namespaces/default/widgets"
+
+	resp := serve(h, http.MethodPost, pathV1, `{"apiVersion":"versions.example.com/v1","kind":"Widget","metadata":{"name":"a"},"spec":{"color":"green"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	created := &v1.Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), created))
+
+	// the object created in v1 is read in v2
+	resp = serve(h, http.MethodGet, pathV2+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	w := &v2.Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), w))
+	assert.Equal(t, "versions.example.com/v2", w.APIVersion)
+	assert.Equal(t, v2.WidgetSpec{Colour: "green"}, w.Spec)
+	assert.Equal(t, created.UID, w.UID)
+	assert.Equal(t, created.ResourceVersion, w.ResourceVersion)
+
+	// the object created in v2 is converted to the storage version and back
+	resp = serve(h, http.MethodPost, pathV2, `{"apiVersion":"versions.example.com/v2","kind":"Widget","metadata":{"name":"b"},"spec":{"colour":"red"}}`)
+	require.Equal(t, http.StatusCreated, resp.Code, resp.Body.String())
+	w = &v2.Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), w))
+	assert.Equal(t, v2.WidgetSpec{Colour: "red"}, w.Spec)
+	resp = serve(h, http.MethodGet, pathV1+"/b", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	stored := &v1.Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stored))
+	assert.Equal(t, v1.WidgetSpec{Color: "red"}, stored.Spec)
+
+	// the items of the lists are converted
+	resp = serve(h, http.MethodGet, pathV2, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	list := &v2.WidgetList{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), list))
+	assert.Equal(t, "versions.example.com/v2", list.APIVersion)
+	require.Len(t, list.Items, 2)
+	assert.Equal(t, v2.WidgetSpec{Colour: "green"}, list.Items[0].Spec)
+	assert.Equal(t, v2.WidgetSpec{Colour: "red"}, list.Items[1].Spec)
+	resp = serve(h, http.MethodGet, pathV1, "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	listV1 := &v1.WidgetList{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), listV1))
+	assert.Len(t, listV1.Items, 2)
+	assert.Equal(t, list.ResourceVersion, listV1.ResourceVersion)
+
+	// the object updated in v2 is stored in v1
+	resp = serve(h, http.MethodPut, pathV2+"/b", fmt.Sprintf(
+		`{"apiVersion":"versions.example.com/v2","kind":"Widget","metadata":{"name":"b","resourceVersion":%q},"spec":{"colour":"blue"}}`,
+		w.ResourceVersion))
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodGet, pathV1+"/b", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	stored = &v1.Widget{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), stored))
+	assert.Equal(t, v1.WidgetSpec{Color: "blue"}, stored.Spec)
+
+	// the objects deleted in one version are gone in the other
+	resp = serve(h, http.MethodDelete, pathV2+"/a", "")
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+	resp = serve(h, http.MethodGet, pathV1+"/a", "")
+	assert.Equal(t, http.StatusNotFound, resp.Code, resp.Body.String())
+}
+
+func TestStorageVersionMigration(t *testing.T) {
+	// the widget persisted before the apiserver started is migrated, the memory store is not
+	root := t.TempDir()
+	dir := filepath.Join(root, "versions.example.com", "widgets", "default")
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.yaml"),
+		[]byte("apiVersion: versions.example.com/v1\nkind: Widget\nmetadata:\n  resourceVersion: \"1\"\nspec:\n  color: green\n"), 0o644))
+	h := newTestHandler(t, NewAPIServer().
+		WithStorageVersionMigration().
+		WithResourceAndHandler(&Widget{}, rest.NewMemoryStorageProvider(&Widget{})).
+		WithResourceAndHandler(&v1.Widget{}, rest.NewFileStorageProvider(&v1.Widget{}, rest.FileStorageOptions{RootPath: root})))
+
+	var versions []apiserver.StorageVersion
+	require.Eventually(t, func() bool {
+		resp := serve(h, http.MethodGet, apiserver.StorageVersionsPath, "")
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &versions))
+		// the resources are migrated in order, the memory store first
+		for _, version := range versions {
+			if version.Group == "versions.example.com" {
+				return version.Migration != nil && version.Migration.CompletionTime != nil
+			}
+		}
+		return false
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Len(t, versions, 2)
+	for _, version := range versions {
+		switch version.Group {
+		case "test.example.com":
+			assert.Nil(t, version.Migration)
+		case "versions.example.com":
+			assert.Equal(t, apiserver.StorageVersionMigrationSucceeded, version.Migration.State)
+			assert.Equal(t, 1, version.Migration.Migrated)
+			assert.Zero(t, version.Migration.Failed)
+		}
+	}
+}
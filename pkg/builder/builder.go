@@ -3,25 +3,34 @@ package builder
 import (
 	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/henderiw/apiserver-builder/pkg/apiserver"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
 	"github.com/henderiw/apiserver-builder/pkg/cmd/apiserverbuilder"
 	"github.com/henderiw/apiserver-builder/pkg/cmd/apiserverbuilder/options"
+	"github.com/henderiw/apiserver-builder/pkg/openapi"
+	"github.com/spf13/pflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/server"
+	openapicommon "k8s.io/kube-openapi/pkg/common"
 )
 
 // APIServer builds an apiserver to server Kubernetes resources and sub resources.
-var APIServer = &Server{
-	StorageProvider: map[schema.GroupResource]*SingletonProvider{},
-}
+var APIServer = NewAPIServer()
 
+// NewAPIServer returns a Server with its own scheme, codecs and APIs, so several servers can be built and run
+// in one process.
 func NewAPIServer() *Server {
 	return &Server{
 		StorageProvider: map[schema.GroupResource]*SingletonProvider{},
+		ExtraConfig:     apiserver.NewExtraConfig(),
 	}
 }
 
@@ -30,21 +39,64 @@ type Server struct {
 	ServerName           string
 	EtcdPath             string
 	errs                 []error
+	registrations        []registration
 	StorageProvider      map[schema.GroupResource]*SingletonProvider
 	groupVersions        map[schema.GroupVersion]bool
 	orderedGroupVersions []schema.GroupVersion
 	Schemes              []*runtime.Scheme
-	schemeBuilder        runtime.SchemeBuilder
+	// parameterSchemeBuilder installs the query parameter objects of the subresources
+	parameterSchemeBuilder runtime.SchemeBuilder
+	// ExtraConfig holds the scheme, the codecs and the APIs of the apiserver, the loopback configs are filled
+	// when the apiserver is created
+	ExtraConfig          apiserver.ExtraConfig
+	serverOptionsFns     []func(*ServerOptions) *ServerOptions
+	recommendedConfigFns []func(*server.RecommendedConfig) *server.RecommendedConfig
+	flagsFns             []func(*pflag.FlagSet) *pflag.FlagSet
+	// standaloneDebugMode is set by the --standalone-debug-mode flag, see WithLocalDebugExtension
+	standaloneDebugMode bool
+	// openAPIDefinitions are the definitions registered by WithOpenAPIDefinitions
+	openAPIDefinitions openapicommon.GetOpenAPIDefinitions
+	// admissionPlugins are the plugins registered by WithAdmissionPlugins
+	admissionPlugins []AdmissionPlugin
+	// validatingAdmissionPolicies is set by WithValidatingAdmissionPolicies
+	validatingAdmissionPolicies bool
+	// admissionPolicyDir is set by the --admission-policy-dir flag, see WithValidatingAdmissionPolicies
+	admissionPolicyDir string
+	// admissionConfigured is true once the admission of the Server is added to the apiserver, see withAdmission
+	admissionConfigured bool
 }
 
-// Build returns a Command used to run the apiserver
+// Build returns a Command used to run the apiserver, the validate-openapi and dump-openapi subcommands validate
+// and dump the OpenAPI documents of the apiserver without running it. Build returns a single error aggregating
+// every invalid registration, see validateRegistrations, validateAdmissionPlugins and validateSchemeRegistrations.
 func (r *Server) Build(ctx context.Context) (*Command, error) {
-	options.EtcdPath = r.EtcdPath
-	r.Schemes = append(r.Schemes, apiserver.Scheme)
-	r.schemeBuilder.Register(
+	// Build only fills the APIs of the Server, so the same Server can be built repeatedly
+	schemes := append(append([]*runtime.Scheme{}, r.Schemes...), r.ExtraConfig.Scheme)
+	// validate the registrations before installing them, conflicting registrations make the scheme panic
+	errList := append(append([]error{}, r.errs...), validateRegistrations(r.registrations)...)
+	errList = append(errList, validateAdmissionPlugins(r.admissionPlugins)...)
+	if len(errList) != 0 {
+		return nil, errs{list: errList}
+	}
+	// the versions of a group-resource share the storage of the storage version, the storage versions are
+	// added to the scheme first so their internal versions are registered before the conversions to them
+	schemeBuilder := runtime.SchemeBuilder{}
+	for _, storageVersion := range []bool{true, false} {
+		for _, reg := range r.registrations {
+			if reg.obj.IsStorageVersion() != storageVersion {
+				continue
+			}
+			r.ExtraConfig.APIs[reg.gvr()] = reg.sp
+			if singleton, found := r.StorageProvider[reg.gvr().GroupResource()]; found {
+				r.ExtraConfig.APIs[reg.gvr()] = singleton.ForVersion(reg.sp)
+			}
+			schemeBuilder.Register(resource.AddToScheme(reg.obj))
+		}
+	}
+	schemeBuilder.Register(
 		func(scheme *runtime.Scheme) error {
 			groupVersions := make(map[string]sets.Set[string])
-			for gvr := range apiserver.APIs {
+			for gvr := range r.ExtraConfig.APIs {
 				if groupVersions[gvr.Group] == nil {
 					groupVersions[gvr.Group] = sets.New[string]()
 				}
@@ -73,31 +125,73 @@ func (r *Server) Build(ctx context.Context) (*Command, error) {
 			return nil
 		},
 	)
-	for i := range r.Schemes {
-		if err := r.schemeBuilder.AddToScheme(r.Schemes[i]); err != nil {
-			panic(err)
+	for i := range schemes {
+		if err := addToScheme(schemeBuilder, schemes[i]); err != nil {
+			errList = append(errList, err)
 		}
 	}
-
-	// debug
-	/*
-		for _, scheme := range r.schemes {
-			for gvk, v := range scheme.AllKnownTypes() {
-				fmt.Println("scheme", "gvk", gvk, v)
-			}
-		}
-	*/
-
-	if len(r.errs) != 0 {
-		return nil, errs{list: r.errs}
+	if err := addToScheme(r.parameterSchemeBuilder, r.ExtraConfig.ParameterScheme); err != nil {
+		errList = append(errList, err)
+	}
+	if len(errList) == 0 {
+		errList = append(errList, validateSchemeRegistrations(r.ExtraConfig.Scheme, r.registrations)...)
+	}
+	errList = append(errList, r.compileValidationRules()...)
+	if len(errList) != 0 {
+		return nil, errs{list: errList}
 	}
-	o := options.NewServerOptions(os.Stdout, os.Stderr, r.orderedGroupVersions...)
+	o := options.NewServerOptions(os.Stdout, os.Stderr, r.ExtraConfig, r.EtcdPath, r.orderedGroupVersions...)
+	o.ServerOptionsFns = r.serverOptionsFns
+	o.RecommendedConfigFns = r.recommendedConfigFns
 	cmd := apiserverbuilder.NewCommandStartServer(ctx, r.ServerName, o)
-	options.ApplyFlagsFns(cmd.Flags())
+	for i := range r.flagsFns {
+		r.flagsFns[i](cmd.Flags())
+	}
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
+	cmd.AddCommand(
+		apiserverbuilder.NewCommandValidateOpenAPI(ctx, r.ServerName, o),
+		apiserverbuilder.NewCommandDumpOpenAPI(ctx, r.ServerName, o),
+	)
 	return cmd, nil
 }
 
+// compileValidationRules compiles the x-kubernetes-validations rules of the OpenAPI schemas of the storage versions,
+// the storage of a resource evaluates the rules of its storage version. An error is returned for every resource
+// with a rule that does not compile or exceeds the cost budget, or whose storage provider cannot evaluate the
+// rules, see rest.StorageProvider.ValidationRulesStorageFn.
+func (r *Server) compileValidationRules() []error {
+	errList := []error{}
+	r.ExtraConfig.ValidationRules = map[schema.GroupResource]*rest.ValidationRules{}
+	if r.openAPIDefinitions == nil {
+		return errList
+	}
+	for _, reg := range r.registrations {
+		if !reg.obj.IsStorageVersion() || strings.Contains(reg.gvr().Resource, "/") {
+			continue
+		}
+		s, err := openapi.StructuralSchema(r.openAPIDefinitions, reg.obj.New())
+		if err != nil {
+			errList = append(errList, fmt.Errorf("%s: %w", reg.gvr(), err))
+			continue
+		}
+		rules, err := rest.NewValidationRules(s)
+		if err != nil {
+			errList = append(errList, fmt.Errorf("%s: invalid x-kubernetes-validations rules: %w", reg.gvr(), err))
+			continue
+		}
+		if rules == nil {
+			continue
+		}
+		if reg.sp == nil || reg.sp.ValidationRulesStorageFn == nil {
+			errList = append(errList, fmt.Errorf("%s: x-kubernetes-validations rules require a storage provider with a ValidationRulesStorageFn, "+
+				"e.g. the builder storage providers", reg.gvr()))
+			continue
+		}
+		r.ExtraConfig.ValidationRules[reg.gvr().GroupResource()] = rules
+	}
+	return errList
+}
+
 // Execute builds and executes the apiserver Command.
 func (r *Server) Execute(ctx context.Context) error {
 	cmd, err := r.Build(ctx)
@@ -0,0 +1,192 @@
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/namespace/lifecycle"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/validating"
+	"k8s.io/apiserver/pkg/server"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+)
+
+// AdmissionFunc admits a request for a resource of the apiserver. obj is the object of a create or an update,
+// nil on delete, and old is the stored object of an update or a delete, nil on create. An error that is not
+// an API status error rejects the request as forbidden.
+type AdmissionFunc func(ctx context.Context, a admission.Attributes, obj, old resource.Object) error
+
+// AdmissionPlugin is an admission plugin run in-process by the apiserver.
+type AdmissionPlugin struct {
+	// Name is the name of the plugin in the --enable-admission-plugins and --disable-admission-plugins flags.
+	Name string
+	// Operations are the operations admitted by the plugin, all of them if empty.
+	Operations []admission.Operation
+	// Resources are the resources admitted by the plugin, every resource of the apiserver if empty.
+	Resources []schema.GroupResource
+	// Mutate may change obj, it is called before the validating plugins of the apiserver.
+	Mutate AdmissionFunc
+	// Validate must not change obj, it is called after the mutating plugins of the apiserver.
+	Validate AdmissionFunc
+	// DefaultOff disables the plugin unless it is enabled by the --enable-admission-plugins flag.
+	DefaultOff bool
+}
+
+// WithAdmissionPlugins registers the admission plugins with the admission options of the apiserver, the
+// plugins are called in the order they are registered after the NamespaceLifecycle plugin and before the
+// admission policies and webhooks, the mutating plugins before the validating plugins. The plugins are enabled
+// and disabled by the --enable-admission-plugins and --disable-admission-plugins flags, their names must differ
+// from the names of the plugins of the apiserver, e.g. NamespaceLifecycle. When the admission options are
+// disabled, e.g. by the --standalone-debug-mode flag of WithLocalDebugExtension, the plugins that are not
+// DefaultOff are still called.
+func (r *Server) WithAdmissionPlugins(plugins ...AdmissionPlugin) *Server {
+	r.admissionPlugins = append(r.admissionPlugins, plugins...)
+	r.withAdmission()
+	return r
+}
+
+// withAdmission adds the admission plugins and the admission policies of the Server to the admission of the
+// apiserver, the options and config functions are registered once and read the Server when they are applied.
+func (r *Server) withAdmission() {
+	if r.admissionConfigured {
+		return
+	}
+	r.admissionConfigured = true
+	r.serverOptionsFns = append(r.serverOptionsFns, func(o *ServerOptions) *ServerOptions {
+		if o.RecommendedOptions.Admission != nil {
+			registerAdmissionPlugins(o.RecommendedOptions.Admission, r.admissionPlugins)
+			if r.validatingAdmissionPolicies {
+				// the policies are evaluated by the Server against its own resources
+				o.RecommendedOptions.Admission.DefaultOffPlugins.Insert(validating.PluginName)
+			}
+		}
+		return o
+	})
+	r.recommendedConfigFns = append(r.recommendedConfigFns, func(config *server.RecommendedConfig) *server.RecommendedConfig {
+		handlers := []admission.Interface{}
+		if config.AdmissionControl != nil {
+			handlers = append(handlers, config.AdmissionControl)
+		} else {
+			// the admission chain is only nil if the admission options are disabled
+			for _, plugin := range r.admissionPlugins {
+				if !plugin.DefaultOff {
+					handlers = append(handlers, newAdmissionPlugin(plugin))
+				}
+			}
+		}
+		if r.validatingAdmissionPolicies {
+			policies := newAdmissionPolicies(r.admissionPolicyDir, r.ExtraConfig.Loopback)
+			config.AddPostStartHookOrDie("start-validating-admission-policies", policies.start)
+			handlers = append(handlers, policies)
+		}
+		config.AdmissionControl = admission.NewChainHandler(handlers...)
+		return config
+	})
+}
+
+// registerAdmissionPlugins registers the plugins with the admission options, the options are completed every
+// time the server options functions are applied so registering the plugins again leaves them unchanged. The
+// plugins do not share the names of the plugins of the apiserver, see validateAdmissionPlugins, a registered
+// name is a plugin registered before.
+func registerAdmissionPlugins(options *genericoptions.AdmissionOptions, plugins []AdmissionPlugin) {
+	names := []string{}
+	registered := sets.New(options.Plugins.Registered()...)
+	for _, plugin := range plugins {
+		names = append(names, plugin.Name)
+		if plugin.DefaultOff {
+			options.DefaultOffPlugins.Insert(plugin.Name)
+		}
+		if registered.Has(plugin.Name) {
+			continue
+		}
+		options.Plugins.Register(plugin.Name, func(io.Reader) (admission.Interface, error) {
+			return newAdmissionPlugin(plugin), nil
+		})
+	}
+	order := slices.DeleteFunc(slices.Clone(options.RecommendedPluginOrder), func(name string) bool {
+		return slices.Contains(names, name)
+	})
+	// the plugins are called after NamespaceLifecycle, it rejects the objects of the terminating namespaces
+	i := slices.Index(order, lifecycle.PluginName) + 1
+	options.RecommendedPluginOrder = slices.Insert(order, i, names...)
+}
+
+// validateAdmissionPlugins returns an error for every admission plugin without a name or a function, for every
+// name registered more than once and for every name of a plugin of the apiserver, e.g. NamespaceLifecycle.
+func validateAdmissionPlugins(plugins []AdmissionPlugin) []error {
+	errs := []error{}
+	names := sets.New[string]()
+	builtin := sets.New(genericoptions.NewAdmissionOptions().Plugins.Registered()...)
+	for i, plugin := range plugins {
+		switch {
+		case plugin.Name == "":
+			errs = append(errs, fmt.Errorf("admission plugin %d: no name", i))
+		case builtin.Has(plugin.Name):
+			errs = append(errs, fmt.Errorf("admission plugin %s: the name of an admission plugin of the apiserver", plugin.Name))
+		case names.Has(plugin.Name):
+			errs = append(errs, fmt.Errorf("admission plugin %s: registered more than once", plugin.Name))
+		case plugin.Mutate == nil && plugin.Validate == nil:
+			errs = append(errs, fmt.Errorf("admission plugin %s: neither Mutate nor Validate is set", plugin.Name))
+		}
+		names.Insert(plugin.Name)
+	}
+	return errs
+}
+
+var (
+	_ admission.MutationInterface   = &admissionPlugin{}
+	_ admission.ValidationInterface = &admissionPlugin{}
+)
+
+// admissionPlugin calls the functions of an AdmissionPlugin for the resource.Objects of its resources.
+type admissionPlugin struct {
+	*admission.Handler
+
+	plugin    AdmissionPlugin
+	resources sets.Set[schema.GroupResource]
+}
+
+func newAdmissionPlugin(plugin AdmissionPlugin) *admissionPlugin {
+	operations := plugin.Operations
+	if len(operations) == 0 {
+		operations = []admission.Operation{admission.Create, admission.Update, admission.Delete, admission.Connect}
+	}
+	return &admissionPlugin{
+		Handler:   admission.NewHandler(operations...),
+		plugin:    plugin,
+		resources: sets.New(plugin.Resources...),
+	}
+}
+
+func (p *admissionPlugin) Admit(ctx context.Context, a admission.Attributes, _ admission.ObjectInterfaces) error {
+	return p.admit(ctx, a, p.plugin.Mutate)
+}
+
+func (p *admissionPlugin) Validate(ctx context.Context, a admission.Attributes, _ admission.ObjectInterfaces) error {
+	return p.admit(ctx, a, p.plugin.Validate)
+}
+
+func (p *admissionPlugin) admit(ctx context.Context, a admission.Attributes, fn AdmissionFunc) error {
+	if fn == nil || (p.resources.Len() != 0 && !p.resources.Has(a.GetResource().GroupResource())) {
+		return nil
+	}
+	obj, _ := a.GetObject().(resource.Object)
+	old, _ := a.GetOldObject().(resource.Object)
+	// the options of a connect request and the objects of the subresources that are not resources are skipped
+	if obj == nil && old == nil {
+		return nil
+	}
+	if err := fn(ctx, a, obj, old); err != nil {
+		if _, ok := err.(apierrors.APIStatus); ok {
+			return err
+		}
+		return admission.NewForbidden(a, err)
+	}
+	return nil
+}
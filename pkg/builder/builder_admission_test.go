@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apiserver/pkg/admission"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+)
+
+func TestRegisterAdmissionPlugins(t *testing.T) {
+	validate := func(context.Context, admission.Attributes, resource.Object, resource.Object) error { return nil }
+	plugins := []AdmissionPlugin{
+		{Name: "First", Validate: validate},
+		{Name: "Second", Mutate: validate, DefaultOff: true},
+	}
+	options := genericoptions.NewAdmissionOptions()
+	registerAdmissionPlugins(options, plugins)
+	// the options are completed every time the server options functions are applied
+	registerAdmissionPlugins(options, plugins)
+
+	assert.Equal(t, []string{
+		"NamespaceLifecycle", "First", "Second", "MutatingAdmissionPolicy", "MutatingAdmissionWebhook",
+		"ValidatingAdmissionPolicy", "ValidatingAdmissionWebhook",
+	}, options.RecommendedPluginOrder)
+	assert.True(t, options.DefaultOffPlugins.Has("Second"))
+	assert.Empty(t, options.Validate())
+}
+
+func TestValidateAdmissionPlugins(t *testing.T) {
+	validate := func(context.Context, admission.Attributes, resource.Object, resource.Object) error { return nil }
+	errs := validateAdmissionPlugins([]AdmissionPlugin{
+		{Name: "First", Validate: validate},
+		{Validate: validate},
+		{Name: "First", Mutate: validate},
+		{Name: "Second"},
+		{Name: "NamespaceLifecycle", Validate: validate},
+		{Name: "ValidatingAdmissionWebhook", Mutate: validate},
+	})
+	assert.Len(t, errs, 5)
+	assert.ErrorContains(t, errs[0], "admission plugin 1: no name")
+	assert.ErrorContains(t, errs[1], "admission plugin First: registered more than once")
+	assert.ErrorContains(t, errs[2], "admission plugin Second: neither Mutate nor Validate is set")
+	assert.ErrorContains(t, errs[3], "admission plugin NamespaceLifecycle: the name of an admission plugin of the apiserver")
+	assert.ErrorContains(t, errs[4], "admission plugin ValidatingAdmissionWebhook: the name of an admission plugin of the apiserver")
+}
+
+func TestAdmissionPoliciesHandles(t *testing.T) {
+	policies := newAdmissionPolicies("", nil)
+	for _, operation := range []admission.Operation{admission.Create, admission.Update, admission.Delete, admission.Connect} {
+		assert.True(t, policies.Handles(operation), operation)
+	}
+	assert.False(t, policies.Handles(admission.Operation("WATCH")))
+}
@@ -0,0 +1,293 @@
+package builder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/henderiw/apiserver-builder/pkg/util/loopback"
+	"github.com/spf13/pflag"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/admission/plugin/policy/validating"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/authorization/authorizerfactory"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/ptr"
+)
+
+// WithValidatingAdmissionPolicies evaluates the ValidatingAdmissionPolicies and their bindings against the
+// resources of the apiserver, after its admission plugins. The policies and their param resources are read from
+// the master kube-apiserver with the loopback master client config. When the --admission-policy-dir flag is set,
+// e.g. in standalone mode, the policies are read from the YAML and JSON files of the directory when the apiserver
+// starts and their param resources are read from the apiserver itself. The generic ValidatingAdmissionPolicy
+// plugin of the admission options is off by default so the policies are evaluated once.
+func (r *Server) WithValidatingAdmissionPolicies() *Server {
+	if !r.validatingAdmissionPolicies {
+		r.validatingAdmissionPolicies = true
+		r.flagsFns = append(r.flagsFns, func(fs *pflag.FlagSet) *pflag.FlagSet {
+			fs.StringVar(&r.admissionPolicyDir, "admission-policy-dir", r.admissionPolicyDir,
+				"The directory the ValidatingAdmissionPolicies and ValidatingAdmissionPolicyBindings are read from "+
+					"rather than from the master kube-apiserver, e.g. when the apiserver runs standalone.")
+			return fs
+		})
+	}
+	r.withAdmission()
+	return r
+}
+
+var _ admission.ValidationInterface = &admissionPolicies{}
+
+// admissionPolicies evaluates the ValidatingAdmissionPolicies with the generic plugin once it is started by the
+// post start hook, the clients of the plugin are created from the loopback configs filled when the apiserver is
+// created. It handles the operations of the generic plugin and is ready once the plugin is started, the requests
+// wait for it like they wait for the informers of the plugin to sync.
+type admissionPolicies struct {
+	*admission.Handler
+
+	// dir is the directory of the policies, they are read from the master kube-apiserver if empty
+	dir      string
+	loopback *loopback.Config
+	plugin   atomic.Pointer[validating.Plugin]
+}
+
+func newAdmissionPolicies(dir string, loopback *loopback.Config) *admissionPolicies {
+	p := &admissionPolicies{
+		Handler:  admission.NewHandler(admission.Connect, admission.Create, admission.Delete, admission.Update),
+		dir:      dir,
+		loopback: loopback,
+	}
+	p.SetReadyFunc(func() bool { return p.plugin.Load() != nil })
+	return p
+}
+
+func (p *admissionPolicies) Validate(ctx context.Context, a admission.Attributes, o admission.ObjectInterfaces) error {
+	if !p.WaitForReady() {
+		return admission.NewForbidden(a, fmt.Errorf("not yet ready to handle request"))
+	}
+	return p.plugin.Load().Validate(ctx, a, o)
+}
+
+// start initializes the plugin and starts its informers, they are stopped with the apiserver. The param resources
+// of the policies are read with the client config the policies are read with, the loopback client config of the
+// apiserver itself when they are read from a directory.
+func (p *admissionPolicies) start(ctx server.PostStartHookContext) error {
+	var client kubernetes.Interface
+	var factory informers.SharedInformerFactory
+	var clientConfig *restclient.Config
+	if p.dir != "" {
+		policies, bindings, err := readAdmissionPolicies(p.dir)
+		if err != nil {
+			return err
+		}
+		clientConfig = ctx.LoopbackClientConfig
+		loopbackClient, err := kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			return err
+		}
+		client = admissionPolicyClient{Interface: loopbackClient}
+		factory = informers.NewSharedInformerFactory(client, 0)
+		// the informers of the factory are created once per type, the plugin gets the static informers
+		factory.InformerFor(&admissionregistrationv1.ValidatingAdmissionPolicy{}, newStaticInformer(&admissionregistrationv1.ValidatingAdmissionPolicy{}, policies))
+		factory.InformerFor(&admissionregistrationv1.ValidatingAdmissionPolicyBinding{}, newStaticInformer(&admissionregistrationv1.ValidatingAdmissionPolicyBinding{}, bindings))
+		factory.InformerFor(&corev1.Namespace{}, newStaticInformer(&corev1.Namespace{}, &corev1.NamespaceList{}))
+	} else {
+		if p.loopback == nil || p.loopback.MasterClientConfig == nil {
+			return fmt.Errorf("the admission policies are read from the master kube-apiserver but it has no client config, " +
+				"set --admission-policy-dir to read them from a directory")
+		}
+		clientConfig = p.loopback.MasterClientConfig
+		var err error
+		if client, err = kubernetes.NewForConfig(clientConfig); err != nil {
+			return err
+		}
+		factory = informers.NewSharedInformerFactory(client, 0)
+	}
+	dynamicClient, err := dynamic.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+	// the REST mapper maps the param kinds of the policies to their resources
+	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	go wait.Until(restMapper.Reset, 30*time.Second, ctx.Done())
+
+	var authz authorizer.Authorizer = authorizerfactory.NewAlwaysAllowAuthorizer()
+	if p.loopback != nil && p.loopback.Authorizer != nil {
+		authz = p.loopback.Authorizer
+	}
+	plugin := validating.NewPlugin(nil)
+	plugin.SetExternalKubeInformerFactory(factory)
+	plugin.SetExternalKubeClientSet(client)
+	plugin.SetDynamicClient(dynamicClient)
+	plugin.SetRESTMapper(restMapper)
+	plugin.SetAuthorizer(authz)
+	plugin.SetDrainedNotification(ctx.Done())
+	if err := plugin.ValidateInitialization(); err != nil {
+		return fmt.Errorf("unable to initialize the validating admission policies: %w", err)
+	}
+	factory.Start(ctx.Done())
+	p.plugin.Store(plugin)
+	return nil
+}
+
+// readAdmissionPolicies returns the ValidatingAdmissionPolicies and ValidatingAdmissionPolicyBindings of the
+// YAML and JSON files of the directory with their defaults, a file may hold several YAML documents.
+func readAdmissionPolicies(dir string) (*admissionregistrationv1.ValidatingAdmissionPolicyList, *admissionregistrationv1.ValidatingAdmissionPolicyBindingList, error) {
+	policies := &admissionregistrationv1.ValidatingAdmissionPolicyList{}
+	bindings := &admissionregistrationv1.ValidatingAdmissionPolicyBindingList{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch ext := filepath.Ext(path); {
+		case d.IsDir(), strings.HasPrefix(d.Name(), "."), ext != ".yaml" && ext != ".yml" && ext != ".json":
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("cannot read %s: %w", path, err)
+			}
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(doc, nil, nil)
+			if err != nil {
+				return fmt.Errorf("cannot decode %s: %w", path, err)
+			}
+			switch obj := obj.(type) {
+			case *admissionregistrationv1.ValidatingAdmissionPolicy:
+				if obj.Spec.FailurePolicy == nil {
+					obj.Spec.FailurePolicy = ptr.To(admissionregistrationv1.Fail)
+				}
+				defaultMatchResources(obj.Spec.MatchConstraints)
+				policies.Items = append(policies.Items, *obj)
+			case *admissionregistrationv1.ValidatingAdmissionPolicyBinding:
+				defaultMatchResources(obj.Spec.MatchResources)
+				bindings.Items = append(bindings.Items, *obj)
+			default:
+				return fmt.Errorf("%s: %s is neither a ValidatingAdmissionPolicy nor a ValidatingAdmissionPolicyBinding", path, gvk)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return policies, bindings, nil
+}
+
+// defaultMatchResources sets the defaults of the match resources of a policy or a binding read from a file, the
+// kube-apiserver sets them when the policy or the binding is created.
+func defaultMatchResources(m *admissionregistrationv1.MatchResources) {
+	if m == nil {
+		return
+	}
+	if m.MatchPolicy == nil {
+		m.MatchPolicy = ptr.To(admissionregistrationv1.Equivalent)
+	}
+	if m.NamespaceSelector == nil {
+		m.NamespaceSelector = &metav1.LabelSelector{}
+	}
+	if m.ObjectSelector == nil {
+		m.ObjectSelector = &metav1.LabelSelector{}
+	}
+	for _, rules := range [][]admissionregistrationv1.NamedRuleWithOperations{m.ResourceRules, m.ExcludeResourceRules} {
+		for i := range rules {
+			if rules[i].Scope == nil {
+				rules[i].Scope = ptr.To(admissionregistrationv1.AllScopes)
+			}
+		}
+	}
+}
+
+// newStaticInformer returns the informer of the objects of the list, the objects never change.
+func newStaticInformer(example, list runtime.Object) func(kubernetes.Interface, time.Duration) cache.SharedIndexInformer {
+	return func(_ kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+		return cache.NewSharedIndexInformer(staticListWatch{list: list}, example, resync, indexers)
+	}
+}
+
+// staticListWatch lists the objects of a list that never changes, its watches return no events.
+type staticListWatch struct {
+	list runtime.Object
+}
+
+func (lw staticListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	return lw.list.DeepCopyObject(), nil
+}
+
+func (lw staticListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewProxyWatcher(make(chan watch.Event)), nil
+}
+
+// IsWatchListSemanticsUnSupported makes the reflectors list the objects rather than stream them with a watch.
+func (lw staticListWatch) IsWatchListSemanticsUnSupported() bool {
+	return true
+}
+
+// admissionPolicyClient is the client of the plugin when the policies are read from a directory, the namespaces
+// are not stored so every namespace exists with the kubernetes.io/metadata.name label of its name.
+type admissionPolicyClient struct {
+	kubernetes.Interface
+}
+
+func (c admissionPolicyClient) CoreV1() corev1client.CoreV1Interface {
+	return admissionPolicyCoreV1{CoreV1Interface: c.Interface.CoreV1()}
+}
+
+type admissionPolicyCoreV1 struct {
+	corev1client.CoreV1Interface
+}
+
+func (c admissionPolicyCoreV1) Namespaces() corev1client.NamespaceInterface {
+	return admissionPolicyNamespaces{NamespaceInterface: c.CoreV1Interface.Namespaces()}
+}
+
+type admissionPolicyNamespaces struct {
+	corev1client.NamespaceInterface
+}
+
+func (c admissionPolicyNamespaces) Get(ctx context.Context, name string, options metav1.GetOptions) (*corev1.Namespace, error) {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   name,
+		Labels: map[string]string{corev1.LabelMetadataName: name},
+	}}, nil
+}
@@ -1,42 +1,68 @@
 package builder
 
 import (
-	"github.com/henderiw/apiserver-builder/pkg/apiserver"
+	"strings"
+
 	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
 	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-/*
-func (r *Server) WithResource(obj resource.Object) *Server {
-	gvr := obj.GetGroupVersionResource()
-	r.schemeBuilder.Register(resource.AddToScheme(obj))
-
-	var sh rest.ResourceStorageHandler
-	sh.ResourceStorageProviderFn = rest.NewEtcdProviderFn(obj)
-
-	if _, ok := obj.(resource.ObjectWithStatusSubResource); ok {
-		sh.StatusSubResourceStorageProviderFn = rest.NewEtcdStatusProviderFn(obj, sh.ResourceStorageProviderFn)
+// WithResource registers the resource with the apiserver using the default etcd backend storage.
+//
+// Note: the versions of a GroupResource share the storage of the version returning true for IsStorageVersion,
+// the other versions must implement MultiVersionObject or resourcestrategy.Converter and are registered
+// without a storage of their own.
+//
+// Note: WithResource will register the "status" subresource if the resource implements
+// ObjectWithStatusSubResource and the arbitrary subresources if the resource implements
+// ObjectWithArbitrarySubResource.
+func (r *Server) WithResource(obj resource.InternalObject) *Server {
+	if !obj.IsStorageVersion() {
+		return r.WithResourceAndStorageProvider(obj, &rest.StorageProvider{})
 	}
+	return r.WithResourceAndStorageProvider(obj, rest.NewEtcdStorageProvider(obj))
+}
 
-	//
-
-	return r.forGroupVersionResource(gvr, sh)
+// WithResourceAndStorageProvider registers the resource with the apiserver using the storage of the
+// StorageProvider, e.g. rest.NewMemoryStorageProvider or rest.NewFileStorageProvider.
+//
+// Note: the versions of a GroupResource share the storage of the version returning true for IsStorageVersion,
+// the StorageProvider of the other versions only provides their arbitrary subresources, its
+// ResourceStorageProviderFn must be nil.
+//
+// Note: WithResourceAndStorageProvider will register the "status" subresource if the resource implements
+// ObjectWithStatusSubResource and the arbitrary subresources if the resource implements
+// ObjectWithArbitrarySubResource, unless the StorageProvider already provides them.
+func (r *Server) WithResourceAndStorageProvider(obj resource.InternalObject, sp *rest.StorageProvider) *Server {
+	if statusObj, ok := obj.(resource.ObjectWithStatusSubResource); ok && sp.StatusSubResourceStorageProviderFn == nil {
+		sp.StatusSubResourceStorageProviderFn = rest.NewStatusSubResourceStorageProviderFn(statusObj)
+	}
+	if arbObj, ok := obj.(resource.ObjectWithArbitrarySubResource); ok {
+		if sp.ArbitrarySubresourceHandlerProviders == nil {
+			sp.ArbitrarySubresourceHandlerProviders = map[string]rest.SubResourceStorageProviderFn{}
+		}
+		for _, sub := range arbObj.GetArbitrarySubResources() {
+			if _, found := sp.ArbitrarySubresourceHandlerProviders[sub.SubResourceName()]; !found {
+				sp.ArbitrarySubresourceHandlerProviders[sub.SubResourceName()] = sub.NewStorage
+			}
+		}
+	}
+	return r.WithResourceAndHandler(obj, sp)
 }
-*/
 
 // WithResourceAndHandler registers a request handler for the resource rather than the default
 // etcd backend storage.
 //
-// Note: WithResourceAndHandler should never be called after the GroupResource has already been registered with
-// another version.
+// Note: the versions of a GroupResource share the storage of the version returning true for IsStorageVersion,
+// the other versions are converted to and from its internal version, see SingletonProvider. The
+// StorageProvider of the other versions has no ResourceStorageProviderFn.
 //
 // Note: WithResourceAndHandler will NOT register the "status" subresource for the resource object.
 func (r *Server) WithResourceAndHandler(obj resource.Object, sp *rest.StorageProvider) *Server {
-	gvr := obj.GetGroupVersionResource()
-	r.schemeBuilder.Register(resource.AddToScheme(obj))
-	return r.forGroupVersionResource(gvr, sp)
+	r.parameterSchemeBuilder.Register(resource.AddToParameterScheme(obj))
+	return r.forGroupVersionResource(obj, sp)
 }
 
 // WithSchemeInstallers registers functions to install resource types into the Scheme.
@@ -59,21 +85,26 @@ func (a *Server) withGroupVersions(
 	return a
 }
 
-// forGroupVersionResource manually registers storage for a specific resource.
-func (a *Server) forGroupVersionResource(gvr schema.GroupVersionResource, sp *rest.StorageProvider) *Server {
+// forGroupVersionResource manually registers storage for a specific resource. The registrations are
+// validated and added to the APIs by Build, see validateRegistrations.
+func (a *Server) forGroupVersionResource(obj resource.Object, sp *rest.StorageProvider) *Server {
+	gvr := obj.GetGroupVersionResource()
 	// register the group version
 	a.withGroupVersions(gvr.GroupVersion())
+	a.registrations = append(a.registrations, registration{obj: obj, sp: sp})
 
-	// TODO: make sure folks don't register multiple storageProvider instance for the same group-resource
-	// don't replace the existing instance otherwise it will chain wrapped singletonProviders when
-	// fetching from the map before calling this function
-	if _, found := a.StorageProvider[gvr.GroupResource()]; !found {
-		a.StorageProvider[gvr.GroupResource()] = &SingletonProvider{Provider: sp}
+	if strings.Contains(gvr.Resource, "/") {
+		return a
+	}
+	// the versions of the group-resource share the storage of the storage version
+	singleton, found := a.StorageProvider[gvr.GroupResource()]
+	if !found {
+		singleton = &SingletonProvider{Provider: sp}
+		a.StorageProvider[gvr.GroupResource()] = singleton
+	}
+	if obj.IsStorageVersion() {
+		singleton.Provider = sp
 	}
-
-	//fmt.Println("forGroupVersionResource", gvr.String(), sp)
-	// add the API with its storageProvider
-	apiserver.APIs[gvr] = sp
 	return a
 }
 
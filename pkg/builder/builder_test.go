@@ -0,0 +1,27 @@
+package builder
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestBuildServers(t *testing.T) {
+	newServer := func(group string) *Server {
+		obj := &testObject{gvr: schema.GroupVersionResource{Group: group, Version: "v1", Resource: "foos"}, storage: true}
+		return NewAPIServer().WithResourceAndHandler(obj, &rest.StorageProvider{ResourceStorageProviderFn: storageProviderA})
+	}
+	a, b := newServer("a.example.com"), newServer("b.example.com")
+	// the same server can be built repeatedly and the servers do not share their scheme and APIs
+	for _, s := range []*Server{a, a, b} {
+		_, err := s.Build(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.True(t, a.ExtraConfig.Scheme.Recognizes(schema.GroupVersionKind{Group: "a.example.com", Version: "v1", Kind: "testObject"}))
+	assert.False(t, a.ExtraConfig.Scheme.Recognizes(schema.GroupVersionKind{Group: "b.example.com", Version: "v1", Kind: "testObject"}))
+	assert.Len(t, a.ExtraConfig.APIs, 1)
+	assert.Len(t, b.ExtraConfig.APIs, 1)
+}
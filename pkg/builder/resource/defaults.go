@@ -0,0 +1,92 @@
+package resource
+
+import (
+	"reflect"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcestrategy"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var defaulterType = reflect.TypeOf((*resourcestrategy.Defaulter)(nil)).Elem()
+
+// addDefaultingFuncs registers the defaulting functions of the object and its list, they call Default on
+// the object, its nested values and the items of the list that implement resourcestrategy.Defaulter. The
+// scheme applies the defaulting functions when a request or a stored object is decoded into the version.
+func addDefaultingFuncs(s *runtime.Scheme, obj Object) {
+	defaulters := map[reflect.Type]bool{}
+	for _, o := range []runtime.Object{obj.New(), obj.NewList()} {
+		if hasDefaulter(reflect.TypeOf(o), defaulters) {
+			s.AddTypeDefaultingFunc(o, func(o interface{}) {
+				setDefaults(reflect.ValueOf(o), false, defaulters)
+			})
+		}
+	}
+}
+
+// hasDefaulter returns true if a pointer to the type or to one of its nested types implements
+// resourcestrategy.Defaulter, the results are cached in defaulters.
+func hasDefaulter(t reflect.Type, defaulters map[reflect.Type]bool) bool {
+	if found, ok := defaulters[t]; ok {
+		return found
+	}
+	// a recursive type has a defaulter if one of its other nested types has one
+	defaulters[t] = false
+	found := reflect.PointerTo(t).Implements(defaulterType)
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		found = found || hasDefaulter(t.Elem(), defaulters)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() && hasDefaulter(t.Field(i).Type, defaulters) {
+				found = true
+			}
+		}
+	}
+	defaulters[t] = found
+	return found
+}
+
+// setDefaults calls Default on the value before its nested values. A struct embedding a Defaulter is
+// defaulted by its own Default method, the Default method of the embedded field is not called again. The
+// defaulters are only read, they are filled by hasDefaulter when the defaulting functions are registered.
+func setDefaults(v reflect.Value, promoted bool, defaulters map[reflect.Type]bool) {
+	if !defaulters[v.Type()] {
+		return
+	}
+	if v.Kind() == reflect.Pointer {
+		if !v.IsNil() {
+			setDefaults(v.Elem(), false, defaulters)
+		}
+		return
+	}
+	isDefaulter := false
+	if v.CanAddr() {
+		if d, ok := v.Addr().Interface().(resourcestrategy.Defaulter); ok {
+			isDefaulter = true
+			if !promoted {
+				d.Default()
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.IsExported() {
+				setDefaults(v.Field(i), isDefaulter && field.Anonymous, defaulters)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			setDefaults(v.Index(i), false, defaulters)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// the values of a map are not addressable, they are defaulted in a copy
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			setDefaults(value, false, defaulters)
+			v.SetMapIndex(iter.Key(), value)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var widgetGroup = "test.io"
+
+type widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              widgetSpec `json:"spec"`
+}
+
+type widgetSpec struct {
+	Color string                `json:"color,omitempty"`
+	Ports []widgetPort          `json:"ports,omitempty"`
+	Named map[string]widgetPort `json:"named,omitempty"`
+	Main  *widgetPort           `json:"main,omitempty"`
+}
+
+type widgetPort struct {
+	Protocol string `json:"protocol,omitempty"`
+}
+
+type widgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []widget `json:"items"`
+}
+
+func (w *widget) Default() {
+	if w.Spec.Color == "" {
+		w.Spec.Color = "blue"
+	}
+}
+
+func (p *widgetPort) Default() {
+	if p.Protocol == "" {
+		p.Protocol = "TCP"
+	}
+}
+
+func (w *widget) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Spec.Ports = append([]widgetPort(nil), w.Spec.Ports...)
+	return &c
+}
+func (w *widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *widget) NamespaceScoped() bool             { return true }
+func (w *widget) New() runtime.Object               { return &widget{} }
+func (w *widget) NewList() runtime.Object           { return &widgetList{} }
+func (w *widget) IsStorageVersion() bool            { return true }
+func (w *widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: widgetGroup, Version: "v1", Resource: "widgets"}
+}
+
+func (l *widgetList) DeepCopyObject() runtime.Object {
+	c := *l
+	c.Items = nil
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*widget))
+	}
+	return &c
+}
+
+// widgetV2 is the v2 version of the widget, it has its own defaults.
+type widgetV2 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              widgetSpec `json:"spec"`
+}
+
+type widgetV2List struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []widgetV2 `json:"items"`
+}
+
+func (w *widgetV2) Default() {
+	if w.Spec.Color == "" {
+		w.Spec.Color = "red"
+	}
+}
+
+func (w *widgetV2) DeepCopyObject() runtime.Object {
+	c := *w
+	w.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	c.Spec.Ports = append([]widgetPort(nil), w.Spec.Ports...)
+	return &c
+}
+func (w *widgetV2) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *widgetV2) NamespaceScoped() bool             { return true }
+func (w *widgetV2) New() runtime.Object               { return &widgetV2{} }
+func (w *widgetV2) NewList() runtime.Object           { return &widgetV2List{} }
+func (w *widgetV2) IsStorageVersion() bool            { return false }
+func (w *widgetV2) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: widgetGroup, Version: "v2", Resource: "widgets"}
+}
+func (w *widgetV2) ConvertToInternal() interface{} {
+	c := w.DeepCopyObject().(*widgetV2)
+	return &widget{ObjectMeta: c.ObjectMeta, Spec: c.Spec}
+}
+func (w *widgetV2) ConvertFromInternal(internal interface{}) {
+	c := internal.(*widget).DeepCopyObject().(*widget)
+	w.ObjectMeta, w.Spec = c.ObjectMeta, c.Spec
+}
+
+func (l *widgetV2List) DeepCopyObject() runtime.Object {
+	c := *l
+	c.Items = nil
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*widgetV2))
+	}
+	return &c
+}
+
+func TestAddToSchemeDefaulting(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, AddToScheme(&widget{}, &widgetV2{})(scheme))
+	codecs := serializer.NewCodecFactory(scheme)
+	v1 := schema.GroupVersion{Group: widgetGroup, Version: "v1"}
+	v2 := schema.GroupVersion{Group: widgetGroup, Version: "v2"}
+
+	// the objects are decoded in the version of the request, the kinds of the versions differ in this test
+	cases := map[string]struct {
+		version schema.GroupVersion
+		data    string
+		want    widgetSpec
+	}{
+		"nested values of the storage version": {
+			version: v1,
+			data:    `{"apiVersion":"test.io/v1","kind":"widget","spec":{"ports":[{},{"protocol":"UDP"}],"named":{"a":{}},"main":{}}}`,
+			want: widgetSpec{
+				Color: "blue",
+				Ports: []widgetPort{{Protocol: "TCP"}, {Protocol: "UDP"}},
+				Named: map[string]widgetPort{"a": {Protocol: "TCP"}},
+				Main:  &widgetPort{Protocol: "TCP"},
+			},
+		},
+		"other version": {
+			version: v2,
+			data:    `{"apiVersion":"test.io/v2","kind":"widgetV2","spec":{"ports":[{}]}}`,
+			want:    widgetSpec{Color: "red", Ports: []widgetPort{{Protocol: "TCP"}}},
+		},
+		"defaults are not overwritten": {
+			version: v2,
+			data:    `{"apiVersion":"test.io/v2","kind":"widgetV2","spec":{"color":"green"}}`,
+			want:    widgetSpec{Color: "green"},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			obj, _, err := codecs.UniversalDecoder(tc.version).Decode([]byte(tc.data), nil, nil)
+			require.NoError(t, err)
+			switch obj := obj.(type) {
+			case *widget:
+				assert.Equal(t, tc.want, obj.Spec)
+			case *widgetV2:
+				assert.Equal(t, tc.want, obj.Spec)
+			default:
+				t.Fatalf("unexpected type %T", obj)
+			}
+		})
+	}
+
+	obj, _, err := codecs.UniversalDecoder(v2).Decode([]byte(`{"apiVersion":"test.io/v2","kind":"widgetV2List","items":[{"spec":{}}]}`), nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &widgetV2List{}, obj)
+	assert.Equal(t, "red", obj.(*widgetV2List).Items[0].Spec.Color)
+}
@@ -5,19 +5,29 @@ import (
 	"net/url"
 	"reflect"
 
-	"github.com/henderiw/apiserver-builder/pkg/apiserver"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcerest"
 	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcestrategy"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/util"
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 )
 
 // AddToScheme returns a function to add the Objects to the scheme.
 //
 // AddToScheme will register the objects returned by New and NewList under the GroupVersion for each object.
 // AddToScheme will also register the objects under the "__internal" group version for each object that
-// returns true for IsStorageVersion.
-// AddToScheme will register the defaulting function if it implements the Defaulter inteface.
+// returns true for IsStorageVersion. The other objects are converted to and from the internal version with
+// the conversions registered by MultiVersionObject or, if the object implements resourcestrategy.Converter,
+// with ConvertToInternal and ConvertFromInternal, the internal version must be registered first.
+// AddToScheme will register the defaulting functions of the object and its list if the object, its nested types or
+// the items of the list implement the Defaulter interface, so every served version is defaulted on decode.
+// AddToScheme will register the field label conversion function of the object if it implements
+// InternalObject, ObjectWithSelectableFields or FieldsIndexer, so the selectable and indexing fields are
+// accepted in field selectors.
 func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 	return func(s *runtime.Scheme) error {
 		for i := range objs {
@@ -28,19 +38,20 @@ func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 					Group:   obj.GetGroupVersionResource().Group,
 					Version: runtime.APIVersionInternal,
 				}, obj.New(), obj.NewList())
-			} else {
-				multiVersionObj, ok := obj.(MultiVersionObject)
-				if !ok {
-					return fmt.Errorf("resource should implement MultiVersionObject if it's not storage-version")
-				}
+			} else if multiVersionObj, ok := obj.(MultiVersionObject); ok {
 				if err := multiVersionObj.RegisterConversions()(s); err != nil {
 					return err
 				}
+			} else if _, ok := obj.New().(resourcestrategy.Converter); ok {
+				if err := addConverterConversions(s, obj); err != nil {
+					return err
+				}
+			} else {
+				return fmt.Errorf("resource should implement MultiVersionObject or resourcestrategy.Converter if it's not storage-version")
 			}
-			if _, ok := obj.(resourcestrategy.Defaulter); ok {
-				s.AddTypeDefaultingFunc(obj, func(o interface{}) {
-					o.(resourcestrategy.Defaulter).Default()
-				})
+			addDefaultingFuncs(s, obj)
+			if err := addFieldLabelConversionFunc(s, obj); err != nil {
+				return err
 			}
 			// register subresources
 			if objWithStatus, ok := obj.(ObjectWithStatusSubResource); ok {
@@ -65,29 +76,14 @@ func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 					}
 					// Register options type if the subresource uses GetterWithOptions
 					if subWithOpts, ok := sub.(ArbitrarySubResourceWithOptions); ok {
-						opts := subWithOpts.NewGetOptions()
-						if opts != nil {
+						if opts := subWithOpts.NewGetOptions(); opts != nil {
+							s.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), opts)
+						}
+					}
+					// Register connect options type if the subresource is a connector
+					if connector, ok := sub.(ConnectorSubResource); ok {
+						if opts, _, _ := connector.NewConnectOptions(); opts != nil {
 							s.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), opts)
-							apiserver.ParameterScheme.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), opts)
-
-							// Register url.Values → options conversion
-							if converter, ok := sub.(ArbitrarySubResourceWithOptionsConverter); ok {
-								if err := apiserver.ParameterScheme.AddConversionFunc(
-									(*url.Values)(nil),
-									opts,
-									converter.ConvertFromURLValues(),
-								); err != nil {
-									return err
-								}
-								// Register version conversion for ParameterScheme
-								if versionConverter, ok := sub.(ArbitrarySubResourceWithVersionConverter); ok {
-									for _, convFn := range versionConverter.ParameterSchemeConversions() {
-										if err := convFn(apiserver.ParameterScheme); err != nil {
-											return err
-										}
-									}
-								}
-							}
 						}
 					}
 				}
@@ -97,3 +93,217 @@ func AddToScheme(objs ...Object) func(s *runtime.Scheme) error {
 		return nil
 	}
 }
+
+// addConverterConversions registers the conversions of the object and its list to and from the internal
+// version with the resourcestrategy.Converter of the object, the lists are converted item by item.
+func addConverterConversions(s *runtime.Scheme, obj Object) error {
+	internalGVK, err := internalKind(s, obj)
+	if err != nil {
+		return err
+	}
+	internalListGVK := internalGVK.GroupVersion().WithKind(internalGVK.Kind + "List")
+	newInternal := func() runtime.Object {
+		internal, _ := s.New(internalGVK)
+		return internal
+	}
+	internalList, err := s.New(internalListGVK)
+	if err != nil {
+		return err
+	}
+	if err := s.AddConversionFunc(obj.New(), newInternal(), func(a, b interface{}, scope conversion.Scope) error {
+		internal, ok := a.(resourcestrategy.Converter).ConvertToInternal().(runtime.Object)
+		if !ok {
+			return fmt.Errorf("ConvertToInternal of %T must return a runtime.Object", a)
+		}
+		return util.DeepCopy(internal, b.(runtime.Object))
+	}); err != nil {
+		return err
+	}
+	if err := s.AddConversionFunc(newInternal(), obj.New(), func(a, b interface{}, scope conversion.Scope) error {
+		b.(resourcestrategy.Converter).ConvertFromInternal(a)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := s.AddConversionFunc(obj.NewList(), internalList, convertListFunc(newInternal)); err != nil {
+		return err
+	}
+	return s.AddConversionFunc(internalList, obj.NewList(), convertListFunc(obj.New))
+}
+
+// internalKind returns the kind of the internal version returned by ConvertToInternal, the internal version
+// is registered by the storage version of the resource together with its list, e.g. Foo and FooList.
+func internalKind(s *runtime.Scheme, obj Object) (schema.GroupVersionKind, error) {
+	internal, ok := obj.New().(resourcestrategy.Converter).ConvertToInternal().(runtime.Object)
+	if !ok {
+		return schema.GroupVersionKind{}, fmt.Errorf("ConvertToInternal of %T must return a runtime.Object", obj.New())
+	}
+	gvks, _, _ := s.ObjectKinds(internal)
+	for _, gvk := range gvks {
+		if gvk.Group == obj.GetGroupVersionResource().Group && gvk.Version == runtime.APIVersionInternal {
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("the storage version of %s must be registered before its other versions, "+
+		"%T is not registered for the internal version", obj.GetGroupVersionResource().GroupResource(), internal)
+}
+
+// convertListFunc returns a conversion of a list converting the items to the objects returned by newItem.
+func convertListFunc(newItem func() runtime.Object) conversion.ConversionFunc {
+	return func(a, b interface{}, scope conversion.Scope) error {
+		items, err := meta.ExtractList(a.(runtime.Object))
+		if err != nil {
+			return err
+		}
+		converted := make([]runtime.Object, 0, len(items))
+		for _, item := range items {
+			out := newItem()
+			if err := scope.Convert(item, out); err != nil {
+				return err
+			}
+			converted = append(converted, out)
+		}
+		in, err := meta.ListAccessor(a)
+		if err != nil {
+			return err
+		}
+		out, err := meta.ListAccessor(b)
+		if err != nil {
+			return err
+		}
+		out.SetResourceVersion(in.GetResourceVersion())
+		out.SetContinue(in.GetContinue())
+		out.SetRemainingItemCount(in.GetRemainingItemCount())
+		return meta.SetList(b.(runtime.Object), converted)
+	}
+}
+
+// addFieldLabelConversionFunc registers the field label conversion function of the InternalObject or, if the
+// object does not provide one, a conversion accepting the selectable fields of ObjectWithSelectableFields and
+// the indexing fields of FieldsIndexer.
+func addFieldLabelConversionFunc(s *runtime.Scheme, obj Object) error {
+	var fn runtime.FieldLabelConversionFunc
+	if internalObj, ok := obj.(InternalObject); ok {
+		fn = internalObj.FieldLabelConversion()
+	}
+	if fn == nil {
+		fn = selectableFieldsLabelConversionFunc(obj)
+	}
+	if fn == nil {
+		return nil
+	}
+	gvks, _, err := s.ObjectKinds(obj.New())
+	if err != nil {
+		return err
+	}
+	for _, gvk := range gvks {
+		if gvk.GroupVersion() == obj.GetGroupVersionResource().GroupVersion() {
+			if err := s.AddFieldLabelConversionFunc(gvk, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectableFieldsLabelConversionFunc returns a field label conversion function accepting metadata.name,
+// metadata.namespace, the selectable fields and the indexing fields of the object, nil if the object has
+// neither.
+func selectableFieldsLabelConversionFunc(obj Object) runtime.FieldLabelConversionFunc {
+	selectableFields := sets.New[string]()
+	if selectableObj, ok := obj.(ObjectWithSelectableFields); ok {
+		for field := range selectableObj.SelectableFields() {
+			selectableFields.Insert(field)
+		}
+	}
+	if indexer, ok := obj.(resourcerest.FieldsIndexer); ok {
+		selectableFields.Insert(indexer.IndexingFields()...)
+	}
+	if selectableFields.Len() == 0 {
+		return nil
+	}
+	return func(label, value string) (string, string, error) {
+		switch label {
+		case "metadata.name", "metadata.namespace":
+			return label, value, nil
+		}
+		if selectableFields.Has(label) {
+			return label, value, nil
+		}
+		return "", "", fmt.Errorf("field label not supported: %s", label)
+	}
+}
+
+// AddToParameterScheme returns a function to add the query parameter objects of the subresources to the
+// parameter scheme.
+//
+// AddToParameterScheme will register the options returned by NewGetOptions for each arbitrary subresource
+// together with the url.Values conversion if the subresource implements ArbitrarySubResourceWithOptionsConverter.
+// AddToParameterScheme will register the options returned by NewConnectOptions for each connector subresource
+// together with the url.Values conversion if the options implement QueryParameterObject.
+func AddToParameterScheme(objs ...Object) func(s *runtime.Scheme) error {
+	return func(s *runtime.Scheme) error {
+		for i := range objs {
+			obj := objs[i]
+			arb, ok := obj.(ObjectWithArbitrarySubResource)
+			if !ok {
+				continue
+			}
+			for _, sub := range arb.GetArbitrarySubResources() {
+				if connector, ok := sub.(ConnectorSubResource); ok {
+					if err := addConnectOptionsToParameterScheme(s, obj, connector); err != nil {
+						return err
+					}
+					continue
+				}
+				subWithOpts, ok := sub.(ArbitrarySubResourceWithOptions)
+				if !ok {
+					continue
+				}
+				opts := subWithOpts.NewGetOptions()
+				if opts == nil {
+					continue
+				}
+				s.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), opts)
+
+				// Register url.Values → options conversion
+				converter, ok := sub.(ArbitrarySubResourceWithOptionsConverter)
+				if !ok {
+					continue
+				}
+				if err := s.AddConversionFunc(
+					(*url.Values)(nil),
+					opts,
+					converter.ConvertFromURLValues(),
+				); err != nil {
+					return err
+				}
+				// Register version conversion for ParameterScheme
+				if versionConverter, ok := sub.(ArbitrarySubResourceWithVersionConverter); ok {
+					for _, convFn := range versionConverter.ParameterSchemeConversions() {
+						if err := convFn(s); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// addConnectOptionsToParameterScheme registers the connect options of the connector subresource and the
+// url.Values conversion of QueryParameterObject options.
+func addConnectOptionsToParameterScheme(s *runtime.Scheme, obj Object, connector ConnectorSubResource) error {
+	opts, _, _ := connector.NewConnectOptions()
+	if opts == nil {
+		return nil
+	}
+	s.AddKnownTypes(obj.GetGroupVersionResource().GroupVersion(), opts)
+	if _, ok := opts.(QueryParameterObject); !ok {
+		return nil
+	}
+	return s.AddConversionFunc((*url.Values)(nil), opts, func(a, b interface{}, scope conversion.Scope) error {
+		return b.(QueryParameterObject).ConvertFromUrlValues(a.(*url.Values))
+	})
+}
@@ -95,15 +95,20 @@ type Watcher = rest.Watcher
 // StandardStorage defines the standard endpoints for resources.
 type StandardStorage = rest.StandardStorage
 
-// FieldsIndexer indices resources by certain fields at the server-side.
-// TODO: implement it
+// FieldsIndexer indices resources by certain fields at the server-side. The storage keeps a secondary index
+// per indexing field, lists with a field selector requiring an exact match on an indexing field, e.g.
+// spec.nodeName=node1, only visit the objects in the index. The indexing fields are also selectable.
 type FieldsIndexer interface {
+	// IndexingFields returns the indexing fields, e.g. []string{"spec.nodeName"}
 	IndexingFields() []string
+	// GetField returns the value of the indexing field of the resource
 	GetField(fieldName string) string
 }
 
-// LabelsIndexer indices resources by their labels at the server-side.
-// TODO: implement it
+// LabelsIndexer indices resources by their labels at the server-side. The storage keeps a secondary index
+// per indexing label key, lists with a label selector requiring an exact match on an indexing label key only
+// visit the objects in the index.
 type LabelsIndexer interface {
+	// IndexingLabelKeys returns the indexing label keys
 	IndexingLabelKeys() []string
 }
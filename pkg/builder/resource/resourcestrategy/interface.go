@@ -47,9 +47,11 @@ type Canonicalizer interface {
 }
 
 // Converter defines functions for converting a version of a resource to / from the internal version.
-// Converter functions are called to convert the request version of the object to the handler version --
-// e.g. if a v1beta1 object is created, and the handler uses a v1alpha1 version, then the v1beta1 will be converted
-// to a v1alpha1 before the handler is called.
+// Converter functions are called to convert the request version of the object to the storage version --
+// e.g. if a v1beta1 object is created, and v1alpha1 is the storage version, then the v1beta1 will be converted
+// to the internal v1alpha1 type before it is stored, and back to a v1beta1 when it is returned.
+// Converter is only used for the versions that are not the storage version and do not implement
+// MultiVersionObject.
 type Converter interface {
 	// ConvertFromInternal converts an internal version of the object to this object's version
 	ConvertFromInternal(internal interface{})
@@ -70,6 +72,7 @@ type Defaulter interface {
 // is implemented for a type, it will be invoked before creating an object of that type.
 //
 // PrepareForCreater is only invoked when storing an object and only for the type that is the storage version type.
+// A resource.InternalObject implements the PrepareForCreate hook instead.
 type PrepareForCreater interface {
 	PrepareForCreate(ctx context.Context)
 }
@@ -78,6 +81,7 @@ type PrepareForCreater interface {
 // is implemented for a type, it will be invoked before updating an object of that type.
 //
 // PrepareForUpdater is only invoked when storing an object and only for the type that is the storage version type.
+// A resource.InternalObject implements the PrepareForUpdate hook instead.
 type PrepareForUpdater interface {
 	PrepareForUpdate(ctx context.Context, old runtime.Object)
 }
@@ -95,6 +99,32 @@ type Validater interface {
 
 // ValidateUpdater functions are invoked before an object is stored to validate the object during update.
 // If ValidateUpdater is implemented for a type, it will be invoked before updating an object of that type.
+//
+// A resource.InternalObject implements the ValidateUpdate hook instead.
 type ValidateUpdater interface {
 	ValidateUpdate(ctx context.Context, obj runtime.Object) field.ErrorList
 }
+
+// PrepareForDeleter functions are invoked before an object is deleted or marked for deletion.  If PrepareForDelete
+// is implemented for a type, it will be invoked before deleting an object of that type, its changes are stored
+// when the object is marked for deletion, i.e. when the deletion waits for its finalizers or its grace period.
+//
+// PrepareForDeleter is only invoked for the type that is the storage version type.
+type PrepareForDeleter interface {
+	PrepareForDelete(ctx context.Context)
+}
+
+// ValidateDeleter functions are invoked before an object is deleted or marked for deletion to validate the deletion.
+// If ValidateDeleter is implemented for a type, it will be invoked before deleting an object of that type.
+type ValidateDeleter interface {
+	ValidateDelete(ctx context.Context) field.ErrorList
+}
+
+// GracefulDeleter functions are invoked when an object is deleted to decide if it is deleted gracefully, i.e. it is
+// marked for deletion and kept until it is deleted again with a grace period of 0, e.g. by its controller.  If
+// CheckGracefulDelete returns true, it must set the GracePeriodSeconds of the options.
+//
+// The objects of a type that does not implement GracefulDeleter are deleted immediately unless they have finalizers.
+type GracefulDeleter interface {
+	CheckGracefulDelete(ctx context.Context, options *metav1.DeleteOptions) bool
+}
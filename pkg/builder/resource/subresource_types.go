@@ -17,6 +17,9 @@ limitations under the License.
 package resource
 
 import (
+	"net/url"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcerest"
 	"k8s.io/apimachinery/pkg/conversion"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apiserver/pkg/registry/rest"
@@ -62,14 +65,15 @@ type ArbitrarySubResourceWithVersionConverter interface {
 	ParameterSchemeConversions() []func(*runtime.Scheme) error
 }
 
-/*
-// ConnectorSubResource defines required methods for implementing a connector subresource.
+// ConnectorSubResource defines required methods for implementing a connector subresource, e.g. exec, log
+// or proxy style subresources. The connector receives the parent storage through the request context.
 type ConnectorSubResource interface {
 	ArbitrarySubResource
 	resourcerest.Connecter
 }
 
 // GetterUpdaterSubResource defines required methods for implementing a subresource that allows getting & updating.
+// The subresource receives the parent storage through the request context.
 type GetterUpdaterSubResource interface {
 	ArbitrarySubResource
 	resourcerest.Getter
@@ -81,4 +85,3 @@ type GetterUpdaterSubResource interface {
 type QueryParameterObject interface {
 	ConvertFromUrlValues(values *url.Values) error
 }
-*/
@@ -53,26 +53,28 @@ type Object interface {
 
 	// IsStorageVersion returns true if the object is also the internal version -- i.e. is the type defined
 	// for the API group an alias to this object.
-	// If false, the resource is expected to implement MultiVersionObject interface.
+	// If false, the resource is expected to implement MultiVersionObject interface or its type is expected to
+	// implement resourcestrategy.Converter. Exactly one version of a resource is the storage version.
 	IsStorageVersion() bool
 }
 
 type InternalObject interface {
 	Object
 
-	// GetSingularName return the singular name of the resource
+	// GetSingularName return the singular name of the resource, it is advertised in discovery
 	GetSingularName() string
 
-	// GetShortNames retruns the short names for the resource
+	// GetShortNames retruns the short names for the resource, they are advertised in discovery
 	GetShortNames() []string
 
-	// GetCategories returns the categories of the resource
+	// GetCategories returns the categories of the resource, they are advertised in discovery, e.g. "all"
 	GetCategories() []string
 
 	// NamespaceScoped returns if the resource is namespaced or not
 	NamespaceScoped() bool
 
-	// TableConvertor return the table convertor interface
+	// TableConvertor return the table convertor interface, it may return nil if the resource implements
+	// ObjectWithTableColumns or is printed with the default columns
 	TableConvertor() func(gr schema.GroupResource) rest.TableConvertor
 
 	// FieldLabelConversion returns the field conversion function
@@ -109,7 +111,8 @@ type ObjectList interface {
 }
 
 // MultiVersionObject should be implemented if the resource is not storage version and has multiple versions serving
-// at the server.
+// at the server. The conversions are registered after the storage version, they convert to and from the internal
+// version.
 type MultiVersionObject interface {
 	RegisterConversions() func(s *runtime.Scheme) error
 }
@@ -120,7 +123,8 @@ type ObjectWithStatusSubResource interface {
 	// GetStatus returns the status subresource
 	GetStatus() (statusSubResource StatusSubResource)
 
-	// IsEqual validates if status subresources are equal
+	// IsStatusEqual returns true if the status of obj equals the status of old, an update of the status
+	// subresource with an equal status is not stored.
 	IsStatusEqual(ctx context.Context, obj, old runtime.Object) bool
 
 	// PrepareForUpdate prepares the resource for update.
@@ -144,6 +148,44 @@ type ObjectWithArbitrarySubResource interface {
 	GetArbitrarySubResources() []ArbitrarySubResource
 }
 
+// ObjectWithSelectableFields defines an interface for resources that can be selected by field selectors on
+// fields other than metadata.name and metadata.namespace, e.g. spec.nodeName or status.phase.
+type ObjectWithSelectableFields interface {
+	Object
+	// SelectableFields returns the values of the selectable fields of the resource. The fields of the set
+	// must not depend on the state of the object, e.g. fields.Set{"status.phase": string(r.Status.Phase)}.
+	SelectableFields() fields.Set
+}
+
+// ObjectWithTableColumns defines an interface for resources that are printed by `kubectl get` with the columns
+// they declare rather than with the table convertor they build. The columns of the storage version are used for
+// every version of the resource.
+type ObjectWithTableColumns interface {
+	Object
+	// TableColumns returns the columns printed after the name of the object, the age of the object is printed
+	// last unless a column is named Age.
+	TableColumns() []TableColumn
+}
+
+// TableColumn defines a column of the table of a resource, see ObjectWithTableColumns. The fields are the fields
+// of the additional printer columns of a CustomResourceDefinition.
+type TableColumn struct {
+	// Name is the header of the column
+	Name string
+	// Type is the OpenAPI type of the values of the column: integer, number, string, boolean or date
+	Type string
+	// Format is the OpenAPI format of the values of the column, e.g. name
+	Format string
+	// Description is the description of the column, it defaults to the JSONPath
+	Description string
+	// Priority is the priority of the column, the columns with a priority greater than 0 are only printed in
+	// the wide output
+	Priority int32
+	// JSONPath is the simple JSON path evaluated against the object for the value of the column,
+	// e.g. .status.phase
+	JSONPath string
+}
+
 type Filter interface {
 	Filter(ctx context.Context, obj runtime.Object) bool
 }
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"context"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// deleteHooks are the hooks of a delete strategy called before an object is deleted or marked for deletion,
+// DefaultStrategy implements them.
+type deleteHooks interface {
+	PrepareForDelete(ctx context.Context, obj runtime.Object)
+	ValidateDelete(ctx context.Context, obj runtime.Object) field.ErrorList
+}
+
+// withDeleteHooks returns the delete validation preparing and validating the object with the hooks of the
+// strategy before calling deleteValidation, e.g. the admission of the request. The changes of PrepareForDelete
+// are stored when the object is marked for deletion rather than deleted.
+func withDeleteHooks(strategy rest.RESTDeleteStrategy, deleteValidation rest.ValidateObjectFunc) rest.ValidateObjectFunc {
+	hooks, ok := strategy.(deleteHooks)
+	if !ok {
+		return deleteValidation
+	}
+	return func(ctx context.Context, obj runtime.Object) error {
+		hooks.PrepareForDelete(ctx, obj)
+		if errs := hooks.ValidateDelete(ctx, obj); len(errs) != 0 {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return apierrors.NewInternalError(err)
+			}
+			kinds, _, err := strategy.ObjectKinds(obj)
+			if err != nil {
+				return apierrors.NewInternalError(err)
+			}
+			return apierrors.NewInvalid(kinds[0].GroupKind(), accessor.GetName(), errs)
+		}
+		if deleteValidation != nil {
+			return deleteValidation(ctx, obj)
+		}
+		return nil
+	}
+}
+
+// deletionFinalizers returns the finalizers of the object asking the garbage collector to orphan its dependents
+// or to delete them in the foreground as the propagation policy of the options tells, the finalizers of the
+// object are kept when the options have no policy, like the generic registry store does. It returns false if
+// the finalizers are unchanged.
+func deletionFinalizers(accessor metav1.Object, options *metav1.DeleteOptions) ([]string, bool) {
+	orphan := slices.Contains(accessor.GetFinalizers(), metav1.FinalizerOrphanDependents)
+	foreground := !orphan && slices.Contains(accessor.GetFinalizers(), metav1.FinalizerDeleteDependents)
+	switch {
+	case options.OrphanDependents != nil: //nolint:staticcheck
+		orphan, foreground = *options.OrphanDependents, false //nolint:staticcheck
+	case options.PropagationPolicy != nil:
+		orphan = *options.PropagationPolicy == metav1.DeletePropagationOrphan
+		foreground = *options.PropagationPolicy == metav1.DeletePropagationForeground
+	}
+	finalizers := slices.DeleteFunc(slices.Clone(accessor.GetFinalizers()), func(finalizer string) bool {
+		return finalizer == metav1.FinalizerOrphanDependents || finalizer == metav1.FinalizerDeleteDependents
+	})
+	if orphan {
+		finalizers = append(finalizers, metav1.FinalizerOrphanDependents)
+	}
+	if foreground {
+		finalizers = append(finalizers, metav1.FinalizerDeleteDependents)
+	}
+	return finalizers, !sets.New(finalizers...).Equal(sets.New(accessor.GetFinalizers()...))
+}
+
+// markAsDeleting sets the deletionTimestamp of an object waiting for its finalizers and a grace period of 0, so
+// the object is deleted once its finalizers are removed, and bumps its generation when the deletion starts.
+func markAsDeleting(accessor metav1.Object, now metav1.Time) {
+	if accessor.GetDeletionTimestamp() == nil && accessor.GetGeneration() > 0 {
+		accessor.SetGeneration(accessor.GetGeneration() + 1)
+	}
+	if timestamp := accessor.GetDeletionTimestamp(); timestamp == nil || now.Before(timestamp) {
+		accessor.SetDeletionTimestamp(&now)
+	}
+	accessor.SetDeletionGracePeriodSeconds(new(int64))
+}
@@ -0,0 +1,57 @@
+package rest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestDeletionFinalizers(t *testing.T) {
+	const cleanup = "test.example.com/cleanup"
+	for name, tc := range map[string]struct {
+		finalizers []string
+		options    metav1.DeleteOptions
+		want       []string
+		changed    bool
+	}{
+		"no policy": {
+			finalizers: []string{cleanup},
+			want:       []string{cleanup},
+		},
+		"no policy keeps the finalizer of the garbage collector": {
+			finalizers: []string{metav1.FinalizerOrphanDependents},
+			want:       []string{metav1.FinalizerOrphanDependents},
+		},
+		"orphan": {
+			finalizers: []string{cleanup},
+			options:    metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationOrphan)},
+			want:       []string{cleanup, metav1.FinalizerOrphanDependents},
+			changed:    true,
+		},
+		"foreground replaces orphan": {
+			finalizers: []string{metav1.FinalizerOrphanDependents},
+			options:    metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationForeground)},
+			want:       []string{metav1.FinalizerDeleteDependents},
+			changed:    true,
+		},
+		"background removes the finalizers of the garbage collector": {
+			finalizers: []string{cleanup, metav1.FinalizerDeleteDependents},
+			options:    metav1.DeleteOptions{PropagationPolicy: ptr.To(metav1.DeletePropagationBackground)},
+			want:       []string{cleanup},
+			changed:    true,
+		},
+		"orphan dependents": {
+			options: metav1.DeleteOptions{OrphanDependents: ptr.To(true)},
+			want:    []string{metav1.FinalizerOrphanDependents},
+			changed: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, changed := deletionFinalizers(&metav1.ObjectMeta{Finalizers: tc.finalizers}, &tc.options)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.changed, changed)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package rest
+
+import (
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/utils"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic"
+	registry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// NewEtcdStorageProvider returns a StorageProvider that stores the resource in etcd using the
+// generic registry store configured through the RESTOptionsGetter of the apiserver.
+func NewEtcdStorageProvider(obj resource.InternalObject) *StorageProvider {
+	return &StorageProvider{
+		ResourceStorageProviderFn: func(scheme *runtime.Scheme, getter genericregistry.RESTOptionsGetter) (rest.Storage, error) {
+			return NewEtcdStore(scheme, getter, obj)
+		},
+		ValidationRulesStorageFn: WithValidationRules,
+	}
+}
+
+// NewEtcdStore returns a generic registry store for the resource. The create, update and delete strategies
+// dispatch to the hooks of the InternalObject and to the other interfaces of resourcestrategy it implements,
+// e.g. resourcestrategy.Validater. The watch cache indexes the resource by the indexing fields of FieldsIndexer
+// and the indexing label keys of LabelsIndexer.
+func NewEtcdStore(scheme *runtime.Scheme, getter genericregistry.RESTOptionsGetter, obj resource.InternalObject) (*registry.Store, error) {
+	gr := obj.GetGroupVersionResource().GroupResource()
+	strategy := newInternalObjectStrategy(scheme, obj)
+
+	store := &registry.Store{
+		NewFunc:                   obj.New,
+		NewListFunc:               obj.NewList,
+		PredicateFunc:             utils.IndexedMatch(obj),
+		DefaultQualifiedResource:  gr,
+		SingularQualifiedResource: schema.GroupResource{Group: gr.Group, Resource: obj.GetSingularName()},
+		TableConvertor:            strategy,
+
+		CreateStrategy: strategy,
+		UpdateStrategy: strategy,
+		DeleteStrategy: strategy,
+	}
+	options := &genericregistry.StoreOptions{
+		RESTOptions: getter,
+		AttrFunc:    utils.GetAttrs,
+		TriggerFunc: utils.TriggerFunc(obj),
+		Indexers:    utils.Indexers(obj),
+	}
+	if err := store.CompleteWithOptions(options); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
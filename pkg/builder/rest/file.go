@@ -0,0 +1,337 @@
+package rest
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultFilePollInterval is the default interval to detect changes made outside of the apiserver
+	defaultFilePollInterval = 2 * time.Second
+	// fileResourceVersionName is the file persisting the resourceVersion of the last deletion
+	fileResourceVersionName = ".resourceversion"
+)
+
+// FileStorageOptions configures the file system storage of a resource.
+type FileStorageOptions struct {
+	// RootPath is the directory the objects are stored under as <root>/<group>/<resource>/<namespace>/<name>
+	RootPath string
+	// MediaType is the media type used to encode the objects, runtime.ContentTypeYAML by default
+	MediaType string
+	// PollInterval is the interval used to detect files changed outside of the apiserver
+	PollInterval time.Duration
+}
+
+// NewFileStorageProvider returns a StorageProvider that persists each object of the resource as a file
+// on the file system. Files added, changed or removed outside of the apiserver are picked up by polling
+// the directory of the resource and are sent to the watchers.
+func NewFileStorageProvider(obj resource.InternalObject, opts FileStorageOptions) *StorageProvider {
+	return &StorageProvider{
+		ResourceStorageProviderFn: func(scheme *runtime.Scheme, getter genericregistry.RESTOptionsGetter) (rest.Storage, error) {
+			return NewFileStore(scheme, obj, opts)
+		},
+		ValidationRulesStorageFn: WithValidationRules,
+	}
+}
+
+// NewFileStore returns a file system storage for the resource. The create, update and delete strategies
+// dispatch to the hooks of the InternalObject and to the other interfaces of resourcestrategy it implements,
+// e.g. resourcestrategy.Validater.
+func NewFileStore(scheme *runtime.Scheme, obj resource.InternalObject, opts FileStorageOptions) (rest.StandardStorage, error) {
+	if opts.RootPath == "" {
+		return nil, fmt.Errorf("file storage for %s requires a root path", obj.GetGroupVersionResource().GroupResource().String())
+	}
+	if opts.MediaType == "" {
+		opts.MediaType = runtime.ContentTypeYAML
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultFilePollInterval
+	}
+	codecs := serializer.NewCodecFactory(scheme)
+	info, ok := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), opts.MediaType)
+	if !ok {
+		return nil, fmt.Errorf("unsupported media type %q for the file storage", opts.MediaType)
+	}
+	gvr := obj.GetGroupVersionResource()
+	backend := &fileBackend{
+		dir:       filepath.Join(opts.RootPath, gvr.Group, gvr.Resource),
+		extension: "." + info.MediaTypeSubType,
+		encoder:   codecs.EncoderForVersion(info.Serializer, gvr.GroupVersion()),
+		decoder:   codecs.UniversalDeserializer(),
+		newFunc:   obj.New,
+		files:     map[types.NamespacedName]fileStamp{},
+		pending:   map[types.NamespacedName]fileStamp{},
+		stopCh:    make(chan struct{}),
+	}
+	if err := os.MkdirAll(backend.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	store := newMemoryStore(scheme, obj)
+	if err := backend.load(store); err != nil {
+		return nil, err
+	}
+	store.state.backend = backend
+	go wait.Until(func() { backend.sync(store) }, opts.PollInterval, backend.stopCh)
+	return store, nil
+}
+
+// fileStamp identifies the version of a file on the file system
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+var _ memoryBackend = &fileBackend{}
+
+// fileBackend persists the objects of a memoryStore as files in a directory.
+type fileBackend struct {
+	dir       string
+	extension string
+	encoder   runtime.Encoder
+	decoder   runtime.Decoder
+	newFunc   func() runtime.Object
+	// files holds the stamp of the files written or read by the backend to detect external changes
+	files map[types.NamespacedName]fileStamp
+	// pending holds the stamp of the files changed externally during the last poll, a change is applied
+	// once the file is unchanged for a poll so files still being written are not read halfway
+	pending map[types.NamespacedName]fileStamp
+	stopCh  chan struct{}
+}
+
+func (b *fileBackend) save(key types.NamespacedName, obj runtime.Object) error {
+	data, err := runtime.Encode(b.encoder, obj)
+	if err != nil {
+		return err
+	}
+	path := b.path(key)
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	return b.stamp(key, path)
+}
+
+func (b *fileBackend) delete(key types.NamespacedName, resourceVersion uint64) error {
+	if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	delete(b.files, key)
+	// persist the resourceVersion of the deletion so the resourceVersion never goes backwards on restart
+	return writeFileAtomic(filepath.Join(b.dir, fileResourceVersionName), []byte(strconv.FormatUint(resourceVersion, 10)))
+}
+
+func (b *fileBackend) destroy() {
+	close(b.stopCh)
+}
+
+// load reads the objects from the directory into the store before the store is served.
+func (b *fileBackend) load(r *memoryStore) error {
+	data, err := os.ReadFile(filepath.Join(b.dir, fileResourceVersionName))
+	switch {
+	case err == nil:
+		r.state.resourceVersion, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid resourceVersion in %s: %w", b.dir, err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	stamps, err := b.walk()
+	if err != nil {
+		return err
+	}
+	var unversioned []types.NamespacedName
+	for key := range stamps {
+		obj, err := b.read(key)
+		if err != nil {
+			return err
+		}
+		resourceVersion := eventResourceVersion(watch.Event{Object: obj})
+		if resourceVersion == 0 {
+			unversioned = append(unversioned, key)
+		}
+		if resourceVersion > r.state.resourceVersion {
+			r.state.resourceVersion = resourceVersion
+		}
+		r.state.objects[key] = obj
+		r.state.index.add(key, obj)
+		b.files[key] = stamps[key]
+	}
+	// objects without a resourceVersion are handed out a resourceVersion and persisted again
+	for _, key := range unversioned {
+		obj := r.state.objects[key]
+		if err := meta.NewAccessor().SetResourceVersion(obj, strconv.FormatUint(r.state.resourceVersion+1, 10)); err != nil {
+			return err
+		}
+		if err := b.save(key, obj); err != nil {
+			return err
+		}
+		r.state.resourceVersion++
+	}
+	return nil
+}
+
+// sync applies the files added, changed or removed outside of the apiserver to the store and notifies
+// the watchers.
+func (b *fileBackend) sync(r *memoryStore) {
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+
+	stamps, err := b.walk()
+	if err != nil {
+		klog.Errorf("cannot read file storage %s: %v", b.dir, err)
+		return
+	}
+	for key, stamp := range stamps {
+		if known, ok := b.files[key]; ok && known == stamp {
+			delete(b.pending, key)
+			continue
+		}
+		if stamp.size == 0 {
+			// the file is truncated to be written
+			continue
+		}
+		if pending, ok := b.pending[key]; !ok || pending != stamp {
+			b.pending[key] = stamp
+			continue
+		}
+		delete(b.pending, key)
+		obj, err := b.read(key)
+		if err != nil {
+			klog.Errorf("cannot read file storage %s: %v", b.dir, err)
+			continue
+		}
+		eventType := watch.Modified
+		if _, ok := r.state.objects[key]; !ok {
+			eventType = watch.Added
+		}
+		if err := meta.NewAccessor().SetResourceVersion(obj, strconv.FormatUint(r.state.resourceVersion+1, 10)); err != nil {
+			klog.Errorf("cannot set resourceVersion of %s: %v", b.path(key), err)
+			continue
+		}
+		if err := r.store(key, obj, eventType); err != nil {
+			klog.Errorf("cannot write file storage %s: %v", b.dir, err)
+		}
+	}
+	for key := range b.pending {
+		if _, ok := stamps[key]; !ok {
+			delete(b.pending, key)
+		}
+	}
+	for key := range b.files {
+		if _, ok := stamps[key]; ok {
+			continue
+		}
+		obj, ok := r.state.objects[key]
+		if !ok {
+			delete(b.files, key)
+			continue
+		}
+		if err := r.remove(key, obj.DeepCopyObject()); err != nil {
+			klog.Errorf("cannot write file storage %s: %v", b.dir, err)
+		}
+	}
+}
+
+// walk returns the stamps of the object files in the directory of the resource.
+func (b *fileBackend) walk() (map[types.NamespacedName]fileStamp, error) {
+	stamps := map[types.NamespacedName]fileStamp{}
+	err := filepath.WalkDir(b.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || filepath.Ext(path) != b.extension {
+			return nil
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		key := types.NamespacedName{Name: strings.TrimSuffix(filepath.Base(rel), b.extension)}
+		if dir := filepath.Dir(rel); dir != "." {
+			key.Namespace = dir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stamps[key] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	return stamps, err
+}
+
+// read decodes the object stored under the key, the name and namespace are taken from the path.
+func (b *fileBackend) read(key types.NamespacedName) (runtime.Object, error) {
+	path := b.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, _, err := b.decoder.Decode(data, nil, b.newFunc())
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %w", path, err)
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	accessor.SetName(key.Name)
+	accessor.SetNamespace(key.Namespace)
+	return obj, nil
+}
+
+func (b *fileBackend) stamp(key types.NamespacedName, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	b.files[key] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	return nil
+}
+
+func (b *fileBackend) path(key types.NamespacedName) string {
+	return filepath.Join(b.dir, key.Namespace, key.Name+b.extension)
+}
+
+// writeFileAtomic writes the data to a temporary file which is renamed to the path once written.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
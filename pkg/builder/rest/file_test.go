@@ -0,0 +1,134 @@
+package rest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+func newTestFileStore(t *testing.T, opts FileStorageOptions) rest.StandardStorage {
+	t.Helper()
+	store, err := NewFileStore(newTestScheme(), &testObject{}, opts)
+	require.NoError(t, err)
+	t.Cleanup(store.Destroy)
+	return store
+}
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	root := t.TempDir()
+	store := newTestFileStore(t, FileStorageOptions{RootPath: root})
+	path := filepath.Join(root, "test.example.com", "testobjects", "default", "a.yaml")
+
+	_, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: "a"}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "apiVersion: test.example.com/v1")
+	assert.Contains(t, string(data), "spec: a")
+
+	_, _, err = store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Spec = "b" }), nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "spec: b")
+	// the files are written atomically, no temporary file is left behind
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	deleted, _, err := store.Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.NoFileExists(t, path)
+	data, err = os.ReadFile(filepath.Join(root, "test.example.com", "testobjects", fileResourceVersionName))
+	require.NoError(t, err)
+	assert.Equal(t, deleted.(*testObject).ResourceVersion, string(data))
+}
+
+func TestFileStoreReload(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	root := t.TempDir()
+	store := newTestFileStore(t, FileStorageOptions{RootPath: root})
+	created, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: "a"}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "b"}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	deleted, _, err := store.Delete(ctx, "b", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	store.Destroy()
+
+	// the objects and the resourceVersion survive the restart of the apiserver
+	store = newTestFileStore(t, FileStorageOptions{RootPath: root})
+	obj, err := store.Get(ctx, "a", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, created.(*testObject).ResourceVersion, obj.(*testObject).ResourceVersion)
+	assert.Equal(t, "a", obj.(*testObject).Spec)
+	_, err = store.Get(ctx, "b", &metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+
+	obj, err = store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "c"}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Greater(t, resourceVersionOf(t, obj.(*testObject)), resourceVersionOf(t, deleted.(*testObject)))
+}
+
+func TestFileStoreExternalEdits(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	root := t.TempDir()
+	store := newTestFileStore(t, FileStorageOptions{RootPath: root, PollInterval: 10 * time.Millisecond})
+	w, err := store.Watch(ctx, &metainternalversion.ListOptions{})
+	require.NoError(t, err)
+	t.Cleanup(w.Stop)
+	next := func() watch.Event {
+		t.Helper()
+		select {
+		case event := <-w.ResultChan():
+			return event
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "no event for the file changed outside of the apiserver")
+			return watch.Event{}
+		}
+	}
+
+	// the name and the namespace are taken from the path of the file
+	path := filepath.Join(root, "test.example.com", "testobjects", "default", "a.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, []byte("apiVersion: test.example.com/v1\nkind: testObject\nspec: a\n"), 0o644))
+	event := next()
+	assert.Equal(t, watch.Added, event.Type)
+	assert.Equal(t, "a", event.Object.(*testObject).Name)
+	assert.Equal(t, "default", event.Object.(*testObject).Namespace)
+	assert.Equal(t, "a", event.Object.(*testObject).Spec)
+
+	require.NoError(t, os.WriteFile(path, []byte("apiVersion: test.example.com/v1\nkind: testObject\nspec: changed\n"), 0o644))
+	event = next()
+	assert.Equal(t, watch.Modified, event.Type)
+	assert.Equal(t, "changed", event.Object.(*testObject).Spec)
+	obj, err := store.Get(ctx, "a", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "changed", obj.(*testObject).Spec)
+
+	require.NoError(t, os.Remove(path))
+	event = next()
+	assert.Equal(t, watch.Deleted, event.Type)
+	_, err = store.Get(ctx, "a", &metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+}
+
+func resourceVersionOf(t *testing.T, obj *testObject) uint64 {
+	t.Helper()
+	resourceVersion, err := strconv.ParseUint(obj.ResourceVersion, 10, 64)
+	require.NoError(t, err)
+	return resourceVersion
+}
@@ -0,0 +1,87 @@
+package rest
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// memoryIndex is the secondary index of a memoryStore, it maps the values of the indexing fields and label
+// keys to the keys of the objects.
+type memoryIndex struct {
+	indexers cache.Indexers
+	// keys stores the keys of the objects by index name and value
+	keys map[string]map[string]sets.Set[types.NamespacedName]
+}
+
+func newMemoryIndex(indexers *cache.Indexers) *memoryIndex {
+	index := &memoryIndex{
+		indexers: cache.Indexers{},
+		keys:     map[string]map[string]sets.Set[types.NamespacedName]{},
+	}
+	if indexers != nil {
+		for name, indexFunc := range *indexers {
+			index.indexers[name] = indexFunc
+			index.keys[name] = map[string]sets.Set[types.NamespacedName]{}
+		}
+	}
+	return index
+}
+
+// add indexes the object stored under the key.
+func (r *memoryIndex) add(key types.NamespacedName, obj runtime.Object) {
+	for name := range r.indexers {
+		for _, value := range r.values(name, obj) {
+			keys, ok := r.keys[name][value]
+			if !ok {
+				keys = sets.New[types.NamespacedName]()
+				r.keys[name][value] = keys
+			}
+			keys.Insert(key)
+		}
+	}
+}
+
+// delete removes the object stored under the key from the index.
+func (r *memoryIndex) delete(key types.NamespacedName, obj runtime.Object) {
+	for name := range r.indexers {
+		for _, value := range r.values(name, obj) {
+			keys := r.keys[name][value]
+			keys.Delete(key)
+			if keys.Len() == 0 {
+				delete(r.keys[name], value)
+			}
+		}
+	}
+}
+
+// lookup returns the keys of the objects matching all the values of indexes. lookup returns false if none of
+// the values is indexed, i.e. all the objects must be visited.
+func (r *memoryIndex) lookup(matchValues []storage.MatchValue) (sets.Set[types.NamespacedName], bool) {
+	var result sets.Set[types.NamespacedName]
+	for _, matchValue := range matchValues {
+		values, ok := r.keys[matchValue.IndexName]
+		if !ok {
+			continue
+		}
+		keys := values[matchValue.Value]
+		if result == nil {
+			result = keys.Clone()
+		} else {
+			result = result.Intersection(keys)
+		}
+	}
+	return result, result != nil
+}
+
+func (r *memoryIndex) values(name string, obj runtime.Object) []string {
+	values, err := r.indexers[name](obj)
+	if err != nil {
+		klog.Errorf("cannot index %T by %s: %v", obj, name, err)
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,815 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/utils"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/endpoints/handlers/fieldmanager"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic"
+	registry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/util/dryrun"
+)
+
+const (
+	// memoryWatchHistorySize is the number of events kept to resume watches from a resourceVersion
+	memoryWatchHistorySize = 1000
+	// memoryWatchQueueLength is the number of events queued for a watcher, a watcher lagging further behind
+	// is stopped
+	memoryWatchQueueLength = 1000
+)
+
+// NewMemoryStorageProvider returns a StorageProvider that keeps the resource in memory. The content of
+// the storage is lost when the apiserver restarts, which fits derived or cached resources.
+func NewMemoryStorageProvider(obj resource.InternalObject) *StorageProvider {
+	return &StorageProvider{
+		ResourceStorageProviderFn: func(scheme *runtime.Scheme, getter genericregistry.RESTOptionsGetter) (rest.Storage, error) {
+			return NewMemoryStore(scheme, obj), nil
+		},
+		ValidationRulesStorageFn: WithValidationRules,
+	}
+}
+
+// NewMemoryStore returns an in-memory storage for the resource. The create, update and delete strategies
+// dispatch to the hooks of the InternalObject and to the other interfaces of resourcestrategy it implements,
+// e.g. resourcestrategy.Validater.
+func NewMemoryStore(scheme *runtime.Scheme, obj resource.InternalObject) rest.StandardStorage {
+	return newMemoryStore(scheme, obj)
+}
+
+func newMemoryStore(scheme *runtime.Scheme, obj resource.InternalObject) *memoryStore {
+	gr := obj.GetGroupVersionResource().GroupResource()
+	strategy := newInternalObjectStrategy(scheme, obj)
+	return &memoryStore{
+		gr:             gr,
+		obj:            obj,
+		tableConvertor: strategy,
+		createStrategy: strategy,
+		updateStrategy: strategy,
+		deleteStrategy: strategy,
+		state: &memoryState{
+			objects:  map[types.NamespacedName]runtime.Object{},
+			index:    newMemoryIndex(utils.Indexers(obj)),
+			watchers: map[*memoryWatcher]struct{}{},
+		},
+	}
+}
+
+var _ rest.StandardStorage = &memoryStore{}
+var _ rest.Scoper = &memoryStore{}
+var _ rest.SingularNameProvider = &memoryStore{}
+var _ rest.ShortNamesProvider = &memoryStore{}
+var _ rest.CategoriesProvider = &memoryStore{}
+var _ rest.TableConvertor = &memoryStore{}
+var _ rest.StorageVersionProvider = &memoryStore{}
+
+// memoryStore implements rest.StandardStorage on top of a memoryState. Copies of the memoryStore share
+// the same state, e.g. the status subresource storage only swaps the update strategy. The updates and the
+// deletions are prepared and validated without the lock of the state, so the strategies and the admission
+// may read the store, and are committed under the lock if the object did not change meanwhile.
+type memoryStore struct {
+	gr             schema.GroupResource
+	obj            resource.InternalObject
+	tableConvertor rest.TableConvertor
+	createStrategy rest.RESTCreateStrategy
+	updateStrategy rest.RESTUpdateStrategy
+	deleteStrategy rest.RESTDeleteStrategy
+
+	state *memoryState
+}
+
+// memoryState holds the objects of a memoryStore together with the resourceVersion and the watch history.
+type memoryState struct {
+	mu sync.RWMutex
+	// objects stores the objects by namespace/name
+	objects map[types.NamespacedName]runtime.Object
+	// index is the secondary index of the objects by the indexing fields and label keys of the resource
+	index *memoryIndex
+	// resourceVersion is the last resourceVersion handed out by the store
+	resourceVersion uint64
+	// history contains the last events to resume watches from a resourceVersion
+	history []watch.Event
+	// compactedResourceVersion is the resourceVersion of the last event dropped from the history
+	compactedResourceVersion uint64
+	// watchers are the open watches of the store, nil once the store is destroyed
+	watchers map[*memoryWatcher]struct{}
+	// backend persists the objects, nil when the objects are only kept in memory
+	backend memoryBackend
+	// destroyOnce releases the backend and stops the watchers once, the state is shared by the copies of the
+	// memoryStore and by the versions of the resource
+	destroyOnce sync.Once
+}
+
+// memoryBackend persists the objects of a memoryStore, e.g. on the file system.
+type memoryBackend interface {
+	// save persists the object stored under the key
+	save(key types.NamespacedName, obj runtime.Object) error
+	// delete removes the object stored under the key, resourceVersion is the resourceVersion of the deletion
+	delete(key types.NamespacedName, resourceVersion uint64) error
+	// destroy releases the resources held by the backend
+	destroy()
+}
+
+func (r *memoryStore) New() runtime.Object {
+	return r.obj.New()
+}
+
+func (r *memoryStore) NewList() runtime.Object {
+	return r.obj.NewList()
+}
+
+// StorageVersion returns the version the backend persists the objects in, nil when the objects are only kept
+// in memory.
+func (r *memoryStore) StorageVersion() runtime.GroupVersioner {
+	if r.state.backend == nil {
+		return nil
+	}
+	return r.obj.GetGroupVersionResource().GroupVersion()
+}
+
+// Destroy releases the state of the store, Destroy may be called more than once.
+func (r *memoryStore) Destroy() {
+	r.state.destroyOnce.Do(func() {
+		if r.state.backend != nil {
+			r.state.backend.destroy()
+		}
+		r.state.mu.Lock()
+		defer r.state.mu.Unlock()
+		for w := range r.state.watchers {
+			w.stop()
+		}
+		r.state.watchers = nil
+	})
+}
+
+func (r *memoryStore) NamespaceScoped() bool {
+	return r.obj.NamespaceScoped()
+}
+
+func (r *memoryStore) GetSingularName() string {
+	return r.obj.GetSingularName()
+}
+
+func (r *memoryStore) ShortNames() []string {
+	return r.obj.GetShortNames()
+}
+
+func (r *memoryStore) Categories() []string {
+	return r.obj.GetCategories()
+}
+
+func (r *memoryStore) ConvertToTable(ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return r.tableConvertor.ConvertToTable(ctx, object, tableOptions)
+}
+
+func (r *memoryStore) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	r.state.mu.RLock()
+	defer r.state.mu.RUnlock()
+
+	obj, ok := r.state.objects[r.key(ctx, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(r.gr, name)
+	}
+	return obj.DeepCopyObject(), nil
+}
+
+// List returns the objects matching the options, at most Limit objects if it is set together with the continue
+// token of the next objects. Unlike the generic registry store the next objects are listed from the current
+// objects rather than from the objects of the first list, so they reflect the changes made in between.
+func (r *memoryStore) List(ctx context.Context, options *metainternalversion.ListOptions) (runtime.Object, error) {
+	matches, err := r.matcher(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	var limit int64
+	var after *types.NamespacedName
+	if options != nil {
+		limit = options.Limit
+		if options.Continue != "" {
+			key, err := decodeContinue(options.Continue)
+			if err != nil {
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid continue token: %v", err))
+			}
+			after = &key
+		}
+	}
+
+	r.state.mu.RLock()
+	defer r.state.mu.RUnlock()
+
+	list := r.obj.NewList()
+	v, err := utils.GetListPrt(list)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	var count int64
+	var last types.NamespacedName
+	for _, obj := range r.listObjects(ctx, options) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		key := types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}
+		if (after != nil && !keyAfter(key, *after)) || !matches(obj) {
+			continue
+		}
+		if limit > 0 && count == limit {
+			token, err := encodeContinue(last, r.state.resourceVersion)
+			if err != nil {
+				return nil, apierrors.NewInternalError(err)
+			}
+			listMeta.SetContinue(token)
+			break
+		}
+		utils.AppendItem(v, obj.DeepCopyObject())
+		count++
+		last = key
+	}
+	listMeta.SetResourceVersion(strconv.FormatUint(r.state.resourceVersion, 10))
+	return list, nil
+}
+
+func (r *memoryStore) Create(ctx context.Context, obj runtime.Object, createValidation rest.ValidateObjectFunc, options *metav1.CreateOptions) (runtime.Object, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	rest.FillObjectMetaSystemFields(accessor)
+	if len(accessor.GetGenerateName()) > 0 && len(accessor.GetName()) == 0 {
+		accessor.SetName(r.createStrategy.GenerateName(accessor.GetGenerateName()))
+	}
+	if err := rest.BeforeCreate(r.createStrategy, ctx, obj); err != nil {
+		return nil, err
+	}
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+
+	key := types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()}
+	if _, ok := r.state.objects[key]; ok {
+		return nil, apierrors.NewAlreadyExists(r.gr, accessor.GetName())
+	}
+	if accessor.GetGeneration() == 0 {
+		accessor.SetGeneration(1)
+	}
+	if dryrun.IsDryRun(options.DryRun) {
+		return obj, nil
+	}
+	accessor.SetResourceVersion(strconv.FormatUint(r.state.resourceVersion+1, 10))
+	if err := r.store(key, obj, watch.Added); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return obj.DeepCopyObject(), nil
+}
+
+func (r *memoryStore) Update(ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	key := r.key(ctx, name)
+	for {
+		old, ok := r.get(key)
+		if !ok {
+			if !forceAllowCreate && !r.updateStrategy.AllowCreateOnUpdate() {
+				return nil, false, apierrors.NewNotFound(r.gr, name)
+			}
+			obj, err := objInfo.UpdatedObject(ctx, nil)
+			if err != nil {
+				return nil, false, err
+			}
+			// the existence of the object is checked again while creating it
+			obj, err = r.Create(ctx, obj, createValidation, &metav1.CreateOptions{DryRun: options.DryRun})
+			return obj, true, err
+		}
+		obj, err := r.prepareUpdate(ctx, name, old, objInfo, updateValidation)
+		if err != nil {
+			return nil, false, err
+		}
+
+		r.state.mu.Lock()
+		// the update is prepared again on top of the current object if the object changed meanwhile
+		if r.state.objects[key] != old {
+			r.state.mu.Unlock()
+			continue
+		}
+		obj, err = r.commitUpdate(ctx, key, obj, old, options)
+		r.state.mu.Unlock()
+		return obj, false, err
+	}
+}
+
+// prepareUpdate returns the updated object validated against the stored object old, old is not changed.
+func (r *memoryStore) prepareUpdate(ctx context.Context, name string, old runtime.Object, objInfo rest.UpdatedObjectInfo, updateValidation rest.ValidateObjectUpdateFunc) (runtime.Object, error) {
+	if err := r.checkPreconditions(name, objInfo.Preconditions(), old); err != nil {
+		return nil, err
+	}
+	obj, err := objInfo.UpdatedObject(ctx, old.DeepCopyObject())
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	oldAccessor, err := meta.Accessor(old)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	switch accessor.GetResourceVersion() {
+	case oldAccessor.GetResourceVersion():
+	case "":
+		if !r.updateStrategy.AllowUnconditionalUpdate() {
+			return nil, apierrors.NewConflict(r.gr, name, fmt.Errorf("metadata.resourceVersion must be specified for an update"))
+		}
+	default:
+		return nil, apierrors.NewConflict(r.gr, name, errors.New(registry.OptimisticLockErrorMsg))
+	}
+
+	if err := rest.BeforeUpdate(r.updateStrategy, ctx, obj, old); err != nil {
+		return nil, err
+	}
+	// the changes of the managedFields timestamps alone do not make an update, like in the generic registry
+	// store
+	obj, err = fieldmanager.IgnoreManagedFieldsTimestampsTransformer(ctx, obj, old)
+	if err != nil {
+		return nil, err
+	}
+	if updateValidation != nil {
+		if err := updateValidation(ctx, obj, old); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// commitUpdate stores the updated object prepared on top of the stored object old, the caller must hold the
+// lock of the state.
+func (r *memoryStore) commitUpdate(ctx context.Context, key types.NamespacedName, obj, old runtime.Object, options *metav1.UpdateOptions) (runtime.Object, error) {
+	// the object marked for deletion is deleted once its finalizers are removed, like the generic registry
+	// store does
+	if registry.ShouldDeleteDuringUpdate(ctx, key.String(), obj, old) {
+		if dryrun.IsDryRun(options.DryRun) {
+			return obj, nil
+		}
+		if err := r.remove(key, obj.DeepCopyObject()); err != nil {
+			return nil, apierrors.NewInternalError(err)
+		}
+		return obj, nil
+	}
+	// an update without changes is not stored and keeps the resourceVersion, like the generic registry
+	// store does
+	oldResourceVersion, err := meta.NewAccessor().ResourceVersion(old)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	unchanged := obj.DeepCopyObject()
+	if err := meta.NewAccessor().SetResourceVersion(unchanged, oldResourceVersion); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	if apiequality.Semantic.DeepEqual(unchanged, old) {
+		return old.DeepCopyObject(), nil
+	}
+
+	// the resourceVersion is derived from the current revision of the store, the generation is only
+	// bumped when the spec changes
+	current := old.DeepCopyObject()
+	if err := meta.NewAccessor().SetResourceVersion(current, strconv.FormatUint(r.state.resourceVersion, 10)); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	if r.obj.IsEqual(ctx, obj, old) {
+		err = updateResourceVersion(obj, current)
+	} else {
+		err = utils.UpdateResourceVersionAndGeneration(obj, current)
+	}
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	if dryrun.IsDryRun(options.DryRun) {
+		return obj, nil
+	}
+	if err := r.store(key, obj, watch.Modified); err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	return obj.DeepCopyObject(), nil
+}
+
+func (r *memoryStore) Delete(ctx context.Context, name string, deleteValidation rest.ValidateObjectFunc, options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	return r.delete(ctx, r.key(ctx, name), deleteValidation, options)
+}
+
+func (r *memoryStore) DeleteCollection(ctx context.Context, deleteValidation rest.ValidateObjectFunc, options *metav1.DeleteOptions, listOptions *metainternalversion.ListOptions) (runtime.Object, error) {
+	matches, err := r.matcher(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	r.state.mu.RLock()
+	keys := []types.NamespacedName{}
+	for _, obj := range r.listObjects(ctx, listOptions) {
+		if !matches(obj) {
+			continue
+		}
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			r.state.mu.RUnlock()
+			return nil, apierrors.NewInternalError(err)
+		}
+		keys = append(keys, types.NamespacedName{Namespace: accessor.GetNamespace(), Name: accessor.GetName()})
+	}
+	r.state.mu.RUnlock()
+
+	list := r.obj.NewList()
+	v, err := utils.GetListPrt(list)
+	if err != nil {
+		return nil, apierrors.NewInternalError(err)
+	}
+	for _, key := range keys {
+		deleted, _, err := r.delete(ctx, key, deleteValidation, options)
+		if apierrors.IsNotFound(err) {
+			// the object was deleted meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		utils.AppendItem(v, deleted)
+	}
+	return list, nil
+}
+
+func (r *memoryStore) Watch(ctx context.Context, options *metainternalversion.ListOptions) (watch.Interface, error) {
+	matches, err := r.matcher(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	r.state.mu.Lock()
+	defer r.state.mu.Unlock()
+
+	if r.state.watchers == nil {
+		return nil, apierrors.NewServiceUnavailable(fmt.Sprintf("the storage of %s is destroyed", r.gr))
+	}
+	var initEvents []watch.Event
+	switch options.ResourceVersion {
+	case "", "0":
+		// start with the current state of the store
+		for _, obj := range r.listObjects(ctx, options) {
+			if matches(obj) {
+				initEvents = append(initEvents, watch.Event{Type: watch.Added, Object: obj.DeepCopyObject()})
+			}
+		}
+	default:
+		// resume from the history of the store
+		resourceVersion, err := strconv.ParseUint(options.ResourceVersion, 10, 64)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid resourceVersion %q: %v", options.ResourceVersion, err))
+		}
+		if resourceVersion < r.state.compactedResourceVersion {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", resourceVersion, r.state.compactedResourceVersion))
+		}
+		for _, event := range r.state.history {
+			if eventResourceVersion(event) > resourceVersion && matches(event.Object) {
+				initEvents = append(initEvents, watch.Event{Type: event.Type, Object: event.Object.DeepCopyObject()})
+			}
+		}
+	}
+	return newMemoryWatcher(r.state, initEvents, matches), nil
+}
+
+// delete removes the object from the store. An object with finalizers, including the finalizers of the
+// propagation policy of the options, or deleted gracefully is marked for deletion and stored until its
+// finalizers are removed or it is deleted with a grace period of 0.
+func (r *memoryStore) delete(ctx context.Context, key types.NamespacedName, deleteValidation rest.ValidateObjectFunc, options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	// nil options delete the object without grace period, like the generic registry store does
+	if options == nil {
+		options = metav1.NewDeleteOptions(0)
+	}
+	for {
+		obj, ok := r.get(key)
+		if !ok {
+			return nil, false, apierrors.NewNotFound(r.gr, key.Name)
+		}
+		if err := r.checkPreconditions(key.Name, options.Preconditions, obj); err != nil {
+			return nil, false, err
+		}
+		deleted := obj.DeepCopyObject()
+		if err := withDeleteHooks(r.deleteStrategy, deleteValidation)(ctx, deleted); err != nil {
+			return nil, false, err
+		}
+		graceful, pendingGraceful, err := rest.BeforeDelete(r.deleteStrategy, ctx, deleted, options)
+		if err != nil {
+			return nil, false, err
+		}
+		// the finalizers cannot be changed by the options once the graceful deletion is pending
+		if pendingGraceful {
+			return obj.DeepCopyObject(), false, nil
+		}
+		accessor, err := meta.Accessor(deleted)
+		if err != nil {
+			return nil, false, apierrors.NewInternalError(err)
+		}
+		if finalizers, changed := deletionFinalizers(accessor, options); changed {
+			accessor.SetFinalizers(finalizers)
+		}
+		pendingFinalizers := len(accessor.GetFinalizers()) != 0
+		if pendingFinalizers && !graceful {
+			markAsDeleting(accessor, metav1.Now())
+		}
+		keep := pendingFinalizers || (graceful && *options.GracePeriodSeconds > 0)
+		// deleting an object already marked for deletion leaves it unchanged
+		if keep && apiequality.Semantic.DeepEqual(deleted, obj) {
+			return deleted, false, nil
+		}
+		if dryrun.IsDryRun(options.DryRun) {
+			return deleted, !keep, nil
+		}
+
+		r.state.mu.Lock()
+		// the deletion is prepared again on top of the current object if the object changed meanwhile
+		if r.state.objects[key] != obj {
+			r.state.mu.Unlock()
+			continue
+		}
+		if keep {
+			accessor.SetResourceVersion(strconv.FormatUint(r.state.resourceVersion+1, 10))
+			err = r.store(key, deleted, watch.Modified)
+		} else {
+			err = r.remove(key, deleted)
+		}
+		r.state.mu.Unlock()
+		if err != nil {
+			return nil, false, apierrors.NewInternalError(err)
+		}
+		return deleted.DeepCopyObject(), !keep, nil
+	}
+}
+
+// encodeContinue returns the continue token of the objects listed after the object of the key, it is encoded
+// like the continue tokens of the generic registry store.
+func encodeContinue(key types.NamespacedName, resourceVersion uint64) (string, error) {
+	return storage.EncodeContinue("/"+continueKey(key), "/", int64(resourceVersion))
+}
+
+// decodeContinue returns the key of the last object listed before the continue token.
+func decodeContinue(token string) (types.NamespacedName, error) {
+	key, _, err := storage.DecodeContinue(token, "")
+	if err != nil {
+		return types.NamespacedName{}, err
+	}
+	if namespace, name, namespaced := strings.Cut(key, "/"); namespaced {
+		return types.NamespacedName{Namespace: namespace, Name: name}, nil
+	}
+	return types.NamespacedName{Name: key}, nil
+}
+
+// continueKey returns the key of the continue token of the object of the key, the namespace and the name of the
+// objects are path segments.
+func continueKey(key types.NamespacedName) string {
+	if key.Namespace == "" {
+		return key.Name
+	}
+	return key.Namespace + "/" + key.Name
+}
+
+// keyAfter returns true if the objects of key are listed after the objects of other, the objects are ordered by
+// namespace and name.
+func keyAfter(key, other types.NamespacedName) bool {
+	if key.Namespace != other.Namespace {
+		return key.Namespace > other.Namespace
+	}
+	return key.Name > other.Name
+}
+
+// updateResourceVersion sets the resourceVersion following the resourceVersion of old on obj, unlike
+// utils.UpdateResourceVersion the generation of obj is kept.
+func updateResourceVersion(obj, old runtime.Object) error {
+	accessor := meta.NewAccessor()
+	oldResourceVersion, err := accessor.ResourceVersion(old)
+	if err != nil {
+		return err
+	}
+	resourceVersion, err := strconv.ParseUint(oldResourceVersion, 10, 64)
+	if err != nil {
+		return err
+	}
+	return accessor.SetResourceVersion(obj, strconv.FormatUint(resourceVersion+1, 10))
+}
+
+// get returns the stored object of the key, the stored objects are replaced rather than changed so the object
+// may be read without the lock of the state but must not be changed.
+func (r *memoryStore) get(key types.NamespacedName) (runtime.Object, bool) {
+	r.state.mu.RLock()
+	defer r.state.mu.RUnlock()
+
+	obj, ok := r.state.objects[key]
+	return obj, ok
+}
+
+// store saves the object and notifies the watchers, the caller must hold the lock of the state and set
+// the next resourceVersion on the object.
+func (r *memoryStore) store(key types.NamespacedName, obj runtime.Object, eventType watch.EventType) error {
+	if r.state.backend != nil {
+		if err := r.state.backend.save(key, obj); err != nil {
+			return err
+		}
+	}
+	if old, ok := r.state.objects[key]; ok {
+		r.state.index.delete(key, old)
+	}
+	r.state.objects[key] = obj.DeepCopyObject()
+	r.state.index.add(key, r.state.objects[key])
+	r.record(eventType, obj.DeepCopyObject())
+	return nil
+}
+
+// remove deletes the object with the next resourceVersion and notifies the watchers, the caller must hold
+// the lock of the state.
+func (r *memoryStore) remove(key types.NamespacedName, deleted runtime.Object) error {
+	resourceVersion := r.state.resourceVersion + 1
+	if err := meta.NewAccessor().SetResourceVersion(deleted, strconv.FormatUint(resourceVersion, 10)); err != nil {
+		return err
+	}
+	if r.state.backend != nil {
+		if err := r.state.backend.delete(key, resourceVersion); err != nil {
+			return err
+		}
+	}
+	if old, ok := r.state.objects[key]; ok {
+		r.state.index.delete(key, old)
+	}
+	delete(r.state.objects, key)
+	r.record(watch.Deleted, deleted)
+	return nil
+}
+
+// record advances the resourceVersion of the store to the one of the event, adds the event to the
+// history and notifies the watchers, the caller must hold the lock of the state.
+func (r *memoryStore) record(eventType watch.EventType, obj runtime.Object) {
+	event := watch.Event{Type: eventType, Object: obj}
+	r.state.resourceVersion = eventResourceVersion(event)
+	r.state.history = append(r.state.history, event)
+	if len(r.state.history) > memoryWatchHistorySize {
+		r.state.compactedResourceVersion = eventResourceVersion(r.state.history[0])
+		r.state.history = r.state.history[1:]
+	}
+	event = watch.Event{Type: eventType, Object: obj.DeepCopyObject()}
+	for w := range r.state.watchers {
+		w.send(event)
+	}
+}
+
+func (r *memoryStore) checkPreconditions(name string, preconditions *metav1.Preconditions, obj runtime.Object) error {
+	if preconditions == nil {
+		return nil
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	if preconditions.UID != nil && *preconditions.UID != accessor.GetUID() {
+		return apierrors.NewConflict(r.gr, name, fmt.Errorf("precondition failed: UID in precondition: %v, UID in object meta: %v", *preconditions.UID, accessor.GetUID()))
+	}
+	if preconditions.ResourceVersion != nil && *preconditions.ResourceVersion != accessor.GetResourceVersion() {
+		return apierrors.NewConflict(r.gr, name, fmt.Errorf("precondition failed: ResourceVersion in precondition: %v, ResourceVersion in object meta: %v", *preconditions.ResourceVersion, accessor.GetResourceVersion()))
+	}
+	return nil
+}
+
+// matcher returns a function matching objects against the namespace of the request and the label and
+// field selectors of the options.
+func (r *memoryStore) matcher(ctx context.Context, options *metainternalversion.ListOptions) (func(obj runtime.Object) bool, error) {
+	label := labels.Everything()
+	if options != nil && options.LabelSelector != nil {
+		label = options.LabelSelector
+	}
+	var filter resource.Filter
+	if options != nil && options.FieldSelector != nil {
+		var err error
+		filter, err = r.fieldSelector()(ctx, options.FieldSelector)
+		if err != nil {
+			return nil, err
+		}
+	}
+	namespace, namespaced := genericapirequest.NamespaceFrom(ctx)
+	namespaced = namespaced && namespace != metav1.NamespaceAll && r.obj.NamespaceScoped()
+	return func(obj runtime.Object) bool {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		if namespaced && accessor.GetNamespace() != namespace {
+			return false
+		}
+		if !label.Matches(labels.Set(accessor.GetLabels())) {
+			return false
+		}
+		return filter == nil || !filter.Filter(ctx, obj)
+	}, nil
+}
+
+func (r *memoryStore) fieldSelector() func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	if fn := r.obj.FieldSelector(); fn != nil {
+		return fn
+	}
+	return utils.NewFieldSelector(r.obj)
+}
+
+func (r *memoryStore) key(ctx context.Context, name string) types.NamespacedName {
+	key := types.NamespacedName{Name: name}
+	if r.obj.NamespaceScoped() {
+		key.Namespace = genericapirequest.NamespaceValue(ctx)
+	}
+	return key
+}
+
+// listObjects returns the candidate objects of the list options ordered by namespace and name. Only the
+// objects of the index are returned when the selectors require an exact match on an indexing field or label
+// key, the caller must hold the lock of the state and still match the objects against the options.
+func (r *memoryStore) listObjects(ctx context.Context, options *metainternalversion.ListOptions) []runtime.Object {
+	if options == nil {
+		return r.sortedObjects()
+	}
+	pred := storage.SelectionPredicate{
+		Label:       options.LabelSelector,
+		Field:       options.FieldSelector,
+		IndexFields: utils.IndexFields(r.obj),
+		IndexLabels: utils.IndexLabels(r.obj),
+	}
+	if pred.Label == nil {
+		pred.Label = labels.Everything()
+	}
+	if pred.Field == nil {
+		pred.Field = fields.Everything()
+	}
+	keys, ok := r.state.index.lookup(pred.MatcherIndex(ctx))
+	if !ok {
+		return r.sortedObjects()
+	}
+	return r.objectsByKey(keys.UnsortedList())
+}
+
+// sortedObjects returns the objects ordered by namespace and name, the caller must hold the lock of the state.
+func (r *memoryStore) sortedObjects() []runtime.Object {
+	keys := make([]types.NamespacedName, 0, len(r.state.objects))
+	for key := range r.state.objects {
+		keys = append(keys, key)
+	}
+	return r.objectsByKey(keys)
+}
+
+// objectsByKey returns the objects of the keys ordered by namespace and name, the caller must hold the lock
+// of the state.
+func (r *memoryStore) objectsByKey(keys []types.NamespacedName) []runtime.Object {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Namespace != keys[j].Namespace {
+			return keys[i].Namespace < keys[j].Namespace
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	objs := make([]runtime.Object, 0, len(keys))
+	for _, key := range keys {
+		objs = append(objs, r.state.objects[key])
+	}
+	return objs
+}
+
+func eventResourceVersion(event watch.Event) uint64 {
+	accessor, err := meta.Accessor(event.Object)
+	if err != nil {
+		return 0
+	}
+	resourceVersion, _ := strconv.ParseUint(accessor.GetResourceVersion(), 10, 64)
+	return resourceVersion
+}
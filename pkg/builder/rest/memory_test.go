@@ -0,0 +1,495 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/utils/ptr"
+)
+
+var testGV = schema.GroupVersion{Group: "test.example.com", Version: "v1"}
+
+// testObject is the InternalObject stored by the stores under test, it is indexed by its spec and its app
+// label.
+type testObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              string `json:"spec,omitempty"`
+}
+
+type testObjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []testObject `json:"items"`
+}
+
+func (o *testObject) DeepCopyObject() runtime.Object {
+	c := *o
+	o.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (l *testObjectList) DeepCopyObject() runtime.Object {
+	c := &testObjectList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*testObject))
+	}
+	return c
+}
+
+func (o *testObject) GetObjectMeta() *metav1.ObjectMeta { return &o.ObjectMeta }
+func (o *testObject) NamespaceScoped() bool             { return true }
+func (o *testObject) New() runtime.Object               { return &testObject{} }
+func (o *testObject) NewList() runtime.Object           { return &testObjectList{} }
+func (o *testObject) IsStorageVersion() bool            { return true }
+func (o *testObject) GetSingularName() string           { return "testobject" }
+func (o *testObject) GetShortNames() []string           { return nil }
+func (o *testObject) GetCategories() []string           { return nil }
+func (o *testObject) GetGroupVersionResource() schema.GroupVersionResource {
+	return testGV.WithResource("testobjects")
+}
+func (o *testObject) TableConvertor() func(gr schema.GroupResource) rest.TableConvertor {
+	return nil
+}
+func (o *testObject) FieldLabelConversion() runtime.FieldLabelConversionFunc { return nil }
+func (o *testObject) FieldSelector() func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	return utils.NewFieldSelector(o)
+}
+func (o *testObject) PrepareForCreate(ctx context.Context, obj runtime.Object) {}
+func (o *testObject) ValidateCreate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return nil
+}
+func (o *testObject) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {}
+func (o *testObject) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return nil
+}
+func (o *testObject) IndexingFields() []string         { return []string{"spec"} }
+func (o *testObject) GetField(fieldName string) string { return o.Spec }
+func (o *testObject) IndexingLabelKeys() []string      { return []string{"app"} }
+func (o *testObject) IsEqual(ctx context.Context, obj, old runtime.Object) bool {
+	return obj.(*testObject).Spec == old.(*testObject).Spec
+}
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypes(testGV, &testObject{}, &testObjectList{})
+	metav1.AddToGroupVersion(scheme, testGV)
+	return scheme
+}
+
+// updateFunc updates the stored object like a patch, it is applied again when the update is retried.
+type updateFunc func(obj *testObject)
+
+func (f updateFunc) Preconditions() *metav1.Preconditions { return nil }
+
+func (f updateFunc) UpdatedObject(ctx context.Context, old runtime.Object) (runtime.Object, error) {
+	obj := old.DeepCopyObject().(*testObject)
+	f(obj)
+	return obj, nil
+}
+
+func TestMemoryStoreNoopUpdate(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+
+	created, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: "a"}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	w, err := store.Watch(ctx, &metainternalversion.ListOptions{ResourceVersion: created.(*testObject).ResourceVersion})
+	require.NoError(t, err)
+	t.Cleanup(w.Stop)
+
+	// an update without changes keeps the resourceVersion and notifies no watcher
+	updated, _, err := store.Update(ctx, "a", updateFunc(func(obj *testObject) {}), nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, created.(*testObject).ResourceVersion, updated.(*testObject).ResourceVersion)
+	assert.Equal(t, created.(*testObject).Generation, updated.(*testObject).Generation)
+
+	// the generation is only bumped when the spec changes
+	labeled, _, err := store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Labels = map[string]string{"app": "a"} }),
+		nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, created.(*testObject).ResourceVersion, labeled.(*testObject).ResourceVersion)
+	assert.Equal(t, created.(*testObject).Generation, labeled.(*testObject).Generation)
+	event := <-w.ResultChan()
+	assert.Equal(t, watch.Modified, event.Type)
+
+	updated, _, err = store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Spec = "b" }), nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.NotEqual(t, labeled.(*testObject).ResourceVersion, updated.(*testObject).ResourceVersion)
+	assert.Equal(t, created.(*testObject).Generation+1, updated.(*testObject).Generation)
+	event = <-w.ResultChan()
+	assert.Equal(t, watch.Modified, event.Type)
+	assert.Equal(t, "b", event.Object.(*testObject).Spec)
+}
+
+func TestMemoryStoreListChunks(t *testing.T) {
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+	// the namespaces are ordered before the names, "a" before "a-b"
+	for _, key := range []string{"a/x", "a/y", "a-b/x", "b/x", "b/y"} {
+		namespace, name, _ := strings.Cut(key, "/")
+		_, err := store.Create(genericapirequest.WithNamespace(context.Background(), namespace),
+			&testObject{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": name}}}, nil, &metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	listAll := func(options *metainternalversion.ListOptions) []string {
+		keys := []string{}
+		for {
+			list, err := store.List(genericapirequest.WithNamespace(context.Background(), metav1.NamespaceAll), options)
+			require.NoError(t, err)
+			items := list.(*testObjectList).Items
+			assert.LessOrEqual(t, int64(len(items)), options.Limit)
+			for _, item := range items {
+				keys = append(keys, item.Namespace+"/"+item.Name)
+			}
+			if list.(*testObjectList).Continue == "" {
+				return keys
+			}
+			options = &metainternalversion.ListOptions{
+				LabelSelector: options.LabelSelector,
+				Limit:         options.Limit,
+				Continue:      list.(*testObjectList).Continue,
+			}
+		}
+	}
+	assert.Equal(t, []string{"a/x", "a/y", "a-b/x", "b/x", "b/y"}, listAll(&metainternalversion.ListOptions{Limit: 2}))
+	assert.Equal(t, []string{"a/x", "a-b/x", "b/x"}, listAll(&metainternalversion.ListOptions{
+		Limit:         1,
+		LabelSelector: labels.SelectorFromSet(labels.Set{"app": "x"}),
+	}))
+
+	// the next objects are listed from the current objects
+	list, err := store.List(context.Background(), &metainternalversion.ListOptions{Limit: 2})
+	require.NoError(t, err)
+	require.NotEmpty(t, list.(*testObjectList).Continue)
+	_, _, err = store.Delete(genericapirequest.WithNamespace(context.Background(), "a-b"), "x", nil, nil)
+	require.NoError(t, err)
+	list, err = store.List(context.Background(), &metainternalversion.ListOptions{Continue: list.(*testObjectList).Continue})
+	require.NoError(t, err)
+	assert.Len(t, list.(*testObjectList).Items, 2)
+	assert.Empty(t, list.(*testObjectList).Continue)
+
+	_, err = store.List(context.Background(), &metainternalversion.ListOptions{Continue: "invalid"})
+	assert.True(t, apierrors.IsBadRequest(err), err)
+}
+
+func TestMemoryStoreConcurrentUpdates(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+
+	_, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	w, err := store.Watch(ctx, &metainternalversion.ListOptions{})
+	require.NoError(t, err)
+	t.Cleanup(w.Stop)
+
+	// the validation reads the store, e.g. like an admission plugin, while the other updates are committed
+	readStore := func(ctx context.Context, obj, old runtime.Object) error {
+		_, err := store.Get(ctx, "a", &metav1.GetOptions{})
+		return err
+	}
+	const updates = 20
+	var wg sync.WaitGroup
+	for i := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := store.Update(ctx, "a", updateFunc(func(obj *testObject) {
+				metav1.SetMetaDataLabel(&obj.ObjectMeta, fmt.Sprintf("update-%d", i), "done")
+			}), nil, readStore, false, &metav1.UpdateOptions{})
+			assert.NoError(t, err)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "the updates did not complete")
+	}
+
+	// no update is lost and the watcher receives every update in order
+	obj, err := store.Get(ctx, "a", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Len(t, obj.(*testObject).Labels, updates)
+	event := <-w.ResultChan()
+	require.Equal(t, watch.Added, event.Type)
+	resourceVersion := eventResourceVersion(event)
+	for range updates {
+		event := <-w.ResultChan()
+		require.Equal(t, watch.Modified, event.Type)
+		assert.Greater(t, eventResourceVersion(event), resourceVersion)
+		resourceVersion = eventResourceVersion(event)
+	}
+	assert.Equal(t, obj.(*testObject).ResourceVersion, fmt.Sprint(resourceVersion))
+}
+
+func TestMemoryStoreSlowWatcher(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+
+	w, err := store.Watch(ctx, &metainternalversion.ListOptions{})
+	require.NoError(t, err)
+	// the writers are not blocked by the watcher that does not read its events
+	for i := range memoryWatchQueueLength + 1 {
+		_, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("o%d", i)}}, nil, &metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	// the watcher is stopped once it lags behind, its client resumes from the last event it received
+	received := 0
+	for range w.ResultChan() {
+		received++
+	}
+	assert.Equal(t, memoryWatchQueueLength, received)
+	w.Stop()
+}
+
+func TestMemoryStoreIndexedList(t *testing.T) {
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+	for _, obj := range []*testObject{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a", Labels: map[string]string{"app": "web"}}, Spec: "x"},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b", Labels: map[string]string{"app": "db"}}, Spec: "x"},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "c", Labels: map[string]string{"app": "web", "tier": "front"}}, Spec: "y"},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "d", Labels: map[string]string{"app": "web"}}, Spec: "x"},
+	} {
+		ctx := genericapirequest.WithNamespace(context.Background(), obj.Namespace)
+		_, err := store.Create(ctx, obj, nil, &metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	tests := map[string]struct {
+		namespace  string
+		field      string
+		label      string
+		indexed    bool
+		candidates []string
+		want       []string
+	}{
+		"IndexingField": {
+			field:      "spec=x",
+			indexed:    true,
+			candidates: []string{"a", "b", "d"},
+			want:       []string{"a", "b", "d"},
+		},
+		"IndexingLabelKey": {
+			label:      "app=web",
+			indexed:    true,
+			candidates: []string{"a", "c", "d"},
+			want:       []string{"a", "c", "d"},
+		},
+		"IndexingFieldAndLabelKey": {
+			field:      "spec=x",
+			label:      "app=web",
+			indexed:    true,
+			candidates: []string{"a", "d"},
+			want:       []string{"a", "d"},
+		},
+		"IndexingFieldInNamespace": {
+			namespace:  "default",
+			field:      "spec=x",
+			indexed:    true,
+			candidates: []string{"a", "b", "d"},
+			want:       []string{"a", "b"},
+		},
+		"IndexingLabelKeyAndOtherLabel": {
+			label:      "app=web,tier=front",
+			indexed:    true,
+			candidates: []string{"a", "c", "d"},
+			want:       []string{"c"},
+		},
+		"IndexingFieldAndOtherField": {
+			field:      "spec=x,metadata.name!=b",
+			indexed:    true,
+			candidates: []string{"a", "b", "d"},
+			want:       []string{"a", "d"},
+		},
+		"UnknownIndexedValue": {
+			field:   "spec=z",
+			indexed: true,
+		},
+		"NotEquals": {
+			field: "spec!=x",
+			want:  []string{"c"},
+		},
+		"NotIndexed": {
+			label: "tier=front",
+			want:  []string{"c"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.namespace != "" {
+				ctx = genericapirequest.WithNamespace(ctx, tc.namespace)
+			}
+			options := &metainternalversion.ListOptions{}
+			if tc.field != "" {
+				var err error
+				options.FieldSelector, err = fields.ParseSelector(tc.field)
+				require.NoError(t, err)
+			}
+			if tc.label != "" {
+				var err error
+				options.LabelSelector, err = labels.Parse(tc.label)
+				require.NoError(t, err)
+			}
+
+			// only the objects of the index are visited when the selectors require an indexed value
+			store.state.mu.RLock()
+			candidates := store.listObjects(ctx, options)
+			store.state.mu.RUnlock()
+			if tc.indexed {
+				var names []string
+				for _, obj := range candidates {
+					names = append(names, obj.(*testObject).Name)
+				}
+				assert.Equal(t, tc.candidates, names)
+			} else {
+				assert.Len(t, candidates, 4)
+			}
+
+			list, err := store.List(ctx, options)
+			require.NoError(t, err)
+			var names []string
+			for _, obj := range list.(*testObjectList).Items {
+				names = append(names, obj.Name)
+			}
+			assert.Equal(t, tc.want, names)
+		})
+	}
+
+	// the field selectors of a direct List are not converted by the scheme, the unknown fields are rejected
+	_, err := store.List(context.Background(), &metainternalversion.ListOptions{FieldSelector: fields.ParseSelectorOrDie("spec.unknown!=x")})
+	assert.True(t, apierrors.IsBadRequest(err), err)
+}
+
+func TestMemoryStoreIndexUpdates(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	store := newMemoryStore(newTestScheme(), &testObject{})
+	t.Cleanup(store.Destroy)
+	list := func(selector string) []string {
+		t.Helper()
+		list, err := store.List(ctx, &metainternalversion.ListOptions{FieldSelector: fields.ParseSelectorOrDie(selector)})
+		require.NoError(t, err)
+		var names []string
+		for _, obj := range list.(*testObjectList).Items {
+			names = append(names, obj.Name)
+		}
+		return names
+	}
+
+	_, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Spec: "x"}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, list("spec=x"))
+
+	// the index follows the changes of the indexed values and the deletions
+	_, _, err = store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Spec = "y" }), nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, list("spec=x"))
+	assert.Equal(t, []string{"a"}, list("spec=y"))
+
+	_, _, err = store.Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, list("spec=y"))
+	assert.Empty(t, store.state.index.keys[storage.FieldIndex("spec")])
+}
+
+// gracefulObject is a testObject deleted gracefully, with a grace period of 30 seconds unless the delete options
+// set one.
+type gracefulObject struct {
+	testObject
+}
+
+func (o *gracefulObject) DeepCopyObject() runtime.Object {
+	return &gracefulObject{testObject: *o.testObject.DeepCopyObject().(*testObject)}
+}
+func (o *gracefulObject) New() runtime.Object { return &gracefulObject{} }
+func (o *gracefulObject) CheckGracefulDelete(ctx context.Context, options *metav1.DeleteOptions) bool {
+	if options.GracePeriodSeconds == nil {
+		options.GracePeriodSeconds = ptr.To[int64](30)
+	}
+	return true
+}
+
+func TestMemoryStoreGracefulDelete(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	scheme := newTestScheme()
+	scheme.AddKnownTypes(testGV, &gracefulObject{})
+	store := newMemoryStore(scheme, &gracefulObject{})
+	t.Cleanup(store.Destroy)
+
+	_, err := store.Create(ctx, &gracefulObject{testObject: testObject{ObjectMeta: metav1.ObjectMeta{Name: "a"}}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the object is marked for deletion with the grace period of the object
+	obj, deleted, err := store.Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	marked := obj.(*gracefulObject)
+	assert.NotNil(t, marked.DeletionTimestamp)
+	assert.Equal(t, ptr.To[int64](30), marked.DeletionGracePeriodSeconds)
+
+	// deleting it again without a shorter grace period leaves it unchanged
+	obj, deleted, err = store.Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	assert.Equal(t, marked.ResourceVersion, obj.(*gracefulObject).ResourceVersion)
+	obj, deleted, err = store.Delete(ctx, "a", nil, &metav1.DeleteOptions{GracePeriodSeconds: ptr.To[int64](60)})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	assert.Equal(t, marked.ResourceVersion, obj.(*gracefulObject).ResourceVersion)
+
+	// a shorter grace period is stored
+	obj, deleted, err = store.Delete(ctx, "a", nil, &metav1.DeleteOptions{GracePeriodSeconds: ptr.To[int64](10)})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	assert.Equal(t, ptr.To[int64](10), obj.(*gracefulObject).DeletionGracePeriodSeconds)
+	assert.NotEqual(t, marked.ResourceVersion, obj.(*gracefulObject).ResourceVersion)
+	stored, err := store.Get(ctx, "a", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, ptr.To[int64](10), stored.(*gracefulObject).DeletionGracePeriodSeconds)
+
+	// a grace period of 0 deletes it
+	_, deleted, err = store.Delete(ctx, "a", nil, &metav1.DeleteOptions{GracePeriodSeconds: ptr.To[int64](0)})
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = store.Get(ctx, "a", &metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+
+	// nil options delete it without grace period, like the generic registry store
+	_, err = store.Create(ctx, &gracefulObject{testObject: testObject{ObjectMeta: metav1.ObjectMeta{Name: "b"}}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, deleted, err = store.Delete(ctx, "b", nil, nil)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = store.Get(ctx, "b", &metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+}
@@ -10,8 +10,15 @@ type ResourceStorageProviderFn func(scheme *runtime.Scheme, getter genericregist
 
 type SubResourceStorageProviderFn func(scheme *runtime.Scheme, store rest.Storage) (rest.Storage, error)
 
+// ValidationRulesStorageFn returns the storage evaluating the x-kubernetes-validations rules of the resource on
+// top of the storage of the resource, see WithValidationRules.
+type ValidationRulesStorageFn func(storage rest.Storage, rules *ValidationRules) (rest.Storage, error)
+
 type StorageProvider struct {
 	ResourceStorageProviderFn            ResourceStorageProviderFn
 	StatusSubResourceStorageProviderFn   SubResourceStorageProviderFn
 	ArbitrarySubresourceHandlerProviders map[string]SubResourceStorageProviderFn
+	// ValidationRulesStorageFn is required to serve a resource with x-kubernetes-validations rules, it is
+	// WithValidationRules for the storage providers of this package.
+	ValidationRulesStorageFn ValidationRulesStorageFn
 }
@@ -0,0 +1,62 @@
+package rest
+
+import (
+	"context"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	metainternalversion "k8s.io/apimachinery/pkg/apis/meta/internalversion"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	registry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// WithInternalObjectHooks returns the storage of the InternalObject advertising the short names and the
+// categories of the object in discovery, e.g. so `kubectl get <shortname>` and `kubectl get all` work, and
+// calling the delete hooks of its delete strategy before an object is deleted or marked for deletion, see
+// DefaultStrategy.PrepareForDelete and DefaultStrategy.ValidateDelete. The generic registry store is wrapped,
+// the memory and file stores do both themselves. Other storages are returned as is, wrapping them would hide
+// their optional interfaces, they implement rest.ShortNamesProvider and rest.CategoriesProvider themselves.
+func WithInternalObjectHooks(storage rest.Storage, obj resource.InternalObject) rest.Storage {
+	store, ok := storage.(*registry.Store)
+	if !ok {
+		return storage
+	}
+	return &internalObjectStore{Store: store, obj: obj}
+}
+
+// WithDiscovery returns the storage of the InternalObject advertising the short names and the categories of
+// the object in discovery and calling the delete hooks of its delete strategy.
+//
+// Deprecated: use WithInternalObjectHooks, the storage also calls the delete hooks of the object.
+func WithDiscovery(storage rest.Storage, obj resource.InternalObject) rest.Storage {
+	return WithInternalObjectHooks(storage, obj)
+}
+
+var _ rest.ShortNamesProvider = &internalObjectStore{}
+var _ rest.CategoriesProvider = &internalObjectStore{}
+var _ rest.GracefulDeleter = &internalObjectStore{}
+var _ rest.CollectionDeleter = &internalObjectStore{}
+
+// internalObjectStore is a generic registry store advertising the short names and the categories of the
+// InternalObject in discovery and calling the delete hooks of its delete strategy
+type internalObjectStore struct {
+	*registry.Store
+	obj resource.InternalObject
+}
+
+func (r *internalObjectStore) ShortNames() []string {
+	return r.obj.GetShortNames()
+}
+
+func (r *internalObjectStore) Categories() []string {
+	return r.obj.GetCategories()
+}
+
+func (r *internalObjectStore) Delete(ctx context.Context, name string, deleteValidation rest.ValidateObjectFunc, options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	return r.Store.Delete(ctx, name, withDeleteHooks(r.DeleteStrategy, deleteValidation), options)
+}
+
+func (r *internalObjectStore) DeleteCollection(ctx context.Context, deleteValidation rest.ValidateObjectFunc, options *metav1.DeleteOptions, listOptions *metainternalversion.ListOptions) (runtime.Object, error) {
+	return r.Store.DeleteCollection(ctx, withDeleteHooks(r.DeleteStrategy, deleteValidation), options, listOptions)
+}
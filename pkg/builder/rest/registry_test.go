@@ -0,0 +1,81 @@
+package rest
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/henderiw/apiserver-builder/pkg/storage/sqlstorage"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// newTestEtcdStore returns the generic registry store of the testObjects wrapped by WithInternalObjectHooks, the
+// objects are stored in a SQLite database rather than in etcd.
+func newTestEtcdStore(t *testing.T) *internalObjectStore {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	backend, err := sqlstorage.NewBackend(ctx, db, sqlstorage.Options{
+		Dialect:            sqlstorage.SQLite,
+		PollInterval:       10 * time.Millisecond,
+		CompactionInterval: -1,
+	})
+	require.NoError(t, err)
+	t.Cleanup(backend.Close)
+
+	scheme := newTestScheme()
+	codecs := serializer.NewCodecFactory(scheme)
+	info, _ := runtime.SerializerInfoForMediaType(codecs.SupportedMediaTypes(), runtime.ContentTypeJSON)
+	codec := codecs.CodecForVersions(info.Serializer, info.Serializer, testGV, testGV)
+	store, err := NewEtcdStore(scheme, sqlstorage.NewRESTOptionsGetter(backend, codec), &testObject{})
+	require.NoError(t, err)
+	t.Cleanup(store.Destroy)
+	storage, ok := WithInternalObjectHooks(store, &testObject{}).(*internalObjectStore)
+	require.True(t, ok)
+	return storage
+}
+
+func TestInternalObjectStoreDeleteDuringUpdate(t *testing.T) {
+	ctx := genericapirequest.WithNamespace(context.Background(), "default")
+	store := newTestEtcdStore(t)
+
+	_, err := store.Create(ctx, &testObject{ObjectMeta: metav1.ObjectMeta{
+		Name:       "a",
+		Finalizers: []string{"test.example.com/cleanup"},
+	}}, nil, &metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	// the object is marked for deletion until its finalizers are removed
+	obj, deleted, err := store.Delete(ctx, "a", nil, &metav1.DeleteOptions{})
+	require.NoError(t, err)
+	assert.False(t, deleted)
+	assert.NotNil(t, obj.(*testObject).DeletionTimestamp)
+
+	// an update keeping a finalizer keeps the object
+	_, _, err = store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Spec = "b" }),
+		nil, nil, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	stored, err := store.Get(ctx, "a", &metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "b", stored.(*testObject).Spec)
+
+	// the update removing the last finalizer deletes the object
+	_, _, err = store.Update(ctx, "a", updateFunc(func(obj *testObject) { obj.Finalizers = nil }),
+		rest.ValidateAllObjectFunc, rest.ValidateAllObjectUpdateFunc, false, &metav1.UpdateOptions{})
+	require.NoError(t, err)
+	_, err = store.Get(ctx, "a", &metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+}
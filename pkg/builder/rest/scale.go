@@ -0,0 +1,157 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// NewScaleSubResourceStorage returns the storage of the scale subresource. The scale is read from and
+// written to the parent object through the ObjectWithScaleSubResource interface.
+func NewScaleSubResourceStorage(parentStorage rest.Storage) (rest.Storage, error) {
+	getter, ok := parentStorage.(rest.Getter)
+	if !ok {
+		return nil, fmt.Errorf("parent storage %T must implement rest.Getter to serve the scale subresource", parentStorage)
+	}
+	updater, ok := parentStorage.(rest.Updater)
+	if !ok {
+		return nil, fmt.Errorf("parent storage %T must implement rest.Updater to serve the scale subresource", parentStorage)
+	}
+	return &scaleSubResourceStorage{
+		parentStorageGetter:  getter,
+		parentStorageUpdater: updater,
+	}, nil
+}
+
+var _ rest.GroupVersionKindProvider = &scaleSubResourceStorage{}
+var _ rest.Getter = &scaleSubResourceStorage{}
+var _ rest.Updater = &scaleSubResourceStorage{}
+
+// scaleSubResourceStorage serves the scale subresource
+type scaleSubResourceStorage struct {
+	parentStorageGetter  rest.Getter
+	parentStorageUpdater rest.Updater
+}
+
+func (s *scaleSubResourceStorage) GroupVersionKind(containingGV schema.GroupVersion) schema.GroupVersionKind {
+	return autoscalingv1.SchemeGroupVersion.WithKind("Scale")
+}
+
+func (s *scaleSubResourceStorage) New() runtime.Object {
+	return &autoscalingv1.Scale{}
+}
+
+// Destroy is a no-op, the parent storage is destroyed by its owner.
+func (s *scaleSubResourceStorage) Destroy() {}
+
+func (s *scaleSubResourceStorage) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	parentObj, err := s.parentStorageGetter.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	scaleParentObj, ok := parentObj.(resource.ObjectWithScaleSubResource)
+	if !ok {
+		return nil, fmt.Errorf("not a valid parent object, does it implement resource.ObjectWithScaleSubResource interface?")
+	}
+	return scaleFromParent(scaleParentObj), nil
+}
+
+func (s *scaleSubResourceStorage) Update(ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	updatedObj, updated, err := s.parentStorageUpdater.Update(
+		ctx,
+		name,
+		&scaleUpdatedObjectInfo{reqObjInfo: objInfo},
+		toScaleCreateValidation(createValidation),
+		toScaleUpdateValidation(updateValidation),
+		false,
+		options)
+	if err != nil {
+		return nil, false, err
+	}
+	return scaleFromParent(updatedObj.(resource.ObjectWithScaleSubResource)), updated, nil
+}
+
+// scaleFromParent returns the scale of the parent object with the metadata of the parent so the
+// resourceVersion can be used as a precondition by the client.
+func scaleFromParent(parent resource.ObjectWithScaleSubResource) *autoscalingv1.Scale {
+	scale := parent.GetScale()
+	if scale == nil {
+		scale = &autoscalingv1.Scale{}
+	}
+	meta := parent.GetObjectMeta()
+	scale.Name = meta.Name
+	scale.Namespace = meta.Namespace
+	scale.UID = meta.UID
+	scale.ResourceVersion = meta.ResourceVersion
+	scale.CreationTimestamp = meta.CreationTimestamp
+	return scale
+}
+
+var _ rest.UpdatedObjectInfo = &scaleUpdatedObjectInfo{}
+
+// scaleUpdatedObjectInfo transforms the scale of the request into an update of the parent object.
+type scaleUpdatedObjectInfo struct {
+	reqObjInfo rest.UpdatedObjectInfo
+}
+
+func (s *scaleUpdatedObjectInfo) Preconditions() *metav1.Preconditions {
+	return s.reqObjInfo.Preconditions()
+}
+
+func (s *scaleUpdatedObjectInfo) UpdatedObject(ctx context.Context, oldObj runtime.Object) (newObj runtime.Object, err error) {
+	oldObjWithScale, ok := oldObj.DeepCopyObject().(resource.ObjectWithScaleSubResource)
+	if !ok {
+		return nil, errors.NewBadRequest(fmt.Sprintf("object does not implement the scale subresource: %T", oldObj))
+	}
+	obj, err := s.reqObjInfo.UpdatedObject(ctx, scaleFromParent(oldObjWithScale))
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, errors.NewBadRequest("nil update passed to Scale")
+	}
+	scale, ok := obj.(*autoscalingv1.Scale)
+	if !ok {
+		return nil, errors.NewBadRequest(fmt.Sprintf("wrong object passed to Scale update: %v", obj))
+	}
+	oldObjWithScale.SetScale(scale)
+	if len(scale.ResourceVersion) != 0 {
+		// The client provided a resourceVersion precondition.
+		// Set that precondition and return any conflict errors to the client.
+		oldObjWithScale.GetObjectMeta().ResourceVersion = scale.ResourceVersion
+	}
+	return oldObjWithScale, nil
+}
+
+func toScaleCreateValidation(f rest.ValidateObjectFunc) rest.ValidateObjectFunc {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, obj runtime.Object) error {
+		return f(ctx, scaleFromParent(obj.(resource.ObjectWithScaleSubResource)))
+	}
+}
+
+func toScaleUpdateValidation(f rest.ValidateObjectUpdateFunc) rest.ValidateObjectUpdateFunc {
+	if f == nil {
+		return nil
+	}
+	return func(ctx context.Context, obj, old runtime.Object) error {
+		return f(ctx,
+			scaleFromParent(obj.(resource.ObjectWithScaleSubResource)),
+			scaleFromParent(old.(resource.ObjectWithScaleSubResource)))
+	}
+}
@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	registry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+// NewStatusSubResourceStorageProviderFn returns a SubResourceStorageProviderFn serving the status subresource
+// of the resource on top of the storage of the parent resource.
+func NewStatusSubResourceStorageProviderFn(obj resource.ObjectWithStatusSubResource) SubResourceStorageProviderFn {
+	return func(scheme *runtime.Scheme, store rest.Storage) (rest.Storage, error) {
+		return NewStatusSubResourceStorage(obj, store)
+	}
+}
+
+// NewStatusSubResourceStorage returns the storage of the status subresource. Updates through this storage
+// only persist changes to the status, changes to the spec and metadata are ignored.
+func NewStatusSubResourceStorage(obj resource.ObjectWithStatusSubResource, parentStorage rest.Storage) (rest.Storage, error) {
+	switch parentStore := parentStorage.(type) {
+	case *registry.Store:
+		statusStore := *parentStore
+		statusStore.UpdateStrategy = &statusSubResourceStrategy{
+			RESTUpdateStrategy: parentStore.UpdateStrategy,
+			obj:                obj,
+		}
+		return &statusSubResourceStorage{store: &statusStore}, nil
+	case *memoryStore:
+		statusStore := *parentStore
+		statusStore.updateStrategy = &statusSubResourceStrategy{
+			RESTUpdateStrategy: parentStore.updateStrategy,
+			obj:                obj,
+		}
+		return &statusSubResourceStorage{store: &statusStore}, nil
+	default:
+		return nil, fmt.Errorf("parent storage of %s must be a builder or generic registry store to serve the status subresource, got %T",
+			obj.GetGroupVersionResource().GroupResource().String(), parentStorage)
+	}
+}
+
+var _ rest.Getter = &statusSubResourceStorage{}
+var _ rest.Updater = &statusSubResourceStorage{}
+
+// statusSubResourceStorage serves the status subresource
+type statusSubResourceStorage struct {
+	store interface {
+		rest.Storage
+		rest.Getter
+		rest.Updater
+	}
+}
+
+func (r *statusSubResourceStorage) New() runtime.Object {
+	return r.store.New()
+}
+
+// Destroy is a no-op, the underlying store is shared with the parent storage and destroyed by it.
+func (r *statusSubResourceStorage) Destroy() {}
+
+func (r *statusSubResourceStorage) Get(ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	return r.store.Get(ctx, name, options)
+}
+
+func (r *statusSubResourceStorage) Update(ctx context.Context,
+	name string,
+	objInfo rest.UpdatedObjectInfo,
+	createValidation rest.ValidateObjectFunc,
+	updateValidation rest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool,
+	options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	// the status subresource never creates the parent object
+	return r.store.Update(ctx, name, objInfo, createValidation, updateValidation, false, options)
+}
+
+// statusUpdateValidator is implemented by the update strategies of the parent storage that also validate the
+// updates of the status subresource, e.g. the strategy evaluating the x-kubernetes-validations rules.
+type statusUpdateValidator interface {
+	ValidateStatusUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList
+}
+
+var _ statusUpdateValidator = &validationRulesUpdateStrategy{}
+
+var _ rest.RESTUpdateStrategy = &statusSubResourceStrategy{}
+
+// statusSubResourceStrategy defines the update strategy of the status subresource.
+type statusSubResourceStrategy struct {
+	rest.RESTUpdateStrategy
+
+	obj resource.ObjectWithStatusSubResource
+}
+
+// PrepareForUpdate only retains the status of the updated object, the rest of the object is reset to the
+// stored object before PrepareForStatusUpdate is called. A status equal to the stored status, see
+// IsStatusEqual, is not retained either so the update is not stored and keeps the resourceVersion.
+func (r *statusSubResourceStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	// should panic/fail-fast upon casting failure
+	statusObj := obj.(resource.ObjectWithStatusSubResource)
+	updated := old.DeepCopyObject().(resource.ObjectWithStatusSubResource)
+	if !r.obj.IsStatusEqual(ctx, obj, old) {
+		statusObj.GetStatus().CopyTo(updated)
+	}
+	// keep the request fields the apiserver relies on after this point
+	updated.GetObjectMeta().ResourceVersion = statusObj.GetObjectMeta().ResourceVersion
+	updated.GetObjectMeta().ManagedFields = statusObj.GetObjectMeta().ManagedFields
+	if err := util.DeepCopy(updated, obj); err != nil {
+		utilruntime.HandleError(err)
+	}
+	r.obj.PrepareForStatusUpdate(ctx, obj, old)
+}
+
+func (r *statusSubResourceStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	errs := field.ErrorList{}
+	if validator, ok := r.RESTUpdateStrategy.(statusUpdateValidator); ok {
+		errs = validator.ValidateStatusUpdate(ctx, obj, old)
+	}
+	return append(errs, r.obj.ValidateStatusUpdate(ctx, obj, old)...)
+}
+
+func (r *statusSubResourceStrategy) WarningsOnUpdate(ctx context.Context, obj, old runtime.Object) []string {
+	return nil
+}
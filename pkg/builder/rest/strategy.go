@@ -0,0 +1,206 @@
+package rest
+
+import (
+	"context"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcestrategy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/apiserver/pkg/storage/names"
+)
+
+var _ rest.RESTCreateStrategy = DefaultStrategy{}
+var _ rest.RESTUpdateStrategy = DefaultStrategy{}
+var _ rest.RESTDeleteStrategy = DefaultStrategy{}
+var _ rest.RESTGracefulDeleteStrategy = DefaultStrategy{}
+var _ rest.TableConvertor = DefaultStrategy{}
+
+// DefaultStrategy implements the create, update and delete strategies by dispatching to the optional
+// interfaces of resourcestrategy implemented by the objects, e.g. a resource validates its objects by
+// implementing resourcestrategy.Validater. DefaultStrategy may be embedded into other strategies.
+type DefaultStrategy struct {
+	runtime.ObjectTyper
+
+	// Object is the storage version of the resource, it decides if the resource is namespace scoped and
+	// if it allows to create on update or to update unconditionally.
+	Object runtime.Object
+	// TableConvertor converts the objects that do not implement resourcestrategy.TableConverter, the
+	// default table convertor is used if it is nil.
+	TableConvertor rest.TableConvertor
+}
+
+// NewDefaultStrategy returns the DefaultStrategy of the Object. The objects are printed with the columns of the
+// Object if it implements resource.ObjectWithTableColumns, the table convertor of the columns is built once.
+func NewDefaultStrategy(typer runtime.ObjectTyper, obj runtime.Object) DefaultStrategy {
+	s := DefaultStrategy{ObjectTyper: typer, Object: obj}
+	if o, ok := obj.(resource.ObjectWithTableColumns); ok {
+		// the invalid columns are reported when the apiserver is built, they are not printed
+		s.TableConvertor, _ = NewTableConvertor(o.TableColumns())
+	}
+	return s
+}
+
+// GenerateName appends a random suffix to the base name.
+func (d DefaultStrategy) GenerateName(base string) string {
+	return names.SimpleNameGenerator.GenerateName(base)
+}
+
+// NamespaceScoped returns true unless the Object is a cluster scoped resource.
+func (d DefaultStrategy) NamespaceScoped() bool {
+	if scoper, ok := d.Object.(interface{ NamespaceScoped() bool }); ok {
+		return scoper.NamespaceScoped()
+	}
+	return true
+}
+
+// PrepareForCreate calls PrepareForCreate if the object implements resourcestrategy.PrepareForCreater.
+func (d DefaultStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	if v, ok := obj.(resourcestrategy.PrepareForCreater); ok {
+		v.PrepareForCreate(ctx)
+	}
+}
+
+// Validate calls Validate if the object implements resourcestrategy.Validater.
+func (d DefaultStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	if v, ok := obj.(resourcestrategy.Validater); ok {
+		return v.Validate(ctx)
+	}
+	return field.ErrorList{}
+}
+
+func (d DefaultStrategy) WarningsOnCreate(ctx context.Context, obj runtime.Object) []string {
+	return nil
+}
+
+// Canonicalize calls Canonicalize if the object implements resourcestrategy.Canonicalizer.
+func (d DefaultStrategy) Canonicalize(obj runtime.Object) {
+	if c, ok := obj.(resourcestrategy.Canonicalizer); ok {
+		c.Canonicalize()
+	}
+}
+
+// AllowCreateOnUpdate returns false unless the Object implements resourcestrategy.AllowCreateOnUpdater.
+func (d DefaultStrategy) AllowCreateOnUpdate() bool {
+	if v, ok := d.Object.(resourcestrategy.AllowCreateOnUpdater); ok {
+		return v.AllowCreateOnUpdate()
+	}
+	return false
+}
+
+// PrepareForUpdate calls PrepareForUpdate if the object implements resourcestrategy.PrepareForUpdater.
+func (d DefaultStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	if v, ok := obj.(resourcestrategy.PrepareForUpdater); ok {
+		v.PrepareForUpdate(ctx, old)
+	}
+}
+
+// ValidateUpdate calls ValidateUpdate if the object implements resourcestrategy.ValidateUpdater.
+func (d DefaultStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	if v, ok := obj.(resourcestrategy.ValidateUpdater); ok {
+		return v.ValidateUpdate(ctx, old)
+	}
+	return field.ErrorList{}
+}
+
+func (d DefaultStrategy) WarningsOnUpdate(ctx context.Context, obj, old runtime.Object) []string {
+	return nil
+}
+
+// AllowUnconditionalUpdate returns false unless the Object implements resourcestrategy.AllowUnconditionalUpdater.
+func (d DefaultStrategy) AllowUnconditionalUpdate() bool {
+	if v, ok := d.Object.(resourcestrategy.AllowUnconditionalUpdater); ok {
+		return v.AllowUnconditionalUpdate()
+	}
+	return false
+}
+
+// PrepareForDelete calls PrepareForDelete if the object implements resourcestrategy.PrepareForDeleter.
+func (d DefaultStrategy) PrepareForDelete(ctx context.Context, obj runtime.Object) {
+	if v, ok := obj.(resourcestrategy.PrepareForDeleter); ok {
+		v.PrepareForDelete(ctx)
+	}
+}
+
+// ValidateDelete calls ValidateDelete if the object implements resourcestrategy.ValidateDeleter.
+func (d DefaultStrategy) ValidateDelete(ctx context.Context, obj runtime.Object) field.ErrorList {
+	if v, ok := obj.(resourcestrategy.ValidateDeleter); ok {
+		return v.ValidateDelete(ctx)
+	}
+	return field.ErrorList{}
+}
+
+// CheckGracefulDelete calls CheckGracefulDelete if the object implements resourcestrategy.GracefulDeleter, the
+// other objects are deleted immediately unless they have finalizers.
+func (d DefaultStrategy) CheckGracefulDelete(ctx context.Context, obj runtime.Object, options *metav1.DeleteOptions) bool {
+	if v, ok := obj.(resourcestrategy.GracefulDeleter); ok {
+		return v.CheckGracefulDelete(ctx, options)
+	}
+	return false
+}
+
+// ConvertToTable calls ConvertToTable if the object implements resourcestrategy.TableConverter, the other
+// objects are converted by the TableConvertor.
+func (d DefaultStrategy) ConvertToTable(ctx context.Context, obj runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	if c, ok := obj.(resourcestrategy.TableConverter); ok {
+		return c.ConvertToTable(ctx, tableOptions)
+	}
+	if d.TableConvertor != nil {
+		return d.TableConvertor.ConvertToTable(ctx, obj, tableOptions)
+	}
+	return rest.NewDefaultTableConvertor(d.qualifiedResource()).ConvertToTable(ctx, obj, tableOptions)
+}
+
+// qualifiedResource returns the GroupResource of the Object, it is empty if the Object is not a resource.Object.
+func (d DefaultStrategy) qualifiedResource() schema.GroupResource {
+	if obj, ok := d.Object.(resource.Object); ok {
+		return obj.GetGroupVersionResource().GroupResource()
+	}
+	return schema.GroupResource{}
+}
+
+var _ rest.RESTCreateStrategy = &internalObjectStrategy{}
+var _ rest.RESTUpdateStrategy = &internalObjectStrategy{}
+var _ rest.RESTDeleteStrategy = &internalObjectStrategy{}
+var _ rest.RESTGracefulDeleteStrategy = &internalObjectStrategy{}
+
+// internalObjectStrategy implements the create, update and delete strategies by dispatching to the
+// hooks of the InternalObject and to the optional interfaces of resourcestrategy, see DefaultStrategy.
+// The hooks replace resourcestrategy.PrepareForCreater, resourcestrategy.PrepareForUpdater and
+// resourcestrategy.ValidateUpdater, an InternalObject cannot implement them as their methods have the
+// names of the hooks.
+type internalObjectStrategy struct {
+	DefaultStrategy
+
+	obj resource.InternalObject
+}
+
+func newInternalObjectStrategy(typer runtime.ObjectTyper, obj resource.InternalObject) *internalObjectStrategy {
+	s := NewDefaultStrategy(typer, obj)
+	// the columns take precedence over the table convertor returned by the object
+	if s.TableConvertor == nil {
+		if fn := obj.TableConvertor(); fn != nil {
+			s.TableConvertor = fn(obj.GetGroupVersionResource().GroupResource())
+		}
+	}
+	return &internalObjectStrategy{DefaultStrategy: s, obj: obj}
+}
+
+func (r *internalObjectStrategy) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	r.obj.PrepareForCreate(ctx, obj)
+}
+
+func (r *internalObjectStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return append(r.obj.ValidateCreate(ctx, obj), r.DefaultStrategy.Validate(ctx, obj)...)
+}
+
+func (r *internalObjectStrategy) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	r.obj.PrepareForUpdate(ctx, obj, old)
+}
+
+func (r *internalObjectStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return r.obj.ValidateUpdate(ctx, obj, old)
+}
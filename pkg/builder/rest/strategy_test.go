@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+)
+
+// hookObject implements the optional interfaces of resourcestrategy and records the hooks called.
+type hookObject struct {
+	metav1.TypeMeta
+	metav1.ObjectMeta
+	calls []string
+}
+
+func (o *hookObject) DeepCopyObject() runtime.Object { c := *o; return &c }
+func (o *hookObject) NamespaceScoped() bool          { return false }
+func (o *hookObject) AllowCreateOnUpdate() bool      { return true }
+func (o *hookObject) AllowUnconditionalUpdate() bool { return true }
+func (o *hookObject) Canonicalize()                  { o.calls = append(o.calls, "Canonicalize") }
+func (o *hookObject) PrepareForCreate(ctx context.Context) {
+	o.calls = append(o.calls, "PrepareForCreate")
+}
+func (o *hookObject) PrepareForUpdate(ctx context.Context, old runtime.Object) {
+	o.calls = append(o.calls, "PrepareForUpdate")
+}
+func (o *hookObject) Validate(ctx context.Context) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "create")}
+}
+func (o *hookObject) ValidateUpdate(ctx context.Context, old runtime.Object) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "update")}
+}
+func (o *hookObject) PrepareForDelete(ctx context.Context) {
+	o.calls = append(o.calls, "PrepareForDelete")
+}
+func (o *hookObject) ValidateDelete(ctx context.Context) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "delete")}
+}
+func (o *hookObject) CheckGracefulDelete(ctx context.Context, options *metav1.DeleteOptions) bool {
+	options.GracePeriodSeconds = ptr.To[int64](30)
+	return true
+}
+func (o *hookObject) ConvertToTable(ctx context.Context, tableOptions runtime.Object) (*metav1.Table, error) {
+	return &metav1.Table{ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Hook"}}}, nil
+}
+
+func TestDefaultStrategy(t *testing.T) {
+	ctx := context.Background()
+	obj := &hookObject{}
+	s := DefaultStrategy{Object: obj}
+
+	assert.False(t, s.NamespaceScoped())
+	assert.True(t, s.AllowCreateOnUpdate())
+	assert.True(t, s.AllowUnconditionalUpdate())
+	s.PrepareForCreate(ctx, obj)
+	s.PrepareForUpdate(ctx, obj, &hookObject{})
+	s.Canonicalize(obj)
+	s.PrepareForDelete(ctx, obj)
+	assert.Equal(t, []string{"PrepareForCreate", "PrepareForUpdate", "Canonicalize", "PrepareForDelete"}, obj.calls)
+	assert.Equal(t, "create", s.Validate(ctx, obj)[0].Detail)
+	assert.Equal(t, "update", s.ValidateUpdate(ctx, obj, &hookObject{})[0].Detail)
+	assert.Equal(t, "delete", s.ValidateDelete(ctx, obj)[0].Detail)
+	options := &metav1.DeleteOptions{}
+	assert.True(t, s.CheckGracefulDelete(ctx, obj, options))
+	assert.Equal(t, ptr.To[int64](30), options.GracePeriodSeconds)
+	table, err := s.ConvertToTable(ctx, obj, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hook", table.ColumnDefinitions[0].Name)
+
+	// the objects without the optional interfaces fall back to the defaults
+	plain := DefaultStrategy{Object: &metav1.PartialObjectMetadata{}}
+	assert.True(t, plain.NamespaceScoped())
+	assert.False(t, plain.AllowCreateOnUpdate())
+	assert.False(t, plain.AllowUnconditionalUpdate())
+	assert.Empty(t, plain.Validate(ctx, &metav1.PartialObjectMetadata{}))
+	assert.Empty(t, plain.ValidateUpdate(ctx, &metav1.PartialObjectMetadata{}, &metav1.PartialObjectMetadata{}))
+	assert.Empty(t, plain.ValidateDelete(ctx, &metav1.PartialObjectMetadata{}))
+	assert.False(t, plain.CheckGracefulDelete(ctx, &metav1.PartialObjectMetadata{}, &metav1.DeleteOptions{}))
+	table, err = plain.ConvertToTable(ctx, &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, nil)
+	assert.NoError(t, err)
+	assert.Len(t, table.Rows, 1)
+}
+
+// hookInternalObject is an InternalObject implementing the hooks and the optional interfaces of
+// resourcestrategy that do not clash with them, it records the hooks called.
+type hookInternalObject struct {
+	testObject
+	calls []string
+}
+
+func (o *hookInternalObject) PrepareForCreate(ctx context.Context, obj runtime.Object) {
+	o.calls = append(o.calls, "PrepareForCreate")
+}
+func (o *hookInternalObject) ValidateCreate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "create")}
+}
+func (o *hookInternalObject) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {
+	o.calls = append(o.calls, "PrepareForUpdate")
+}
+func (o *hookInternalObject) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "update")}
+}
+func (o *hookInternalObject) Validate(ctx context.Context) field.ErrorList {
+	return field.ErrorList{field.Required(field.NewPath("spec"), "validate")}
+}
+func (o *hookInternalObject) Canonicalize() { o.calls = append(o.calls, "Canonicalize") }
+func (o *hookInternalObject) PrepareForDelete(ctx context.Context) {
+	o.calls = append(o.calls, "PrepareForDelete")
+}
+
+func TestInternalObjectStrategy(t *testing.T) {
+	ctx := context.Background()
+	obj := &hookInternalObject{}
+	s := newInternalObjectStrategy(newTestScheme(), obj)
+
+	// the hooks are called once, the optional interfaces of resourcestrategy are called next to them
+	s.PrepareForCreate(ctx, obj)
+	s.PrepareForUpdate(ctx, obj, &hookInternalObject{})
+	s.Canonicalize(obj)
+	s.PrepareForDelete(ctx, obj)
+	assert.Equal(t, []string{"PrepareForCreate", "PrepareForUpdate", "Canonicalize", "PrepareForDelete"}, obj.calls)
+
+	var details []string
+	for _, err := range s.Validate(ctx, obj) {
+		details = append(details, err.Detail)
+	}
+	assert.Equal(t, []string{"create", "validate"}, details)
+	errs := s.ValidateUpdate(ctx, obj, &hookInternalObject{})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "update", errs[0].Detail)
+}
+
+// columnsObject is a resource printed with its table columns.
+type columnsObject struct {
+	testObject
+}
+
+func (o *columnsObject) TableColumns() []resource.TableColumn {
+	return []resource.TableColumn{{Name: "UID", Type: "string", JSONPath: ".metadata.uid"}}
+}
+
+func TestNewDefaultStrategy(t *testing.T) {
+	obj := &columnsObject{testObject: testObject{ObjectMeta: metav1.ObjectMeta{Name: "a", UID: "1"}}}
+	s := NewDefaultStrategy(nil, obj)
+	require.NotNil(t, s.TableConvertor)
+	table, err := s.ConvertToTable(context.Background(), obj, nil)
+	require.NoError(t, err)
+	require.Len(t, table.ColumnDefinitions, 3)
+	assert.Equal(t, "UID", table.ColumnDefinitions[1].Name)
+	assert.Equal(t, []interface{}{"a", "1", "<unknown>"}, table.Rows[0].Cells)
+
+	// the objects without columns are printed by the default table convertor
+	assert.Nil(t, NewDefaultStrategy(nil, &metav1.PartialObjectMetadata{}).TableConvertor)
+}
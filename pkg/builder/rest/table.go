@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metatable "k8s.io/apimachinery/pkg/api/meta/table"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/registry/rest"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+var _ rest.TableConvertor = &tableConvertor{}
+
+var (
+	swaggerMetadataDescriptions = metav1.ObjectMeta{}.SwaggerDoc()
+	tableColumnTypes            = sets.New("integer", "number", "string", "boolean", "date")
+)
+
+// tableConvertor prints the objects with the columns of a resource, see resource.ObjectWithTableColumns.
+type tableConvertor struct {
+	headers []metav1.TableColumnDefinition
+	// paths are the JSON paths of the headers following the name
+	paths []*jsonpath.JSONPath
+	// age is true if the age of the objects is printed after the columns
+	age bool
+}
+
+// NewTableConvertor returns a table convertor that prints the name of the objects followed by the columns,
+// the age of the objects is printed last unless a column is named Age. The rows of the table hold the
+// objects, the apiserver replaces them with their metadata or drops them depending on the includeObject
+// option of the request. An error is returned for the columns with an invalid type or JSON path, they are
+// not printed by the returned table convertor.
+func NewTableConvertor(columns []resource.TableColumn) (rest.TableConvertor, error) {
+	c := &tableConvertor{
+		headers: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name", Description: swaggerMetadataDescriptions["name"]},
+		},
+	}
+	errs := []error{}
+	hasAge := false
+	for _, column := range columns {
+		if !tableColumnTypes.Has(column.Type) {
+			errs = append(errs, fmt.Errorf("column %s: unsupported type %q, must be one of %v",
+				column.Name, column.Type, sets.List(tableColumnTypes)))
+			continue
+		}
+		path := jsonpath.New(column.Name).AllowMissingKeys(true)
+		if err := path.Parse(fmt.Sprintf("{%s}", column.JSONPath)); err != nil {
+			errs = append(errs, fmt.Errorf("column %s: invalid JSON path %q: %w", column.Name, column.JSONPath, err))
+			continue
+		}
+		description := column.Description
+		if description == "" {
+			description = fmt.Sprintf("Column (in JSONPath format): %s", column.JSONPath)
+		}
+		c.headers = append(c.headers, metav1.TableColumnDefinition{
+			Name:        column.Name,
+			Type:        column.Type,
+			Format:      column.Format,
+			Description: description,
+			Priority:    column.Priority,
+		})
+		c.paths = append(c.paths, path)
+		hasAge = hasAge || column.Name == "Age"
+	}
+	if !hasAge {
+		c.headers = append(c.headers, metav1.TableColumnDefinition{
+			Name: "Age", Type: "date", Description: swaggerMetadataDescriptions["creationTimestamp"],
+		})
+		c.age = true
+	}
+	if len(errs) != 0 {
+		return c, fmt.Errorf("invalid table columns: %v", errs)
+	}
+	return c, nil
+}
+
+// ConvertToTable prints the object or the items of the list, the headers are omitted if the table options ask
+// for no headers.
+func (c *tableConvertor) ConvertToTable(ctx context.Context, obj runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	table := &metav1.Table{}
+	if options, ok := tableOptions.(*metav1.TableOptions); !ok || options == nil || !options.NoHeaders {
+		table.ColumnDefinitions = c.headers
+	}
+	if m, err := meta.ListAccessor(obj); err == nil {
+		table.ResourceVersion = m.GetResourceVersion()
+		table.Continue = m.GetContinue()
+		table.RemainingItemCount = m.GetRemainingItemCount()
+	} else if m, err := meta.CommonAccessor(obj); err == nil {
+		table.ResourceVersion = m.GetResourceVersion()
+	}
+
+	var err error
+	table.Rows, err = metatable.MetaToTableRow(obj, func(obj runtime.Object, m metav1.Object, name, age string) ([]interface{}, error) {
+		content, err := unstructuredContent(obj)
+		if err != nil {
+			return nil, err
+		}
+		cells := make([]interface{}, 0, len(c.headers))
+		cells = append(cells, name)
+		for i, path := range c.paths {
+			cells = append(cells, c.cell(c.headers[i+1].Type, path, content))
+		}
+		if c.age {
+			cells = append(cells, age)
+		}
+		return cells, nil
+	})
+	return table, err
+}
+
+// cell returns the value of the column for the content of an object, nil if the object has no value.
+func (c *tableConvertor) cell(columnType string, path *jsonpath.JSONPath, content map[string]interface{}) interface{} {
+	results, err := path.FindResults(content)
+	if err != nil || len(results) == 0 || len(results[0]) == 0 {
+		return nil
+	}
+	// a simple JSON path has a single result
+	value := results[0][0].Interface()
+	if columnType == "string" {
+		buf := &bytes.Buffer{}
+		if err := path.PrintResults(buf, []reflect.Value{reflect.ValueOf(value)}); err != nil {
+			return nil
+		}
+		return buf.String()
+	}
+	return cellForJSONValue(columnType, value)
+}
+
+// unstructuredContent returns the JSON content of the object.
+func unstructuredContent(obj runtime.Object) (map[string]interface{}, error) {
+	if u, ok := obj.(runtime.Unstructured); ok {
+		return u.UnstructuredContent(), nil
+	}
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+}
+
+// cellForJSONValue returns the value as the type of the column, nil if the value does not have the type.
+func cellForJSONValue(columnType string, value interface{}) interface{} {
+	switch columnType {
+	case "integer":
+		switch v := value.(type) {
+		case int64:
+			return v
+		case float64:
+			return int64(v)
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+		}
+	case "number":
+		switch v := value.(type) {
+		case int64:
+			return float64(v)
+		case float64:
+			return v
+		case json.Number:
+			if f, err := v.Float64(); err == nil {
+				return f
+			}
+		}
+	case "boolean":
+		if b, ok := value.(bool); ok {
+			return b
+		}
+	case "date":
+		if s, ok := value.(string); ok {
+			var timestamp metav1.Time
+			if err := timestamp.UnmarshalQueryParameter(s); err != nil {
+				return "<invalid>"
+			}
+			return metatable.ConvertToHumanReadableDateType(timestamp)
+		}
+	}
+	return nil
+}
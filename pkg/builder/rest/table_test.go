@@ -0,0 +1,76 @@
+package rest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTableConvertor(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewTableConvertor([]resource.TableColumn{
+		{Name: "Phase", Type: "string", JSONPath: ".status.phase"},
+		{Name: "Node", Type: "string", JSONPath: ".spec.nodeName", Priority: 1},
+		{Name: "Ready", Type: "boolean", JSONPath: ".status.containerStatuses[0].ready"},
+		{Name: "Restarts", Type: "integer", JSONPath: ".status.containerStatuses[0].restartCount"},
+	})
+	require.NoError(t, err)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "a", ResourceVersion: "2"},
+		Spec:       corev1.PodSpec{NodeName: "node"},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Ready: true, RestartCount: 3}},
+		},
+	}
+	table, err := c.ConvertToTable(ctx, pod, nil)
+	require.NoError(t, err)
+	names := []string{}
+	for _, column := range table.ColumnDefinitions {
+		names = append(names, column.Name)
+	}
+	assert.Equal(t, []string{"Name", "Phase", "Node", "Ready", "Restarts", "Age"}, names)
+	assert.Equal(t, int32(1), table.ColumnDefinitions[2].Priority)
+	assert.Equal(t, "2", table.ResourceVersion)
+	require.Len(t, table.Rows, 1)
+	assert.Equal(t, []interface{}{"a", "Running", "node", true, int64(3), "<unknown>"}, table.Rows[0].Cells)
+	// the apiserver replaces the object by its metadata unless the request includes the objects
+	assert.Equal(t, pod, table.Rows[0].Object.Object)
+
+	// the missing values are empty cells and the headers are omitted on request
+	list := &corev1.PodList{ListMeta: metav1.ListMeta{ResourceVersion: "5", Continue: "next"}, Items: []corev1.Pod{*pod, {}}}
+	table, err = c.ConvertToTable(ctx, list, &metav1.TableOptions{NoHeaders: true})
+	require.NoError(t, err)
+	assert.Empty(t, table.ColumnDefinitions)
+	assert.Equal(t, "5", table.ResourceVersion)
+	assert.Equal(t, "next", table.Continue)
+	require.Len(t, table.Rows, 2)
+	assert.Equal(t, []interface{}{"", nil, nil, nil, nil, "<unknown>"}, table.Rows[1].Cells)
+
+	// the partial object metadata is printed with the columns of the metadata
+	partial := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Name: "b"}}
+	table, err = c.ConvertToTable(ctx, partial, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"b", nil, nil, nil, nil, "<unknown>"}, table.Rows[0].Cells)
+}
+
+func TestTableConvertorInvalidColumns(t *testing.T) {
+	c, err := NewTableConvertor([]resource.TableColumn{
+		{Name: "Phase", Type: "enum", JSONPath: ".status.phase"},
+		{Name: "Node", Type: "string", JSONPath: ".spec[nodeName"},
+		{Name: "Age", Type: "date", JSONPath: ".status.startTime"},
+	})
+	assert.ErrorContains(t, err, `column Phase: unsupported type "enum"`)
+	assert.ErrorContains(t, err, `column Node: invalid JSON path ".spec[nodeName"`)
+	// the valid columns are printed
+	table, err := c.ConvertToTable(context.Background(), &corev1.Pod{}, nil)
+	require.NoError(t, err)
+	assert.Len(t, table.ColumnDefinitions, 2)
+	assert.Equal(t, "Age", table.ColumnDefinitions[1].Name)
+}
@@ -0,0 +1,216 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel/model"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"k8s.io/apiserver/pkg/cel/environment"
+	registry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+const (
+	// StaticEstimatedCostLimit is the largest estimated cost of a rule, including the cost of evaluating it for
+	// every value of a list or a map, it is the limit of the rules of a CustomResourceDefinition.
+	StaticEstimatedCostLimit = 10000000
+	// StaticEstimatedTotalCostLimit is the largest estimated cost of the rules of a resource.
+	StaticEstimatedTotalCostLimit = 100000000
+)
+
+// ValidationRules evaluates the CEL rules of the x-kubernetes-validations extensions of the schema of a resource
+// the way the apiserver evaluates the rules of a CustomResourceDefinition: the rules using oldSelf are only
+// evaluated on update and the evaluation of the rules of an object is limited by the runtime cost budget of a
+// custom resource.
+type ValidationRules struct {
+	schema    *structuralschema.Structural
+	validator *cel.Validator
+}
+
+// NewValidationRules compiles the rules of the structural schema of a resource, nil is returned if the schema has
+// no rules. An error is returned for every rule that does not compile and for every rule whose estimated cost
+// exceeds StaticEstimatedCostLimit, the rules of a resource cost at most StaticEstimatedTotalCostLimit.
+func NewValidationRules(s *structuralschema.Structural) (*ValidationRules, error) {
+	if s == nil {
+		return nil, nil
+	}
+	errs := []error{}
+	var totalCost uint64
+	hasRules := false
+	var compile func(fldPath *field.Path, s *structuralschema.Structural, isResourceRoot bool, cardinality *uint64)
+	compile = func(fldPath *field.Path, s *structuralschema.Structural, isResourceRoot bool, cardinality *uint64) {
+		if len(s.XValidations) != 0 {
+			hasRules = true
+			results, err := cel.Compile(s, model.SchemaDeclType(s, isResourceRoot), celconfig.PerCallLimit,
+				environment.MustBaseEnvSet(environment.DefaultCompatibilityVersion()), cel.NewExpressionsEnvLoader())
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", fldPath, err))
+			}
+			for i, result := range results {
+				rulePath := fldPath.Child("x-kubernetes-validations").Index(i)
+				if result.Error != nil {
+					errs = append(errs, fmt.Errorf("%s: rule %q: %s", rulePath, s.XValidations[i].Rule, result.Error.Detail))
+				}
+				if result.MessageExpressionError != nil {
+					errs = append(errs, fmt.Errorf("%s: messageExpression %q: %s", rulePath, s.XValidations[i].MessageExpression,
+						result.MessageExpressionError.Detail))
+				}
+				cost := result.MaxCost
+				if cardinality != nil {
+					cost = multiplyWithOverflowGuard(cost, *cardinality)
+				} else {
+					cost = multiplyWithOverflowGuard(cost, result.MaxCardinality)
+				}
+				if cost > StaticEstimatedCostLimit {
+					errs = append(errs, fmt.Errorf("%s: rule %q: estimated rule cost exceeds budget by factor of %.1fx, "+
+						"limit the size of the lists, maps and strings the rule iterates over, e.g. with maxItems",
+						rulePath, s.XValidations[i].Rule, float64(cost)/StaticEstimatedCostLimit))
+				}
+				if result.MessageExpression != nil && result.MessageExpressionMaxCost > StaticEstimatedCostLimit {
+					errs = append(errs, fmt.Errorf("%s: messageExpression %q: estimated cost exceeds budget by factor of %.1fx",
+						rulePath, s.XValidations[i].MessageExpression, float64(result.MessageExpressionMaxCost)/StaticEstimatedCostLimit))
+				}
+				totalCost = addWithOverflowGuard(totalCost, addWithOverflowGuard(cost, result.MessageExpressionMaxCost))
+			}
+		}
+		if s.Items != nil {
+			compile(fldPath.Child("items"), s.Items, s.Items.XEmbeddedResource, childCardinality(cardinality, s.ValueValidation, true))
+		}
+		properties := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			properties = append(properties, name)
+		}
+		sort.Strings(properties)
+		for _, name := range properties {
+			property := s.Properties[name]
+			compile(fldPath.Child("properties").Key(name), &property, property.XEmbeddedResource, cardinality)
+		}
+		if s.AdditionalProperties != nil && s.AdditionalProperties.Structural != nil {
+			compile(fldPath.Child("additionalProperties"), s.AdditionalProperties.Structural, s.AdditionalProperties.Structural.XEmbeddedResource,
+				childCardinality(cardinality, s.ValueValidation, false))
+		}
+	}
+	rootCardinality := uint64(1)
+	compile(field.NewPath("openAPIV3Schema"), s, true, &rootCardinality)
+	if !hasRules {
+		return nil, nil
+	}
+	if totalCost > StaticEstimatedTotalCostLimit {
+		errs = append(errs, fmt.Errorf("the estimated cost of the x-kubernetes-validations rules exceeds budget by factor of %.1fx",
+			float64(totalCost)/StaticEstimatedTotalCostLimit))
+	}
+	if len(errs) != 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return &ValidationRules{
+		schema:    s,
+		validator: cel.NewValidator(s, true, celconfig.PerCallLimit),
+	}, nil
+}
+
+// Validate evaluates the rules against the object, old is nil on create. The evaluation stops with an error when
+// the runtime cost budget is exceeded.
+func (v *ValidationRules) Validate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	content, err := unstructuredContent(obj)
+	if err != nil {
+		return field.ErrorList{field.InternalError(nil, err)}
+	}
+	var oldContent map[string]interface{}
+	if old != nil {
+		if oldContent, err = unstructuredContent(old); err != nil {
+			return field.ErrorList{field.InternalError(nil, err)}
+		}
+	}
+	errs, _ := v.validator.Validate(ctx, nil, v.schema, content, oldContent, celconfig.RuntimeCELCostBudget)
+	return errs
+}
+
+// WithValidationRules returns the storage with create and update strategies that evaluate the rules before
+// validating the objects with the strategies of the storage, the status subresource served on top of the
+// returned storage also evaluates the rules. The storage must be a builder or generic registry store.
+func WithValidationRules(storage rest.Storage, rules *ValidationRules) (rest.Storage, error) {
+	switch store := storage.(type) {
+	case *registry.Store:
+		rulesStore := *store
+		rulesStore.CreateStrategy = &validationRulesCreateStrategy{RESTCreateStrategy: store.CreateStrategy, rules: rules}
+		rulesStore.UpdateStrategy = &validationRulesUpdateStrategy{RESTUpdateStrategy: store.UpdateStrategy, rules: rules}
+		return &rulesStore, nil
+	case *memoryStore:
+		rulesStore := *store
+		rulesStore.createStrategy = &validationRulesCreateStrategy{RESTCreateStrategy: store.createStrategy, rules: rules}
+		rulesStore.updateStrategy = &validationRulesUpdateStrategy{RESTUpdateStrategy: store.updateStrategy, rules: rules}
+		return &rulesStore, nil
+	default:
+		return nil, fmt.Errorf("storage must be a builder or generic registry store to evaluate the x-kubernetes-validations rules, got %T", storage)
+	}
+}
+
+// validationRulesCreateStrategy evaluates the rules before the validation of the RESTCreateStrategy.
+type validationRulesCreateStrategy struct {
+	rest.RESTCreateStrategy
+
+	rules *ValidationRules
+}
+
+func (r *validationRulesCreateStrategy) Validate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return append(r.rules.Validate(ctx, obj, nil), r.RESTCreateStrategy.Validate(ctx, obj)...)
+}
+
+// validationRulesUpdateStrategy evaluates the rules before the validation of the RESTUpdateStrategy.
+type validationRulesUpdateStrategy struct {
+	rest.RESTUpdateStrategy
+
+	rules *ValidationRules
+}
+
+func (r *validationRulesUpdateStrategy) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return append(r.rules.Validate(ctx, obj, old), r.RESTUpdateStrategy.ValidateUpdate(ctx, obj, old)...)
+}
+
+// ValidateStatusUpdate evaluates the rules on the updates of the status subresource, see statusUpdateValidator.
+func (r *validationRulesUpdateStrategy) ValidateStatusUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return r.rules.Validate(ctx, obj, old)
+}
+
+// childCardinality returns the number of times the values of a list or a map are validated, nil if it is unbounded.
+func childCardinality(cardinality *uint64, validation *structuralschema.ValueValidation, isList bool) *uint64 {
+	if cardinality == nil || validation == nil {
+		return nil
+	}
+	maxElements := validation.MaxProperties
+	if isList {
+		maxElements = validation.MaxItems
+	}
+	if maxElements == nil {
+		return nil
+	}
+	result := multiplyWithOverflowGuard(*cardinality, uint64(max(*maxElements, 0)))
+	return &result
+}
+
+// multiplyWithOverflowGuard returns the product of a and b, math.MaxUint64 if it overflows.
+func multiplyWithOverflowGuard(a, b uint64) uint64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if math.MaxUint64/a < b {
+		return math.MaxUint64
+	}
+	return a * b
+}
+
+// addWithOverflowGuard returns the sum of a and b, math.MaxUint64 if it overflows.
+func addWithOverflowGuard(a, b uint64) uint64 {
+	if math.MaxUint64-a < b {
+		return math.MaxUint64
+	}
+	return a + b
+}
@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var _ watch.Interface = &memoryWatcher{}
+
+// memoryWatcher is a watch of a memoryStore. The events are queued in the buffered channel of the watcher
+// without blocking the writers of the store, a watcher lagging behind by more than memoryWatchQueueLength
+// events is stopped, so its client resumes the watch from the last resourceVersion it received rather than
+// stalling the store.
+type memoryWatcher struct {
+	state   *memoryState
+	matches func(obj runtime.Object) bool
+	result  chan watch.Event
+}
+
+// newMemoryWatcher registers a watcher of the objects matching, it starts with the initial events. The caller
+// must hold the lock of the state.
+func newMemoryWatcher(state *memoryState, initEvents []watch.Event, matches func(obj runtime.Object) bool) *memoryWatcher {
+	w := &memoryWatcher{
+		state:   state,
+		matches: matches,
+		result:  make(chan watch.Event, len(initEvents)+memoryWatchQueueLength),
+	}
+	for _, event := range initEvents {
+		w.result <- event
+	}
+	state.watchers[w] = struct{}{}
+	return w
+}
+
+func (w *memoryWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *memoryWatcher) Stop() {
+	w.state.mu.Lock()
+	defer w.state.mu.Unlock()
+
+	w.stop()
+}
+
+// send queues the event if its object matches, the caller must hold the lock of the state.
+func (w *memoryWatcher) send(event watch.Event) {
+	if !w.matches(event.Object) {
+		return
+	}
+	select {
+	case w.result <- event:
+	default:
+		w.stop()
+	}
+}
+
+// stop unregisters the watcher and closes its channel, the caller must hold the lock of the state.
+func (w *memoryWatcher) stop() {
+	if _, ok := w.state.watchers[w]; ok {
+		delete(w.state.watchers, w)
+		close(w.result)
+	}
+}
@@ -0,0 +1,86 @@
+// Package v1 is the storage version of the widgets served in several versions by the tests of the builder.
+package v1
+
+import (
+	"context"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/utils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apiserver/pkg/registry/rest"
+)
+
+var SchemeGroupVersion = schema.GroupVersion{Group: "versions.example.com", Version: "v1"}
+
+var _ resource.InternalObject = &Widget{}
+
+// Widget is the storage version of the widgets.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WidgetSpec `json:"spec,omitempty"`
+}
+
+type WidgetSpec struct {
+	Color string `json:"color,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+func (w *Widget) DeepCopyInto(out *Widget) {
+	*out = *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := &Widget{}
+	w.DeepCopyInto(c)
+	return c
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := &WidgetList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return c
+}
+
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsStorageVersion() bool            { return true }
+func (w *Widget) GetSingularName() string           { return "widget" }
+func (w *Widget) GetShortNames() []string           { return nil }
+func (w *Widget) GetCategories() []string           { return nil }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("widgets")
+}
+func (w *Widget) TableConvertor() func(gr schema.GroupResource) rest.TableConvertor {
+	return nil
+}
+func (w *Widget) FieldLabelConversion() runtime.FieldLabelConversionFunc { return nil }
+func (w *Widget) FieldSelector() func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	return utils.NewFieldSelector(w)
+}
+func (w *Widget) PrepareForCreate(ctx context.Context, obj runtime.Object) {}
+func (w *Widget) ValidateCreate(ctx context.Context, obj runtime.Object) field.ErrorList {
+	return nil
+}
+func (w *Widget) PrepareForUpdate(ctx context.Context, obj, old runtime.Object) {}
+func (w *Widget) ValidateUpdate(ctx context.Context, obj, old runtime.Object) field.ErrorList {
+	return nil
+}
+func (w *Widget) IsEqual(ctx context.Context, obj, old runtime.Object) bool {
+	return obj.(*Widget).Spec == old.(*Widget).Spec
+}
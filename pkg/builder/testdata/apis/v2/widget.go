@@ -0,0 +1,68 @@
+// Package v2 is a version of the widgets served by the tests of the builder, it is converted to and from the
+// storage version with resourcestrategy.Converter.
+package v2
+
+import (
+	v1 "github.com/henderiw/apiserver-builder/pkg/builder/testdata/apis/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var SchemeGroupVersion = schema.GroupVersion{Group: "versions.example.com", Version: "v2"}
+
+// Widget is the v2 version of the widgets, the color is spelled colour.
+type Widget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              WidgetSpec `json:"spec,omitempty"`
+}
+
+type WidgetSpec struct {
+	Colour string `json:"colour,omitempty"`
+}
+
+type WidgetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Widget `json:"items"`
+}
+
+func (w *Widget) DeepCopyInto(out *Widget) {
+	*out = *w
+	w.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+}
+
+func (w *Widget) DeepCopyObject() runtime.Object {
+	c := &Widget{}
+	w.DeepCopyInto(c)
+	return c
+}
+
+func (l *WidgetList) DeepCopyObject() runtime.Object {
+	c := &WidgetList{TypeMeta: l.TypeMeta}
+	l.ListMeta.DeepCopyInto(&c.ListMeta)
+	for i := range l.Items {
+		c.Items = append(c.Items, *l.Items[i].DeepCopyObject().(*Widget))
+	}
+	return c
+}
+
+func (w *Widget) GetObjectMeta() *metav1.ObjectMeta { return &w.ObjectMeta }
+func (w *Widget) NamespaceScoped() bool             { return true }
+func (w *Widget) New() runtime.Object               { return &Widget{} }
+func (w *Widget) NewList() runtime.Object           { return &WidgetList{} }
+func (w *Widget) IsStorageVersion() bool            { return false }
+func (w *Widget) GetGroupVersionResource() schema.GroupVersionResource {
+	return SchemeGroupVersion.WithResource("widgets")
+}
+
+func (w *Widget) ConvertToInternal() interface{} {
+	c := w.DeepCopyObject().(*Widget)
+	return &v1.Widget{ObjectMeta: c.ObjectMeta, Spec: v1.WidgetSpec{Color: c.Spec.Colour}}
+}
+
+func (w *Widget) ConvertFromInternal(internal interface{}) {
+	c := internal.(*v1.Widget).DeepCopyObject().(*v1.Widget)
+	w.ObjectMeta, w.Spec = c.ObjectMeta, WidgetSpec{Colour: c.Spec.Color}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcerest"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/tools/cache"
+)
+
+// IndexFields returns the indexing fields of the object if it implements FieldsIndexer.
+func IndexFields(obj runtime.Object) []string {
+	if indexer, ok := obj.(resourcerest.FieldsIndexer); ok {
+		return indexer.IndexingFields()
+	}
+	return nil
+}
+
+// IndexLabels returns the indexing label keys of the object if it implements LabelsIndexer.
+func IndexLabels(obj runtime.Object) []string {
+	if indexer, ok := obj.(resourcerest.LabelsIndexer); ok {
+		return indexer.IndexingLabelKeys()
+	}
+	return nil
+}
+
+// IndexedMatch returns the PredicateFunc of the resource, the predicates select the indexing fields and
+// label keys of the object so lists with an exact match on them are served from the indexes.
+func IndexedMatch(obj runtime.Object) func(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+	indexFields := IndexFields(obj)
+	indexLabels := IndexLabels(obj)
+	return func(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+		pred := Match(label, field)
+		pred.IndexFields = indexFields
+		pred.IndexLabels = indexLabels
+		return pred
+	}
+}
+
+// Indexers returns the indexers of the indexing fields and label keys of the object, named after
+// storage.FieldIndex and storage.LabelIndex as expected by the watch cache. Indexers returns nil if the
+// object has no indexing fields or label keys.
+func Indexers(obj runtime.Object) *cache.Indexers {
+	indexFields := IndexFields(obj)
+	indexLabels := IndexLabels(obj)
+	if len(indexFields) == 0 && len(indexLabels) == 0 {
+		return nil
+	}
+	indexers := cache.Indexers{}
+	for _, field := range indexFields {
+		indexers[storage.FieldIndex(field)] = fieldIndexFunc(field)
+	}
+	for _, key := range indexLabels {
+		indexers[storage.LabelIndex(key)] = labelIndexFunc(key)
+	}
+	return &indexers
+}
+
+// TriggerFunc returns the trigger of the watch cache for the first indexing field of the object, the watch
+// cache supports a single trigger. TriggerFunc returns nil if the object has no indexing fields.
+func TriggerFunc(obj runtime.Object) storage.IndexerFuncs {
+	indexFields := IndexFields(obj)
+	if len(indexFields) == 0 {
+		return nil
+	}
+	field := indexFields[0]
+	return storage.IndexerFuncs{
+		field: func(obj runtime.Object) string {
+			indexer, ok := obj.(resourcerest.FieldsIndexer)
+			if !ok {
+				return ""
+			}
+			return indexer.GetField(field)
+		},
+	}
+}
+
+func fieldIndexFunc(field string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		indexer, ok := obj.(resourcerest.FieldsIndexer)
+		if !ok {
+			return nil, fmt.Errorf("given object of type %T does not implement FieldsIndexer", obj)
+		}
+		return []string{indexer.GetField(field)}, nil
+	}
+}
+
+func labelIndexFunc(key string) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		if value, ok := accessor.GetLabels()[key]; ok {
+			return []string{value}, nil
+		}
+		return nil, nil
+	}
+}
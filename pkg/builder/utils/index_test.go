@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+func TestIndexedMatch(t *testing.T) {
+	tests := map[string]struct {
+		field   string
+		label   string
+		want    []storage.MatchValue
+		matches bool
+	}{
+		"IndexingField": {
+			field:   "spec.node=node1",
+			want:    []storage.MatchValue{{IndexName: storage.FieldIndex("spec.node"), Value: "node1"}},
+			matches: true,
+		},
+		"IndexingLabelKey": {
+			label:   "app=a",
+			want:    []storage.MatchValue{{IndexName: storage.LabelIndex("app"), Value: "a"}},
+			matches: true,
+		},
+		"IndexingFieldAndLabelKey": {
+			field: "spec.node=node1,status.phase=Running",
+			label: "app=a",
+			want: []storage.MatchValue{
+				{IndexName: storage.LabelIndex("app"), Value: "a"},
+				{IndexName: storage.FieldIndex("spec.node"), Value: "node1"},
+			},
+			matches: true,
+		},
+		"NotEquals": {
+			field: "spec.node!=node1",
+			label: "app!=a",
+		},
+		"NotIndexed": {
+			field:   "status.phase=Running,metadata.name=a",
+			matches: true,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			field, err := fields.ParseSelector(tc.field)
+			require.NoError(t, err)
+			label, err := labels.Parse(tc.label)
+			require.NoError(t, err)
+
+			pred := IndexedMatch(&testObject{})(label, field)
+			assert.ElementsMatch(t, tc.want, pred.MatcherIndex(context.Background()))
+
+			// the predicate still matches the selectable fields and the labels
+			matches, err := pred.Matches(newTestObject("default", "a", "Running", "node1"))
+			require.NoError(t, err)
+			assert.Equal(t, tc.matches, matches)
+		})
+	}
+}
+
+func TestIndexers(t *testing.T) {
+	indexers := Indexers(&testObject{})
+	require.NotNil(t, indexers)
+	assert.Len(t, *indexers, 2)
+	obj := newTestObject("default", "a", "Running", "node1")
+
+	values, err := (*indexers)[storage.FieldIndex("spec.node")](obj)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node1"}, values)
+	values, err = (*indexers)[storage.LabelIndex("app")](obj)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, values)
+	// objects without the label are not indexed by it
+	obj.Labels = nil
+	values, err = (*indexers)[storage.LabelIndex("app")](obj)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	trigger := TriggerFunc(&testObject{})
+	require.Contains(t, trigger, "spec.node")
+	assert.Equal(t, "node1", trigger["spec.node"](obj))
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcerest"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,14 +31,25 @@ func Match(label labels.Selector, field fields.Selector) storage.SelectionPredic
 	}
 }
 
-// GetAttrs returns labels.Set, fields.Set, and error in case the given runtime.Object is not a ObjectMetaProvider
+// GetAttrs returns labels.Set, fields.Set, and error in case the given runtime.Object is not a ObjectMetaProvider.
+// The fields.Set holds the metadata fields, the selectable fields of ObjectWithSelectableFields and the
+// indexing fields of FieldsIndexer.
 func GetAttrs(obj runtime.Object) (labels.Set, fields.Set, error) {
 	provider, ok := obj.(resource.Object)
 	if !ok {
 		return nil, nil, fmt.Errorf("given object of type %T does not have metadata", obj)
 	}
 	om := provider.GetObjectMeta()
-	return om.GetLabels(), SelectableFields(om), nil
+	fieldSet := SelectableFields(om)
+	if selectable, ok := obj.(resource.ObjectWithSelectableFields); ok {
+		fieldSet = generic.MergeFieldsSets(fieldSet, selectable.SelectableFields())
+	}
+	if indexer, ok := obj.(resourcerest.FieldsIndexer); ok {
+		for _, field := range indexer.IndexingFields() {
+			fieldSet[field] = indexer.GetField(field)
+		}
+	}
+	return om.GetLabels(), fieldSet, nil
 }
 
 // SelectableFields returns a field set that represents the object.
@@ -45,81 +57,79 @@ func SelectableFields(obj *metav1.ObjectMeta) fields.Set {
 	return generic.ObjectMetaFieldsSet(obj, true)
 }
 
-// ParseFieldSelector parses client-provided fields.Selector into a storerFilter
-func ParseFieldSelector(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
-	var filter *storerFilter
-	// add the namespace to the list
-	namespace, ok := genericapirequest.NamespaceFrom(ctx)
-	if fieldSelector == nil {
-		if ok {
-			return &storerFilter{namespace: namespace}, nil
+// NewFieldSelector returns the ParseFieldSelector function of the object rejecting the fields that are not
+// returned by GetAttrs for the object, i.e. neither metadata.name, metadata.namespace, a selectable field of
+// ObjectWithSelectableFields nor an indexing field of FieldsIndexer. The unknown fields are also rejected when
+// the field selector is not converted by the field label conversion of the scheme, e.g. a direct List of the
+// storage or a custom FieldLabelConversion.
+func NewFieldSelector(obj resource.Object) func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	_, knownFields, err := GetAttrs(obj.New())
+	return func(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+		if err != nil {
+			return nil, apierrors.NewInternalError(err)
 		}
-		return filter, nil
-	}
-
-	requirements := fieldSelector.Requirements()
-	for _, requirement := range requirements {
-		filter = &storerFilter{}
-		switch requirement.Operator {
-		case selection.Equals, selection.DoesNotExist:
-			if requirement.Value == "" {
-				return filter, apierrors.NewBadRequest(fmt.Sprintf("unsupported fieldSelector value %q for field %q with operator %q", requirement.Value, requirement.Field, requirement.Operator))
+		if fieldSelector != nil {
+			for _, requirement := range fieldSelector.Requirements() {
+				if _, ok := knownFields[requirement.Field]; !ok {
+					return nil, apierrors.NewBadRequest(fmt.Sprintf("unknown fieldSelector field %q", requirement.Field))
+				}
 			}
-		default:
-			return filter, apierrors.NewBadRequest(fmt.Sprintf("unsupported fieldSelector operator %q for field %q", requirement.Operator, requirement.Field))
 		}
+		return ParseFieldSelector(ctx, fieldSelector)
+	}
+}
 
-		switch requirement.Field {
-		case "metadata.name":
-			filter.name = requirement.Value
-		case "metadata.namespace":
-			filter.namespace = requirement.Value
-		default:
-			return filter, apierrors.NewBadRequest(fmt.Sprintf("unknown fieldSelector field %q", requirement.Field))
+// ParseFieldSelector parses client-provided fields.Selector into a storerFilter. The requirements of the
+// selector are ANDed and support the =, == and != operators on the fields returned by GetAttrs, a field that
+// is not returned by GetAttrs is not rejected, see NewFieldSelector.
+func ParseFieldSelector(ctx context.Context, fieldSelector fields.Selector) (resource.Filter, error) {
+	filter := &storerFilter{}
+	// add the namespace of the request to the filter
+	if namespace, ok := genericapirequest.NamespaceFrom(ctx); ok {
+		filter.namespace = namespace
+	}
+	if fieldSelector != nil && !fieldSelector.Empty() {
+		for _, requirement := range fieldSelector.Requirements() {
+			switch requirement.Operator {
+			case selection.Equals, selection.DoubleEquals, selection.NotEquals:
+			default:
+				return nil, apierrors.NewBadRequest(fmt.Sprintf("unsupported fieldSelector operator %q for field %q", requirement.Operator, requirement.Field))
+			}
 		}
+		filter.selector = fieldSelector
 	}
-	// add namespace to the filter selector if specified
-	if ok {
-		if filter != nil {
-			filter.namespace = namespace
-		} else {
-			filter = &storerFilter{namespace: namespace}
-		}
+	if filter.namespace == "" && filter.selector == nil {
+		return nil, nil
 	}
-
 	return filter, nil
 }
 
 // Filter
 type storerFilter struct {
-	// Name filters by the name of the objects
-	name string
+	// selector filters by the fields of the objects
+	selector fields.Selector
 
 	// Namespace filters by the namespace of the objects
 	namespace string
 }
 
+// Filter returns true if the object is filtered out, i.e. it does not match the namespace or the field selector.
 func (r *storerFilter) Filter(ctx context.Context, obj runtime.Object) bool {
-	f := false // this is the result of the previous filtering
 	accessor, err := meta.Accessor(obj)
 	if err != nil {
-		return f
+		return false
 	}
-	if r.name != "" {
-		if accessor.GetName() == r.name {
-			f = false
-		} else {
-			f = true
-		}
+	if r.namespace != "" && accessor.GetNamespace() != r.namespace {
+		return true
 	}
-	if r.namespace != "" {
-		if accessor.GetNamespace() == r.namespace {
-			f = false
-		} else {
-			f = true
-		}
+	if r.selector == nil {
+		return false
+	}
+	_, fieldSet, err := GetAttrs(obj)
+	if err != nil {
+		return true
 	}
-	return f
+	return !r.selector.Matches(fieldSet)
 }
 
 func UpdateResourceVersionAndGeneration(obj, old runtime.Object) error {
@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// testObject selects its phase and indexes its node and its app label.
+type testObject struct {
+	metav1.TypeMeta
+	metav1.ObjectMeta
+	Phase string
+	Node  string
+}
+
+func (o *testObject) DeepCopyObject() runtime.Object {
+	c := *o
+	o.ObjectMeta.DeepCopyInto(&c.ObjectMeta)
+	return &c
+}
+
+func (o *testObject) GetObjectMeta() *metav1.ObjectMeta { return &o.ObjectMeta }
+func (o *testObject) NamespaceScoped() bool             { return true }
+func (o *testObject) New() runtime.Object               { return &testObject{} }
+func (o *testObject) NewList() runtime.Object           { return nil }
+func (o *testObject) IsStorageVersion() bool            { return true }
+func (o *testObject) GetGroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "test.example.com", Version: "v1", Resource: "testobjects"}
+}
+func (o *testObject) SelectableFields() fields.Set     { return fields.Set{"status.phase": o.Phase} }
+func (o *testObject) IndexingFields() []string         { return []string{"spec.node"} }
+func (o *testObject) GetField(fieldName string) string { return o.Node }
+func (o *testObject) IndexingLabelKeys() []string      { return []string{"app"} }
+
+func newTestObject(namespace, name, phase, node string) *testObject {
+	return &testObject{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": name}},
+		Phase:      phase,
+		Node:       node,
+	}
+}
+
+func TestParseFieldSelector(t *testing.T) {
+	objects := []*testObject{
+		newTestObject("default", "a", "Running", "node1"),
+		newTestObject("default", "b", "Pending", "node1"),
+		newTestObject("other", "c", "Running", "node2"),
+	}
+	tests := map[string]struct {
+		namespace string
+		selector  string
+		want      []string
+	}{
+		"Everything": {
+			want: []string{"a", "b", "c"},
+		},
+		"Namespace": {
+			namespace: "default",
+			want:      []string{"a", "b"},
+		},
+		"Equals": {
+			selector: "status.phase=Running",
+			want:     []string{"a", "c"},
+		},
+		"DoubleEquals": {
+			selector: "metadata.name==b",
+			want:     []string{"b"},
+		},
+		"NotEquals": {
+			selector: "status.phase!=Running",
+			want:     []string{"b"},
+		},
+		"IndexingField": {
+			selector: "spec.node=node2",
+			want:     []string{"c"},
+		},
+		"ANDedRequirements": {
+			selector: "status.phase=Running,spec.node=node1",
+			want:     []string{"a"},
+		},
+		"ANDedWithNamespace": {
+			namespace: "other",
+			selector:  "status.phase=Running,metadata.name!=a",
+			want:      []string{"c"},
+		},
+		"MetadataNamespace": {
+			selector: "metadata.namespace=other",
+			want:     []string{"c"},
+		},
+		"UnknownField": {
+			selector: "spec.unknown=value",
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.namespace != "" {
+				ctx = genericapirequest.WithNamespace(ctx, tc.namespace)
+			}
+			var selector fields.Selector
+			if tc.selector != "" {
+				var err error
+				selector, err = fields.ParseSelector(tc.selector)
+				require.NoError(t, err)
+			}
+			filter, err := ParseFieldSelector(ctx, selector)
+			require.NoError(t, err)
+			if tc.namespace == "" && tc.selector == "" {
+				assert.Nil(t, filter)
+			}
+
+			var got []string
+			for _, obj := range objects {
+				if filter == nil || !filter.Filter(ctx, obj) {
+					got = append(got, obj.Name)
+				}
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNewFieldSelector(t *testing.T) {
+	parse := NewFieldSelector(&testObject{})
+	for selector, known := range map[string]bool{
+		"metadata.name=a,metadata.namespace=default": true,
+		"status.phase!=Running":                      true,
+		"spec.node=node1":                            true,
+		"spec.unknown!=x":                            false,
+		"status.phase=Running,spec.unknown=x":        false,
+	} {
+		t.Run(selector, func(t *testing.T) {
+			filter, err := parse(context.Background(), fields.ParseSelectorOrDie(selector))
+			if known {
+				assert.NoError(t, err)
+				assert.NotNil(t, filter)
+				return
+			}
+			assert.True(t, apierrors.IsBadRequest(err), err)
+			assert.ErrorContains(t, err, `unknown fieldSelector field "spec.unknown"`)
+		})
+	}
+}
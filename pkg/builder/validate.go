@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource/resourcestrategy"
+	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// registration is a resource registered with the apiserver together with its storage provider.
+type registration struct {
+	obj resource.Object
+	sp  *rest.StorageProvider
+}
+
+func (r registration) gvr() schema.GroupVersionResource {
+	return r.obj.GetGroupVersionResource()
+}
+
+// validateRegistrations returns an error for every registration the apiserver cannot serve:
+//   - a GroupVersionResource registered more than once
+//   - a storage version or a subresource registered without a storage provider
+//   - a version of a GroupResource that is not the storage version registered with a ResourceStorageProviderFn,
+//     the versions of a GroupResource share the storage of the storage version
+//   - a GroupResource without a storage version, the storage version is registered as the internal version
+//   - a GroupResource with more than one storage version or a version that is neither the storage version
+//     nor a MultiVersionObject or a resourcestrategy.Converter
+//   - a subresource registered for a resource that is not registered
+//   - a resource.ObjectWithTableColumns with a column of an unsupported type or an invalid JSON path
+func validateRegistrations(registrations []registration) []error {
+	errs := []error{}
+	gvrs := sets.New[schema.GroupVersionResource]()
+	groupResources := []schema.GroupResource{}
+	byGroupResource := map[schema.GroupResource][]registration{}
+	for _, reg := range registrations {
+		gvr := reg.gvr()
+		if gvrs.Has(gvr) {
+			errs = append(errs, fmt.Errorf("%s: registered more than once", gvr))
+			continue
+		}
+		gvrs.Insert(gvr)
+		isSubResource := strings.Contains(gvr.Resource, "/")
+		switch {
+		case reg.sp == nil || (reg.sp.ResourceStorageProviderFn == nil && (isSubResource || reg.obj.IsStorageVersion())):
+			errs = append(errs, fmt.Errorf("%s: no storage provider registered", gvr))
+			continue
+		case reg.sp.ResourceStorageProviderFn != nil && !isSubResource && !reg.obj.IsStorageVersion():
+			errs = append(errs, fmt.Errorf("%s: not the storage version but registered with a ResourceStorageProviderFn, "+
+				"the versions of a resource share the storage of the storage version, register it without ResourceStorageProviderFn", gvr))
+		}
+		if isSubResource {
+			continue
+		}
+		if obj, ok := reg.obj.(resource.ObjectWithTableColumns); ok {
+			if _, err := rest.NewTableConvertor(obj.TableColumns()); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", gvr, err))
+			}
+		}
+		if _, found := byGroupResource[gvr.GroupResource()]; !found {
+			groupResources = append(groupResources, gvr.GroupResource())
+		}
+		byGroupResource[gvr.GroupResource()] = append(byGroupResource[gvr.GroupResource()], reg)
+	}
+
+	for _, reg := range registrations {
+		gvr := reg.gvr()
+		parent, _, isSubResource := strings.Cut(gvr.Resource, "/")
+		if isSubResource && !gvrs.Has(gvr.GroupVersion().WithResource(parent)) {
+			errs = append(errs, fmt.Errorf("%s: subresource of resource %s which is not registered", gvr, parent))
+		}
+	}
+
+	for _, gr := range groupResources {
+		regs := byGroupResource[gr]
+		storageVersions := []string{}
+		for _, reg := range regs {
+			if reg.obj.IsStorageVersion() {
+				storageVersions = append(storageVersions, reg.gvr().Version)
+				continue
+			}
+			_, isMultiVersion := reg.obj.(resource.MultiVersionObject)
+			_, isConverter := reg.obj.New().(resourcestrategy.Converter)
+			if !isMultiVersion && !isConverter {
+				errs = append(errs, fmt.Errorf("%s: not the storage version and does not implement MultiVersionObject or resourcestrategy.Converter",
+					reg.gvr()))
+			}
+		}
+		switch {
+		case len(storageVersions) == 0:
+			errs = append(errs, fmt.Errorf("%s: no version is the storage version, the internal version is not registered", gr))
+		case len(storageVersions) > 1:
+			errs = append(errs, fmt.Errorf("%s: versions %s are all the storage version, a resource has a single storage version",
+				gr, strings.Join(storageVersions, ", ")))
+		}
+	}
+	return errs
+}
+
+// validateSchemeRegistrations returns an error for every registration whose GroupVersionResource disagrees
+// with the registration of its type in the scheme, e.g. the type is registered for another group or the kind
+// is served by another resource.
+func validateSchemeRegistrations(scheme *runtime.Scheme, registrations []registration) []error {
+	errs := []error{}
+	resources := map[schema.GroupVersionKind]string{}
+	for _, reg := range registrations {
+		gvr := reg.gvr()
+		kinds, _, err := scheme.ObjectKinds(reg.obj.New())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", gvr, err))
+			continue
+		}
+		var kind *schema.GroupVersionKind
+		otherGroups := sets.New[string]()
+		for i := range kinds {
+			switch {
+			case kinds[i].Group != gvr.Group:
+				otherGroups.Insert(kinds[i].Group)
+			case kinds[i].Version == gvr.Version:
+				kind = &kinds[i]
+			}
+		}
+		if otherGroups.Len() != 0 {
+			errs = append(errs, fmt.Errorf("%s: type %T is also registered in the scheme for the groups %s",
+				gvr, reg.obj.New(), strings.Join(sets.List(otherGroups), ", ")))
+		}
+		if kind == nil {
+			errs = append(errs, fmt.Errorf("%s: type %T is not registered in the scheme for %s", gvr, reg.obj.New(), gvr.GroupVersion()))
+			continue
+		}
+		if strings.Contains(gvr.Resource, "/") {
+			continue
+		}
+		if other, found := resources[*kind]; found {
+			errs = append(errs, fmt.Errorf("%s: kind %s is also served by resource %s", gvr, kind.Kind, other))
+			continue
+		}
+		resources[*kind] = gvr.Resource
+	}
+	return errs
+}
+
+// addToScheme adds the types of the scheme builder to the scheme, a conflicting registration of a type is
+// returned as an error rather than a panic.
+func addToScheme(schemeBuilder runtime.SchemeBuilder, scheme *runtime.Scheme) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("unable to add the resources to the scheme: %v", r)
+		}
+	}()
+	return schemeBuilder.AddToScheme(scheme)
+}
@@ -0,0 +1,131 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/henderiw/apiserver-builder/pkg/builder/resource"
+	"github.com/henderiw/apiserver-builder/pkg/builder/rest"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+)
+
+type testObject struct {
+	metav1.TypeMeta
+	metav1.ObjectMeta
+	gvr     schema.GroupVersionResource
+	storage bool
+}
+
+func (o *testObject) DeepCopyObject() runtime.Object                       { c := *o; return &c }
+func (o *testObject) GetObjectMeta() *metav1.ObjectMeta                    { return &o.ObjectMeta }
+func (o *testObject) NamespaceScoped() bool                                { return true }
+func (o *testObject) New() runtime.Object                                  { return &testObject{} }
+func (o *testObject) NewList() runtime.Object                              { return &metav1.List{} }
+func (o *testObject) GetGroupVersionResource() schema.GroupVersionResource { return o.gvr }
+func (o *testObject) IsStorageVersion() bool                               { return o.storage }
+
+// columnsObject is a testObject printed with table columns.
+type columnsObject struct {
+	testObject
+	columns []resource.TableColumn
+}
+
+func (o *columnsObject) TableColumns() []resource.TableColumn { return o.columns }
+
+func storageProviderA(*runtime.Scheme, generic.RESTOptionsGetter) (registryrest.Storage, error) {
+	return nil, nil
+}
+func storageProviderB(*runtime.Scheme, generic.RESTOptionsGetter) (registryrest.Storage, error) {
+	return nil, nil
+}
+
+func TestValidateRegistrations(t *testing.T) {
+	gv := schema.GroupVersion{Group: "example.com", Version: "v1"}
+	storage := func(resource string) *testObject { return &testObject{gvr: gv.WithResource(resource), storage: true} }
+	a := &rest.StorageProvider{ResourceStorageProviderFn: storageProviderA}
+	b := &rest.StorageProvider{ResourceStorageProviderFn: storageProviderB}
+	cases := map[string]struct {
+		registrations []registration
+		errs          []string
+	}{
+		"valid": {
+			registrations: []registration{
+				{obj: storage("foos"), sp: a},
+				{obj: storage("foos/log"), sp: a},
+			},
+		},
+		"duplicate resource": {
+			registrations: []registration{
+				{obj: storage("foos"), sp: a},
+				{obj: storage("foos"), sp: a},
+			},
+			errs: []string{"example.com/v1, Resource=foos: registered more than once"},
+		},
+		"missing storage provider": {
+			registrations: []registration{
+				{obj: storage("foos"), sp: &rest.StorageProvider{}},
+			},
+			errs: []string{"example.com/v1, Resource=foos: no storage provider registered"},
+		},
+		"subresource without parent": {
+			registrations: []registration{
+				{obj: storage("foos/log"), sp: a},
+			},
+			errs: []string{"example.com/v1, Resource=foos/log: subresource of resource foos which is not registered"},
+		},
+		"multiple storage versions": {
+			registrations: []registration{
+				{obj: storage("foos"), sp: a},
+				{obj: &testObject{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v2", Resource: "foos"}, storage: true}, sp: b},
+			},
+			errs: []string{
+				"foos.example.com: versions v1, v2 are all the storage version, a resource has a single storage version",
+			},
+		},
+		"storage provider of a version that is not the storage version": {
+			registrations: []registration{
+				{obj: storage("foos"), sp: a},
+				{obj: &testObject{gvr: schema.GroupVersionResource{Group: "example.com", Version: "v2", Resource: "foos"}}, sp: b},
+			},
+			errs: []string{
+				"example.com/v2, Resource=foos: not the storage version but registered with a ResourceStorageProviderFn, " +
+					"the versions of a resource share the storage of the storage version, register it without ResourceStorageProviderFn",
+				"example.com/v2, Resource=foos: not the storage version and does not implement MultiVersionObject or resourcestrategy.Converter",
+			},
+		},
+		"missing storage version": {
+			registrations: []registration{
+				{obj: &testObject{gvr: gv.WithResource("foos")}, sp: &rest.StorageProvider{}},
+			},
+			errs: []string{
+				"example.com/v1, Resource=foos: not the storage version and does not implement MultiVersionObject or resourcestrategy.Converter",
+				"foos.example.com: no version is the storage version, the internal version is not registered",
+			},
+		},
+		"invalid table columns": {
+			registrations: []registration{
+				{obj: &columnsObject{
+					testObject: *storage("foos"),
+					columns:    []resource.TableColumn{{Name: "Phase", Type: "enum", JSONPath: ".status.phase"}},
+				}, sp: a},
+			},
+			errs: []string{
+				`example.com/v1, Resource=foos: invalid table columns: [column Phase: unsupported type "enum", ` +
+					`must be one of [boolean date integer number string]]`,
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			errs := []string{}
+			for _, err := range validateRegistrations(tc.registrations) {
+				errs = append(errs, err.Error())
+			}
+			assert.ElementsMatch(t, tc.errs, errs)
+		})
+	}
+}
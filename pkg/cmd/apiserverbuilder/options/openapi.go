@@ -0,0 +1,93 @@
+package options
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/henderiw/apiserver-builder/pkg/apiserver"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// OpenAPISpecs returns the OpenAPI documents served by the apiserver without running it, see OfflineConfig.
+func (o ServerOptions) OpenAPISpecs(ctx context.Context, serverName string) (*apiserver.OpenAPISpecs, error) {
+	config, err := o.OfflineConfig(serverName)
+	if err != nil {
+		return nil, err
+	}
+	server, err := config.Complete().New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	server.GenericAPIServer.PrepareRun()
+	return server.OpenAPISpecs()
+}
+
+// OfflineConfig returns the config of an apiserver that is never run, e.g. to materialize the OpenAPI
+// documents at build time. The config is built from the same RecommendedConfigFns as the config of a running
+// apiserver, but the storage, serving, authentication, authorization and admission settings are disabled so
+// no etcd, certificates or kubeconfig are needed.
+func (o *ServerOptions) OfflineConfig(serverName string) (*apiserver.Config, error) {
+	recommendedOptions := *o.ApplyServerOptionsFns().RecommendedOptions
+	// the external address is derived from the secure port when the apiserver is run
+	externalAddress := net.JoinHostPort("localhost", "443")
+	if recommendedOptions.SecureServing != nil {
+		externalAddress = net.JoinHostPort("localhost", strconv.Itoa(recommendedOptions.SecureServing.BindPort))
+	}
+	recommendedOptions.Etcd = nil
+	recommendedOptions.SecureServing = nil
+	recommendedOptions.Authentication = nil
+	recommendedOptions.Authorization = nil
+	recommendedOptions.CoreAPI = nil
+	recommendedOptions.Admission = nil
+	features := *recommendedOptions.Features
+	features.EnablePriorityAndFairness = false
+	recommendedOptions.Features = &features
+
+	serverConfig := genericapiserver.NewRecommendedConfig(o.ExtraConfig.Codecs)
+	if err := recommendedOptions.ApplyTo(serverConfig); err != nil {
+		return nil, err
+	}
+	serverConfig = o.ApplyRecommendedConfigFns(serverConfig)
+	serverConfig.RESTOptionsGetter = offlineRESTOptionsGetter{}
+	serverConfig.LoopbackClientConfig = &restclient.Config{}
+	serverConfig.ExternalAddress = externalAddress
+
+	extraConfig := o.ExtraConfig
+	extraConfig.ServerName = serverName
+	extraConfig.Loopback = nil
+	return &apiserver.Config{
+		GenericConfig: serverConfig,
+		ExtraConfig:   extraConfig,
+	}, nil
+}
+
+// offlineRESTOptionsGetter returns RESTOptions without a storage, the stores of an apiserver that is never
+// run are not accessed.
+type offlineRESTOptionsGetter struct{}
+
+func (offlineRESTOptionsGetter) GetRESTOptions(resource schema.GroupResource, example runtime.Object) (generic.RESTOptions, error) {
+	return generic.RESTOptions{
+		StorageConfig: &storagebackend.ConfigForResource{GroupResource: resource},
+		Decorator: func(
+			config *storagebackend.ConfigForResource,
+			resourcePrefix string,
+			keyFunc func(obj runtime.Object) (string, error),
+			newFunc func() runtime.Object,
+			newListFunc func() runtime.Object,
+			getAttrsFunc storage.AttrFunc,
+			trigger storage.IndexerFuncs,
+			indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+			return nil, func() {}, nil
+		},
+		ResourcePrefix: resource.Group + "/" + resource.Resource,
+	}, nil
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/henderiw/apiserver-builder/pkg/apiserver"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	genericapiserver "k8s.io/apiserver/pkg/server"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
@@ -17,19 +16,26 @@ import (
 type ServerOptions struct {
 	RecommendedOptions *genericoptions.RecommendedOptions
 
+	// ExtraConfig holds the schemes, the codecs and the APIs of the apiserver
+	ExtraConfig apiserver.ExtraConfig
+	// ServerOptionsFns customize the ServerOptions when they are completed
+	ServerOptionsFns []func(*ServerOptions) *ServerOptions
+	// RecommendedConfigFns customize the RecommendedConfig of the apiserver
+	RecommendedConfigFns []func(*genericapiserver.RecommendedConfig) *genericapiserver.RecommendedConfig
+
 	StdOut io.Writer
 	StdErr io.Writer
 }
 
-// NewServerOptions returns a new ServerOptions
-func NewServerOptions(out, errOut io.Writer, versions ...schema.GroupVersion) *ServerOptions {
-	apiserver.Codecs = serializer.NewCodecFactory(apiserver.Scheme)
-
+// NewServerOptions returns a new ServerOptions for the apiserver of the ExtraConfig, the resources are stored
+// in etcd under the etcdPath.
+func NewServerOptions(out, errOut io.Writer, extraConfig apiserver.ExtraConfig, etcdPath string, versions ...schema.GroupVersion) *ServerOptions {
 	o := &ServerOptions{
 		RecommendedOptions: genericoptions.NewRecommendedOptions(
-			EtcdPath,
-			apiserver.Codecs.LegacyCodec(versions...),
+			etcdPath,
+			extraConfig.Codecs.LegacyCodec(versions...),
 		),
+		ExtraConfig: extraConfig,
 
 		StdOut: out,
 		StdErr: errOut,
@@ -40,7 +46,7 @@ func NewServerOptions(out, errOut io.Writer, versions ...schema.GroupVersion) *S
 
 // Complete fills in fields required to have valid data
 func (o *ServerOptions) Complete() error {
-	ApplyServerOptionsFns(o)
+	o.ApplyServerOptionsFns()
 	return nil
 }
 
@@ -86,19 +92,19 @@ func (o *ServerOptions) Config(serverName string) (*apiserver.Config, error) {
 	//o.RecommendedOptions.Etcd.StorageConfig.Paging = utilfeature.DefaultFeatureGate.Enabled(features.APIListChunking)
 	//}
 
-	serverConfig := genericapiserver.NewRecommendedConfig(apiserver.Codecs)
+	serverConfig := genericapiserver.NewRecommendedConfig(o.ExtraConfig.Codecs)
 
 	if err := o.RecommendedOptions.ApplyTo(serverConfig); err != nil {
 		return nil, err
 	}
 
-	serverConfig = ApplyRecommendedConfigFns(serverConfig)
+	serverConfig = o.ApplyRecommendedConfigFns(serverConfig)
 
+	extraConfig := o.ExtraConfig
+	extraConfig.ServerName = serverName
 	config := &apiserver.Config{
 		GenericConfig: serverConfig,
-		ExtraConfig: apiserver.ExtraConfig{
-			ServerName: serverName,
-		},
+		ExtraConfig:   extraConfig,
 	}
 	return config, nil
 }
@@ -1,11 +1,18 @@
 package apiserverbuilder
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/henderiw/apiserver-builder/pkg/cmd/apiserverbuilder/options"
+	"github.com/henderiw/apiserver-builder/pkg/openapi"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/sets"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
 )
 
@@ -36,3 +43,102 @@ func NewCommandStartServer(ctx context.Context, serverName string, defaults *opt
 
 	return cmd
 }
+
+// NewCommandValidateOpenAPI provides a CLI handler for the 'validate-openapi' command, it validates the
+// OpenAPI documents served by the apiserver without running it and fails if any of them is invalid.
+func NewCommandValidateOpenAPI(ctx context.Context, serverName string, defaults *options.ServerOptions) *cobra.Command {
+	o := *defaults
+	cmd := &cobra.Command{
+		Use:   "validate-openapi",
+		Short: fmt.Sprintf("validate the OpenAPI documents of %s", serverName),
+		Long: fmt.Sprintf("validate the OpenAPI v2 and v3 documents of %s without running it, every $ref must resolve "+
+			"to a definition and every served kind must have a definition with its GroupVersionKind", serverName),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			specs, err := o.OpenAPISpecs(ctx, serverName)
+			if err != nil {
+				return err
+			}
+			errs := []error{}
+			for _, err := range openapi.ValidateV2(specs.V2) {
+				errs = append(errs, fmt.Errorf("openapi/v2: %w", err))
+			}
+			for _, path := range sets.List(sets.KeySet(specs.V3)) {
+				for _, err := range openapi.ValidateV3(specs.V3[path]) {
+					errs = append(errs, fmt.Errorf("openapi/v3/%s: %w", path, err))
+				}
+			}
+			for _, err := range errs {
+				fmt.Fprintln(c.ErrOrStderr(), err)
+			}
+			if len(errs) != 0 {
+				return fmt.Errorf("%d OpenAPI validation errors", len(errs))
+			}
+			fmt.Fprintf(c.OutOrStdout(), "the OpenAPI v2 document and %d OpenAPI v3 documents are valid\n", len(specs.V3))
+			return nil
+		},
+	}
+	return cmd
+}
+
+// NewCommandDumpOpenAPI provides a CLI handler for the 'dump-openapi' command, it writes the OpenAPI documents
+// served by the apiserver to a directory without running it. The v2 document is written to swagger.json and
+// the v3 documents to <path>_openapi.json, e.g. apis__example.com__v1alpha1_openapi.json.
+func NewCommandDumpOpenAPI(ctx context.Context, serverName string, defaults *options.ServerOptions) *cobra.Command {
+	o := *defaults
+	format := "v3"
+	output := "."
+	cmd := &cobra.Command{
+		Use:          "dump-openapi",
+		Short:        fmt.Sprintf("dump the OpenAPI documents of %s", serverName),
+		Long:         fmt.Sprintf("dump the OpenAPI v2 or v3 documents of %s without running it, the documents are the ones served by %s", serverName, serverName),
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if format != "v2" && format != "v3" {
+				return fmt.Errorf("invalid format %q, must be v2 or v3", format)
+			}
+			specs, err := o.OpenAPISpecs(ctx, serverName)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(output, 0755); err != nil {
+				return err
+			}
+			files := map[string][]byte{}
+			switch format {
+			case "v2":
+				files["swagger.json"] = specs.V2
+			case "v3":
+				for path, data := range specs.V3 {
+					files[strings.ReplaceAll(path, "/", "__")+"_openapi.json"] = data
+				}
+			}
+			for _, name := range sets.List(sets.KeySet(files)) {
+				data, err := indentJSON(files[name])
+				if err != nil {
+					return fmt.Errorf("invalid OpenAPI document %s: %w", name, err)
+				}
+				if err := os.WriteFile(filepath.Join(output, name), data, 0644); err != nil {
+					return err
+				}
+				fmt.Fprintln(c.OutOrStdout(), filepath.Join(output, name))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", format, "The version of the OpenAPI documents, v2 or v3.")
+	cmd.Flags().StringVar(&output, "output", output, "The directory the OpenAPI documents are written to.")
+	return cmd
+}
+
+// indentJSON returns the indented JSON document, so the dumped documents are stable and diffable.
+func indentJSON(data []byte) ([]byte, error) {
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, data, "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
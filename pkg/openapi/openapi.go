@@ -1,17 +1,176 @@
 package openapi
 
-/*
-
 import (
-	managedfields "k8s.io/apimachinery/pkg/util/managedfields"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	apiextensionsopenapi "k8s.io/apiextensions-apiserver/pkg/generated/openapi"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/managedfields"
+	"k8s.io/apiserver/pkg/endpoints"
+	openapinamer "k8s.io/apiserver/pkg/endpoints/openapi"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
 	"k8s.io/kube-openapi/pkg/validation/spec"
 )
 
-// GlobalOpenAPISchemas is set by options before New() is called.
-// apiserver reads it when installing API groups.
-var GlobalOpenAPISchemas map[string]*spec.Schema
+// DefinitionNamer names the OpenAPI definitions after the REST friendly name of their type, e.g.
+// com.github.example.api.v1alpha1.Config for github.com/example/api/v1alpha1.Config. The names never contain
+// a "/", so the $refs need no escaping and the TypeConverter resolves them to the component keys. The
+// definitions of the types registered in the schemes carry their GroupVersionKinds.
+type DefinitionNamer struct {
+	namer *openapinamer.DefinitionNamer
+}
+
+// NewDefinitionNamer returns a DefinitionNamer for the types registered in the schemes.
+func NewDefinitionNamer(schemes ...*runtime.Scheme) *DefinitionNamer {
+	return &DefinitionNamer{namer: openapinamer.NewDefinitionNamer(schemes...)}
+}
+
+// GetDefinitionName returns the name and the extensions of the definition of the type. The type is either
+// named after its Go package path, e.g. by older openapi-gen versions, or after its REST friendly name.
+func (d *DefinitionNamer) GetDefinitionName(name string) (string, spec.Extensions) {
+	return d.namer.GetDefinitionName(friendlyName(name))
+}
+
+// GetDefinitions returns the definitions of the resources merged with the definitions of the built-in
+// types, e.g. the apimachinery and autoscaling types. The definitions are keyed by the Go package path and
+// by the REST friendly name of their type, so the definitions generated by different openapi-gen versions
+// resolve each other.
+func GetDefinitions(defs common.GetOpenAPIDefinitions) common.GetOpenAPIDefinitions {
+	return func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		result := map[string]common.OpenAPIDefinition{}
+		for _, definitions := range []map[string]common.OpenAPIDefinition{apiextensionsopenapi.GetOpenAPIDefinitions(ref), defs(ref)} {
+			for name, definition := range definitions {
+				dependencies := make([]string, 0, len(definition.Dependencies))
+				for _, dependency := range definition.Dependencies {
+					dependencies = append(dependencies, friendlyName(dependency))
+				}
+				definition.Dependencies = dependencies
+				result[name] = definition
+				result[friendlyName(name)] = definition
+			}
+		}
+		return result
+	}
+}
+
+// NewConfig returns the OpenAPI v2 config of the definitions named by the DefinitionNamer of the schemes.
+func NewConfig(defs common.GetOpenAPIDefinitions, schemes ...*runtime.Scheme) *common.Config {
+	config := server.DefaultOpenAPIConfig(GetDefinitions(defs), openapinamer.NewDefinitionNamer(schemes...))
+	config.GetDefinitionName = NewDefinitionNamer(schemes...).GetDefinitionName
+	return config
+}
+
+// NewV3Config returns the OpenAPI v3 config of the definitions named by the DefinitionNamer of the schemes.
+func NewV3Config(defs common.GetOpenAPIDefinitions, schemes ...*runtime.Scheme) *common.OpenAPIV3Config {
+	getDefinitions := GetDefinitions(defs)
+	config := server.DefaultOpenAPIV3Config(getDefinitions, openapinamer.NewDefinitionNamer(schemes...))
+	config.GetDefinitionName = NewDefinitionNamer(schemes...).GetDefinitionName
+	// the default config resolves the definitions with the $refs of the default namer
+	config.Definitions = getDefinitions(func(name string) spec.Ref {
+		defName, _ := config.GetDefinitionName(name)
+		return spec.MustCreateRef("#/components/schemas/" + common.EscapeJsonPointer(defName))
+	})
+	return config
+}
+
+// NewDeducedV3Config returns the OpenAPI v3 config of the types registered in the schemes when no definitions
+// are registered. The types are objects preserving their unknown fields, so server-side apply and the managed
+// fields deduce the fields of the resources like for custom resources without a schema.
+func NewDeducedV3Config(schemes ...*runtime.Scheme) *common.OpenAPIV3Config {
+	return NewV3Config(func(ref common.ReferenceCallback) map[string]common.OpenAPIDefinition {
+		builtin := apiextensionsopenapi.GetOpenAPIDefinitions(ref)
+		str := spec.Schema{SchemaProps: spec.SchemaProps{Type: []string{"string"}}}
+		defs := map[string]common.OpenAPIDefinition{}
+		for _, scheme := range schemes {
+			for _, t := range scheme.AllKnownTypes() {
+				name := util.GetCanonicalTypeName(reflect.New(t).Interface())
+				if _, ok := builtin[name]; ok {
+					continue
+				}
+				definition := common.OpenAPIDefinition{
+					Schema: spec.Schema{SchemaProps: spec.SchemaProps{
+						Type: []string{"object"},
+						Properties: map[string]spec.Schema{
+							"apiVersion": str,
+							"kind":       str,
+							"metadata": {SchemaProps: spec.SchemaProps{
+								Default: map[string]interface{}{},
+								Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+							}},
+						},
+					}},
+					Dependencies: []string{"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"},
+				}
+				definition.Schema.AddExtension("x-kubernetes-preserve-unknown-fields", true)
+				defs[name] = definition
+			}
+		}
+		return defs
+	}, schemes...)
+}
+
+// CheckTypeConverter returns an error listing the kinds of the resources of the API groups that have no
+// schema in the TypeConverter, server-side apply fails for these kinds.
+func CheckTypeConverter(typeConverter managedfields.TypeConverter, apiGroupInfos ...*server.APIGroupInfo) error {
+	missing := []string{}
+	for _, apiGroupInfo := range apiGroupInfos {
+		kinds, err := resourceKinds(apiGroupInfo)
+		if err != nil {
+			return err
+		}
+		for _, gvk := range kinds {
+			obj, err := apiGroupInfo.Scheme.New(gvk)
+			if err != nil {
+				return err
+			}
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+			if _, err := typeConverter.ObjectToTyped(obj); err != nil {
+				missing = append(missing, fmt.Sprintf("%s: %v", gvk.String(), err))
+			}
+		}
+	}
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("no valid OpenAPI schema for the kinds, check the definitions registered with WithOpenAPIDefinitions:\n%s", strings.Join(missing, "\n"))
+	}
+	return nil
+}
 
-// GlobalTypeConverter is built from GlobalOpenAPISchemas.
-var GlobalTypeConverter managedfields.TypeConverter
+// resourceKinds returns the kinds of the resources of the API group, the kinds of the subresources are
+// not included.
+func resourceKinds(apiGroupInfo *server.APIGroupInfo) ([]schema.GroupVersionKind, error) {
+	kinds := []schema.GroupVersionKind{}
+	for _, groupVersion := range apiGroupInfo.PrioritizedVersions {
+		resources := make([]string, 0, len(apiGroupInfo.VersionedResourcesStorageMap[groupVersion.Version]))
+		for resource := range apiGroupInfo.VersionedResourcesStorageMap[groupVersion.Version] {
+			if !strings.Contains(resource, "/") {
+				resources = append(resources, resource)
+			}
+		}
+		sort.Strings(resources)
+		for _, resource := range resources {
+			storage := apiGroupInfo.VersionedResourcesStorageMap[groupVersion.Version][resource]
+			gvk, err := endpoints.GetResourceKind(groupVersion, storage, apiGroupInfo.Scheme)
+			if err != nil {
+				return nil, err
+			}
+			kinds = append(kinds, gvk)
+		}
+	}
+	return kinds, nil
+}
 
-*/
\ No newline at end of file
+// friendlyName returns the REST friendly name of the type named after its Go package path, names without a
+// "/" are already REST friendly.
+func friendlyName(name string) string {
+	if !strings.Contains(name, "/") {
+		return name
+	}
+	return util.ToRESTFriendlyName(name)
+}
@@ -0,0 +1,110 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/kube-openapi/pkg/common"
+	"k8s.io/kube-openapi/pkg/util"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+)
+
+const definitionsPrefix = "#/definitions/"
+
+// StructuralSchema returns the structural schema of the type of the object, the schema of a CustomResourceDefinition
+// the CEL validation rules of its x-kubernetes-validations extensions are compiled against. The $refs of the
+// definitions are inlined, a type referencing itself is an object preserving its unknown fields. nil is returned if
+// the definitions have no definition for the type.
+func StructuralSchema(defs common.GetOpenAPIDefinitions, obj runtime.Object) (*structuralschema.Structural, error) {
+	definitions := GetDefinitions(defs)(func(name string) spec.Ref {
+		return spec.MustCreateRef(definitionsPrefix + friendlyName(name))
+	})
+	name := friendlyName(util.GetCanonicalTypeName(obj))
+	if _, found := definitions[name]; !found {
+		return nil, nil
+	}
+	s, err := inlineRefs(definitions, spec.Schema{SchemaProps: spec.SchemaProps{Ref: spec.MustCreateRef(definitionsPrefix + name)}}, sets.New[string]())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the schema of %s: %w", name, err)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	v1Props := &apiextensionsv1.JSONSchemaProps{}
+	if err := json.Unmarshal(data, v1Props); err != nil {
+		return nil, fmt.Errorf("unable to convert the schema of %s: %w", name, err)
+	}
+	props := &apiextensions.JSONSchemaProps{}
+	if err := apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(v1Props, props, nil); err != nil {
+		return nil, fmt.Errorf("unable to convert the schema of %s: %w", name, err)
+	}
+	return structuralschema.NewStructural(props)
+}
+
+// inlineRefs returns the schema with its $refs replaced by the definitions they refer to, visiting holds the
+// definitions being inlined.
+func inlineRefs(definitions map[string]common.OpenAPIDefinition, s spec.Schema, visiting sets.Set[string]) (spec.Schema, error) {
+	if ref := s.Ref.String(); ref != "" {
+		name := strings.TrimPrefix(ref, definitionsPrefix)
+		definition, found := definitions[name]
+		if !found {
+			return spec.Schema{}, fmt.Errorf("no definition for %s", ref)
+		}
+		if visiting.Has(name) {
+			// the recursive types cannot be inlined, their values are not validated
+			return spec.Schema{
+				SchemaProps:      spec.SchemaProps{Type: []string{"object"}},
+				VendorExtensible: spec.VendorExtensible{Extensions: spec.Extensions{"x-kubernetes-preserve-unknown-fields": true}},
+			}, nil
+		}
+		visiting.Insert(name)
+		defer visiting.Delete(name)
+		return inlineRefs(definitions, definition.Schema, visiting)
+	}
+	// the types marshaled as integers or strings, e.g. IntOrString and Quantity, are one of both in OpenAPI v3
+	if len(s.Type) == 0 && len(s.OneOf) != 0 {
+		s.OneOf = nil
+		s.AddExtension("x-kubernetes-int-or-string", true)
+	}
+	var err error
+	if len(s.Properties) != 0 {
+		properties := make(map[string]spec.Schema, len(s.Properties))
+		for name, property := range s.Properties {
+			if properties[name], err = inlineRefs(definitions, property, visiting); err != nil {
+				return spec.Schema{}, err
+			}
+		}
+		s.Properties = properties
+	}
+	if s.Items != nil && s.Items.Schema != nil {
+		items, err := inlineRefs(definitions, *s.Items.Schema, visiting)
+		if err != nil {
+			return spec.Schema{}, err
+		}
+		s.Items = &spec.SchemaOrArray{Schema: &items}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		additionalProperties, err := inlineRefs(definitions, *s.AdditionalProperties.Schema, visiting)
+		if err != nil {
+			return spec.Schema{}, err
+		}
+		s.AdditionalProperties = &spec.SchemaOrBool{Allows: true, Schema: &additionalProperties}
+	}
+	if len(s.AllOf) != 0 {
+		allOf := make([]spec.Schema, len(s.AllOf))
+		for i := range s.AllOf {
+			if allOf[i], err = inlineRefs(definitions, s.AllOf[i], visiting); err != nil {
+				return spec.Schema{}, err
+			}
+		}
+		s.AllOf = allOf
+	}
+	return s, nil
+}
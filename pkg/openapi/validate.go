@@ -0,0 +1,177 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// extensionGVK is the extension listing the GroupVersionKinds of a definition or of an operation
+const extensionGVK = "x-kubernetes-group-version-kind"
+
+// ValidateV2 validates the OpenAPI v2 document, see validateDocument.
+func ValidateV2(data []byte) []error {
+	return validateDocument(data, "definitions")
+}
+
+// ValidateV3 validates the OpenAPI v3 document, see validateDocument.
+func ValidateV3(data []byte) []error {
+	return validateDocument(data, "components", "schemas")
+}
+
+// validateDocument returns an error for every $ref of the document that does not resolve, e.g. a dangling or
+// a double-encoded $ref, and for every GroupVersionKind of the operations that no definition carries in its
+// GroupVersionKind extension. The definitions are found at the path of the document.
+func validateDocument(data []byte, path ...string) []error {
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []error{fmt.Errorf("invalid document: %w", err)}
+	}
+	definitions, _ := lookup(doc, path...).(map[string]interface{})
+
+	errs := []error{}
+	refs := map[string]sets.Set[string]{}
+	collectRefs(doc, "", refs)
+	for _, ref := range sortedKeys(refs) {
+		if err := resolveRef(doc, ref); err != nil {
+			errs = append(errs, fmt.Errorf("%v, referenced at %s", err, strings.Join(sets.List(refs[ref]), ", ")))
+		}
+	}
+
+	kinds := map[schema.GroupVersionKind]bool{}
+	for _, definition := range definitions {
+		for _, gvk := range groupVersionKinds(definition) {
+			kinds[gvk] = true
+		}
+	}
+	missing := map[string]sets.Set[string]{}
+	paths, _ := lookup(doc, "paths").(map[string]interface{})
+	for path, item := range paths {
+		operations, _ := item.(map[string]interface{})
+		for _, operation := range operations {
+			for _, gvk := range groupVersionKinds(operation) {
+				if kinds[gvk] {
+					continue
+				}
+				if missing[gvk.String()] == nil {
+					missing[gvk.String()] = sets.New[string]()
+				}
+				missing[gvk.String()].Insert(path)
+			}
+		}
+	}
+	for _, gvk := range sortedKeys(missing) {
+		errs = append(errs, fmt.Errorf("no definition with the %s extension for %s, served at %s",
+			extensionGVK, gvk, strings.Join(sets.List(missing[gvk]), ", ")))
+	}
+	return errs
+}
+
+// resolveRef returns an error if the $ref does not resolve to a component served by the document, e.g.
+// #/components/schemas/<name> or #/definitions/<name>, only local $refs are supported. The name is resolved
+// against the keys of the components as served, a name that only resolves once unescaped twice is reported as
+// double-encoded.
+func resolveRef(doc interface{}, ref string) error {
+	if !strings.HasPrefix(ref, "#/") {
+		return fmt.Errorf("$ref %q is not local to the document", ref)
+	}
+	path := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+	for i := range path {
+		path[i] = unescapeJSONPointer(path[i])
+	}
+	// the components are found at #/<section>, e.g. #/definitions, in v2 and at #/components/<type> in v3
+	section, name := path[:len(path)-1], path[len(path)-1]
+	if len(section) != 1 && (len(section) != 2 || section[0] != "components") {
+		return fmt.Errorf("$ref %q is not a component of the document", ref)
+	}
+	components, _ := lookup(doc, section...).(map[string]interface{})
+	if _, ok := components[name]; ok {
+		return nil
+	}
+	if _, ok := components[unescapeJSONPointer(name)]; ok {
+		return fmt.Errorf("double-encoded $ref %q", ref)
+	}
+	return fmt.Errorf("dangling $ref %q", ref)
+}
+
+// lookup returns the node at the path of the document, nil if there is none.
+func lookup(node interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[key]
+	}
+	return node
+}
+
+// collectRefs adds the $refs of the node to refs together with the JSON paths they are found at.
+func collectRefs(node interface{}, path string, refs map[string]sets.Set[string]) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				if refs[ref] == nil {
+					refs[ref] = sets.New[string]()
+				}
+				refs[ref].Insert(path)
+				continue
+			}
+			collectRefs(value, path+"/"+escapeJSONPointer(key), refs)
+		}
+	case []interface{}:
+		for i, value := range n {
+			collectRefs(value, fmt.Sprintf("%s/%d", path, i), refs)
+		}
+	}
+}
+
+// groupVersionKinds returns the GroupVersionKinds of the extension of the node, the extension is a list for
+// the definitions and a single GroupVersionKind for the operations.
+func groupVersionKinds(node interface{}) []schema.GroupVersionKind {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var values []interface{}
+	switch extension := m[extensionGVK].(type) {
+	case []interface{}:
+		values = extension
+	case map[string]interface{}:
+		values = []interface{}{extension}
+	}
+	gvks := []schema.GroupVersionKind{}
+	for _, value := range values {
+		gvk, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		group, _ := gvk["group"].(string)
+		version, _ := gvk["version"].(string)
+		kind, _ := gvk["kind"].(string)
+		gvks = append(gvks, schema.GroupVersionKind{Group: group, Version: version, Kind: kind})
+	}
+	return gvks
+}
+
+func escapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+func unescapeJSONPointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package openapi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	cases := map[string]struct {
+		validate func(data []byte) []error
+		doc      string
+		errs     []string
+	}{
+		"valid v2 document": {
+			validate: ValidateV2,
+			doc: `{
+				"paths": {"/apis/example.com/v1/foos": {
+					"get": {"x-kubernetes-group-version-kind": {"group": "example.com", "version": "v1", "kind": "Foo"}},
+					"parameters": [{"$ref": "#/parameters/pretty"}]
+				}},
+				"parameters": {"pretty": {"name": "pretty"}},
+				"definitions": {
+					"com.example.v1.Foo": {
+						"properties": {"spec": {"$ref": "#/definitions/com.example.v1.FooSpec"}},
+						"x-kubernetes-group-version-kind": [{"group": "example.com", "version": "v1", "kind": "Foo"}]
+					},
+					"com.example.v1.FooSpec": {}
+				}
+			}`,
+		},
+		"valid v3 document with escaped $ref": {
+			validate: ValidateV3,
+			doc: `{
+				"components": {"schemas": {
+					"example.com/v1.Foo": {"properties": {"spec": {"$ref": "#/components/schemas/example.com~1v1.FooSpec"}}},
+					"example.com/v1.FooSpec": {}
+				}}
+			}`,
+		},
+		"dangling and double-encoded $refs": {
+			validate: ValidateV3,
+			doc: `{
+				"components": {"schemas": {
+					"example.com/v1.Foo": {"properties": {
+						"spec": {"$ref": "#/components/schemas/example.com~01v1.FooSpec"},
+						"status": {"$ref": "#/components/schemas/com.example.v1.FooStatus"}
+					}},
+					"example.com/v1.FooSpec": {}
+				}}
+			}`,
+			errs: []string{
+				`double-encoded $ref "#/components/schemas/example.com~01v1.FooSpec", referenced at /components/schemas/example.com~1v1.Foo/properties/spec`,
+				`dangling $ref "#/components/schemas/com.example.v1.FooStatus", referenced at /components/schemas/example.com~1v1.Foo/properties/status`,
+			},
+		},
+		"$ref to a name with an escaped ~1": {
+			validate: ValidateV3,
+			doc: `{
+				"components": {"schemas": {
+					"example.com/v1.Foo": {"properties": {
+						"spec": {"$ref": "#/components/schemas/example.com~01v1.FooSpec"},
+						"status": {"$ref": "#/components/schemas/example.com~001v1.FooStatus"}
+					}},
+					"example.com~1v1.FooSpec": {},
+					"example.com~1v1.FooStatus": {}
+				}}
+			}`,
+			errs: []string{
+				`double-encoded $ref "#/components/schemas/example.com~001v1.FooStatus", referenced at /components/schemas/example.com~1v1.Foo/properties/status`,
+			},
+		},
+		"$ref to a property of a component": {
+			validate: ValidateV3,
+			doc: `{
+				"components": {"schemas": {
+					"example.com/v1.Foo": {"properties": {
+						"spec": {"$ref": "#/components/schemas/example.com~1v1.Bar/properties/spec"}
+					}},
+					"example.com/v1.Bar": {"properties": {"spec": {}}}
+				}}
+			}`,
+			errs: []string{
+				`$ref "#/components/schemas/example.com~1v1.Bar/properties/spec" is not a component of the document, referenced at /components/schemas/example.com~1v1.Foo/properties/spec`,
+			},
+		},
+		"missing GroupVersionKind extension": {
+			validate: ValidateV2,
+			doc: `{
+				"paths": {"/apis/example.com/v1/foos": {
+					"get": {"x-kubernetes-group-version-kind": {"group": "example.com", "version": "v1", "kind": "Foo"}}
+				}},
+				"definitions": {"com.example.v1.Foo": {}}
+			}`,
+			errs: []string{
+				"no definition with the x-kubernetes-group-version-kind extension for example.com/v1, Kind=Foo, served at /apis/example.com/v1/foos",
+			},
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			errs := []string{}
+			for _, err := range tc.validate([]byte(tc.doc)) {
+				errs = append(errs, err.Error())
+			}
+			assert.ElementsMatch(t, tc.errs, errs)
+		})
+	}
+}
@@ -0,0 +1,337 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultTable              = "kv"
+	defaultPollInterval       = time.Second
+	defaultCompactionInterval = 5 * time.Minute
+	// watchBatchSize is the maximum number of changes read by a watcher per query
+	watchBatchSize = 500
+)
+
+// Options configures the SQL backend.
+type Options struct {
+	// Dialect of the database, SQLite or Postgres
+	Dialect Dialect
+	// Table is the name of the table holding the objects, "kv" by default. The revisions are kept in the
+	// table <Table>_revision.
+	Table string
+	// PollInterval is the interval the watchers poll the table for changes, 1s by default
+	PollInterval time.Duration
+	// CompactionInterval is the interval the history is compacted, 5m by default. Every interval the history
+	// is compacted up to the revision observed at the previous interval. A negative interval disables the
+	// compaction.
+	CompactionInterval time.Duration
+}
+
+// Backend stores the objects of all the resources in a single table of a SQL database. Each write inserts a
+// row with the next revision of the database, the rows form the change log the watchers poll. The revision
+// of the row is the resourceVersion of the object.
+//
+// The writers are serialized by locking the revision row of the database, so revisions are committed in
+// order. For SQLite the database should be opened with a busy timeout, e.g. "file.db?_busy_timeout=5000".
+type Backend struct {
+	db           *sql.DB
+	dialect      Dialect
+	table        string
+	pollInterval time.Duration
+	// compactRevision is the latest compacted revision observed by the backend
+	compactRevision atomic.Int64
+	cancel          context.CancelFunc
+}
+
+// row is a row of the table, i.e. a revision of a key
+type row struct {
+	revision       int64
+	key            string
+	createRevision int64
+	deleted        bool
+	value          []byte
+	prevValue      []byte
+}
+
+// querier is implemented by sql.DB and sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// NewBackend creates the tables in the database if they do not exist yet and starts the compaction of the
+// history. The compaction stops when the context is cancelled or the backend is closed.
+func NewBackend(ctx context.Context, db *sql.DB, opts Options) (*Backend, error) {
+	if opts.Dialect.BindVar == nil {
+		return nil, errors.New("sql storage requires a dialect")
+	}
+	if opts.Table == "" {
+		opts.Table = defaultTable
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.CompactionInterval == 0 {
+		opts.CompactionInterval = defaultCompactionInterval
+	}
+	b := &Backend{
+		db:           db,
+		dialect:      opts.Dialect,
+		table:        opts.Table,
+		pollInterval: opts.PollInterval,
+	}
+	if err := b.createSchema(ctx); err != nil {
+		return nil, err
+	}
+	// observe the compacted revision of the database
+	if _, _, err := b.revisions(ctx, db); err != nil {
+		return nil, err
+	}
+
+	ctx, b.cancel = context.WithCancel(ctx)
+	if opts.CompactionInterval > 0 {
+		go b.compactor(ctx, opts.CompactionInterval)
+	}
+	return b, nil
+}
+
+// Close stops the compaction of the history, the database is closed by its owner.
+func (b *Backend) Close() {
+	b.cancel()
+}
+
+func (b *Backend) createSchema(ctx context.Context) error {
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+			revision BIGINT PRIMARY KEY,
+			name %[2]s NOT NULL,
+			create_revision BIGINT NOT NULL,
+			deleted INTEGER NOT NULL,
+			value %[3]s,
+			prev_value %[3]s
+		)`, b.table, b.dialect.KeyType, b.dialect.BlobType),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_name_revision ON %[1]s (name, revision)`, b.table),
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s_revision (
+			id INTEGER PRIMARY KEY,
+			revision BIGINT NOT NULL,
+			compact_revision BIGINT NOT NULL
+		)`, b.table),
+		fmt.Sprintf(`INSERT INTO %[1]s_revision (id, revision, compact_revision) VALUES (1, 0, 0) ON CONFLICT DO NOTHING`, b.table),
+	}
+	for _, stmt := range statements {
+		if _, err := b.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("cannot create the sql storage schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// write runs fn in a transaction holding the lock on the revision row of the database. fn is handed out the
+// revision of the write, the revision is only consumed when fn succeeds.
+func (b *Backend) write(ctx context.Context, fn func(tx *sql.Tx, revision int64) error) (int64, error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s_revision SET revision = revision + 1 WHERE id = 1`, b.table)); err != nil {
+		return 0, err
+	}
+	revision, _, err := b.revisions(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := fn(tx, revision); err != nil {
+		return 0, err
+	}
+	return revision, tx.Commit()
+}
+
+// revisions returns the current and the compacted revision of the database.
+func (b *Backend) revisions(ctx context.Context, q querier) (revision, compactRevision int64, err error) {
+	err = q.QueryRowContext(ctx, fmt.Sprintf(`SELECT revision, compact_revision FROM %s_revision WHERE id = 1`, b.table)).
+		Scan(&revision, &compactRevision)
+	if err == nil {
+		b.observeCompactRevision(compactRevision)
+	}
+	return revision, compactRevision, err
+}
+
+func (b *Backend) observeCompactRevision(compactRevision int64) {
+	for {
+		current := b.compactRevision.Load()
+		if compactRevision <= current || b.compactRevision.CompareAndSwap(current, compactRevision) {
+			return
+		}
+	}
+}
+
+// insert adds a revision of a key to the table.
+func (b *Backend) insert(ctx context.Context, tx *sql.Tx, r *row) error {
+	deleted := 0
+	if r.deleted {
+		deleted = 1
+	}
+	_, err := tx.ExecContext(ctx, b.dialect.rebind(fmt.Sprintf(
+		`INSERT INTO %s (revision, name, create_revision, deleted, value, prev_value) VALUES (?, ?, ?, ?, ?, ?)`, b.table)),
+		r.revision, r.key, r.createRevision, deleted, r.value, r.prevValue)
+	return err
+}
+
+// latest returns the latest revision of the key, nil if the key was never written or is compacted.
+func (b *Backend) latest(ctx context.Context, q querier, key string) (*row, error) {
+	rows, err := q.QueryContext(ctx, b.dialect.rebind(fmt.Sprintf(
+		`SELECT revision, name, create_revision, deleted, value, prev_value FROM %s
+		WHERE name = ? ORDER BY revision DESC LIMIT 1`, b.table)), key)
+	if err != nil {
+		return nil, err
+	}
+	result, err := scanRows(rows)
+	if err != nil || len(result) == 0 {
+		return nil, err
+	}
+	return result[0], nil
+}
+
+// list returns the keys in the range [from, end) that exist at the revision, ordered by key. A limit <= 0
+// returns all the keys.
+func (b *Backend) list(ctx context.Context, q querier, from, end string, revision int64, limit int64) ([]*row, error) {
+	cond, args := keyRange("kv.name", from, end)
+	query := fmt.Sprintf(`SELECT kv.revision, kv.name, kv.create_revision, kv.deleted, kv.value, kv.prev_value FROM %[1]s kv
+		WHERE %[2]s AND kv.deleted = 0 AND kv.revision = (
+			SELECT MAX(m.revision) FROM %[1]s m WHERE m.name = kv.name AND m.revision <= ?)
+		ORDER BY kv.name`, b.table, cond)
+	args = append(args, revision)
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := q.QueryContext(ctx, b.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// count returns the number of keys in the range [from, end) that exist at the revision.
+func (b *Backend) count(ctx context.Context, q querier, from, end string, revision int64) (int64, error) {
+	var count int64
+	cond, args := keyRange("kv.name", from, end)
+	err := q.QueryRowContext(ctx, b.dialect.rebind(fmt.Sprintf(`SELECT COUNT(*) FROM %[1]s kv
+		WHERE %[2]s AND kv.deleted = 0 AND kv.revision = (
+			SELECT MAX(m.revision) FROM %[1]s m WHERE m.name = kv.name AND m.revision <= ?)`, b.table, cond)),
+		append(args, revision)...).Scan(&count)
+	return count, err
+}
+
+// changes returns the revisions of the keys in the range [from, end) after the revision, ordered by revision.
+func (b *Backend) changes(ctx context.Context, q querier, from, end string, revision int64) ([]*row, error) {
+	cond, args := keyRange("name", from, end)
+	rows, err := q.QueryContext(ctx, b.dialect.rebind(fmt.Sprintf(
+		`SELECT revision, name, create_revision, deleted, value, prev_value FROM %s
+		WHERE %s AND revision > ? ORDER BY revision LIMIT ?`, b.table, cond)),
+		append(args, revision, watchBatchSize)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanRows(rows)
+}
+
+// keyRange returns the condition selecting the keys of the column in the range [from, end). The keys never
+// contain a NUL byte, which is not supported by all databases, so a bound ending with a NUL byte is turned
+// into the equivalent bound on the key without it, e.g. [key\x00 is (key.
+func keyRange(column, from, end string) (string, []any) {
+	lower, upper := column+" >= ?", column+" < ?"
+	if trimmed, ok := strings.CutSuffix(from, "\x00"); ok {
+		lower, from = column+" > ?", trimmed
+	}
+	if trimmed, ok := strings.CutSuffix(end, "\x00"); ok {
+		upper, end = column+" <= ?", trimmed
+	}
+	return lower + " AND " + upper, []any{from, end}
+}
+
+func scanRows(rows *sql.Rows) ([]*row, error) {
+	defer rows.Close()
+	var result []*row
+	for rows.Next() {
+		r := &row{}
+		var deleted int
+		if err := rows.Scan(&r.revision, &r.key, &r.createRevision, &deleted, &r.value, &r.prevValue); err != nil {
+			return nil, err
+		}
+		r.deleted = deleted != 0
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// Compact removes the history up to the revision, the latest revision of the keys that exist at the
+// revision is kept. Watchers and continued lists from before the revision fail with an expired error.
+func (b *Backend) Compact(ctx context.Context, revision int64) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// lock the revision row so the compaction is serialized with the writers
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s_revision SET revision = revision WHERE id = 1`, b.table)); err != nil {
+		return err
+	}
+	current, compactRevision, err := b.revisions(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if revision > current {
+		return fmt.Errorf("cannot compact to revision %d, current revision is %d", revision, current)
+	}
+	if revision <= compactRevision {
+		// compacted already
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, b.dialect.rebind(fmt.Sprintf(
+		`UPDATE %s_revision SET compact_revision = ? WHERE id = 1`, b.table)), revision); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, b.dialect.rebind(fmt.Sprintf(`DELETE FROM %[1]s WHERE revision <= ? AND (deleted = 1 OR revision < (
+			SELECT MAX(m.revision) FROM %[1]s m WHERE m.name = %[1]s.name AND m.revision <= ?))`, b.table)),
+		revision, revision); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	b.observeCompactRevision(revision)
+	return nil
+}
+
+// compactor compacts the history every interval up to the revision observed at the previous interval, so
+// the history of at least one interval is available to the watchers.
+func (b *Backend) compactor(ctx context.Context, interval time.Duration) {
+	var revision int64
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if revision > 0 {
+			if err := b.Compact(ctx, revision); err != nil {
+				klog.Errorf("cannot compact sql storage %s to revision %d: %v", b.table, revision, err)
+				return
+			}
+		}
+		current, _, err := b.revisions(ctx, b.db)
+		if err != nil {
+			klog.Errorf("cannot read the revision of sql storage %s: %v", b.table, err)
+			return
+		}
+		revision = current
+	}, interval)
+}
@@ -0,0 +1,50 @@
+package sqlstorage
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect captures the differences between the SQL databases supported by the storage.
+type Dialect struct {
+	// Name of the dialect
+	Name string
+	// BindVar returns the bind variable of the n-th (1-based) parameter of a statement
+	BindVar func(n int) string
+	// KeyType is the column type of the keys, the keys must be ordered byte wise
+	KeyType string
+	// BlobType is the column type of the encoded objects
+	BlobType string
+}
+
+var (
+	// SQLite is the dialect of SQLite, e.g. for development on an embedded database file.
+	SQLite = Dialect{
+		Name:     "sqlite",
+		BindVar:  func(int) string { return "?" },
+		KeyType:  "TEXT",
+		BlobType: "BLOB",
+	}
+	// Postgres is the dialect of PostgreSQL.
+	Postgres = Dialect{
+		Name:     "postgres",
+		BindVar:  func(n int) string { return "$" + strconv.Itoa(n) },
+		KeyType:  `TEXT COLLATE "C"`,
+		BlobType: "BYTEA",
+	}
+)
+
+// rebind replaces the '?' bind variables of the query by the bind variables of the dialect.
+func (d Dialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(d.BindVar(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
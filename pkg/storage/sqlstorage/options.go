@@ -0,0 +1,65 @@
+package sqlstorage
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+)
+
+// defaultPrefix is the prefix of the keys in the table, the same as the default etcd prefix
+const defaultPrefix = "/registry"
+
+var _ generic.RESTOptionsGetter = &restOptionsGetter{}
+
+// restOptionsGetter returns the RESTOptions storing the resources in the Backend.
+type restOptionsGetter struct {
+	backend *Backend
+	codec   runtime.Codec
+}
+
+// NewRESTOptionsGetter returns a RESTOptionsGetter storing the resources in the backend, the objects are
+// encoded with the codec. It replaces the etcd RESTOptionsGetter of the apiserver config, e.g.
+// config.RESTOptionsGetter = sqlstorage.NewRESTOptionsGetter(backend, codec).
+func NewRESTOptionsGetter(backend *Backend, codec runtime.Codec) generic.RESTOptionsGetter {
+	return &restOptionsGetter{backend: backend, codec: codec}
+}
+
+func (r *restOptionsGetter) GetRESTOptions(resource schema.GroupResource, example runtime.Object) (generic.RESTOptions, error) {
+	return generic.RESTOptions{
+		StorageConfig: &storagebackend.ConfigForResource{
+			Config: storagebackend.Config{
+				Type:   "sql",
+				Prefix: defaultPrefix,
+				Codec:  r.codec,
+			},
+			GroupResource: resource,
+		},
+		Decorator:               r.backend.decorator,
+		EnableGarbageCollection: true,
+		DeleteCollectionWorkers: 1,
+		ResourcePrefix:          resource.Group + "/" + resource.Resource,
+	}, nil
+}
+
+// decorator implements generic.StorageDecorator, the storage is not cached since the watchers poll the
+// change log, so the trigger and the indexers of the watch cache are not used.
+func (b *Backend) decorator(
+	config *storagebackend.ConfigForResource,
+	resourcePrefix string,
+	keyFunc func(obj runtime.Object) (string, error),
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	getAttrsFunc storage.AttrFunc,
+	trigger storage.IndexerFuncs,
+	indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+	s, err := newStore(b, config.Codec, newFunc, newListFunc, config.Prefix, resourcePrefix, config.GroupResource)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the backend is shared by the resources and closed by its owner
+	return s, func() {}, nil
+}
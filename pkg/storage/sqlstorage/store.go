@@ -0,0 +1,480 @@
+package sqlstorage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/klog/v2"
+)
+
+const (
+	expired         = "The resourceVersion for the provided list is too old."
+	continueExpired = "The provided continue parameter is too old to display a consistent list result. " +
+		"You can start a new list without the continue parameter."
+)
+
+// errConflict is returned by the write transactions when the key changed since it was read
+var errConflict = errors.New("conflict")
+
+var _ storage.Interface = &store{}
+
+// store implements storage.Interface for a resource on top of the Backend.
+type store struct {
+	backend        *Backend
+	codec          runtime.Codec
+	versioner      storage.Versioner
+	pathPrefix     string
+	resourcePrefix string
+	groupResource  schema.GroupResource
+	newFunc        func() runtime.Object
+	newListFunc    func() runtime.Object
+}
+
+func newStore(backend *Backend, codec runtime.Codec, newFunc, newListFunc func() runtime.Object, prefix, resourcePrefix string, groupResource schema.GroupResource) (*store, error) {
+	pathPrefix := path.Join("/", prefix)
+	if !strings.HasSuffix(pathPrefix, "/") {
+		pathPrefix += "/"
+	}
+	if resourcePrefix == "" || resourcePrefix == "/" || !strings.HasPrefix(resourcePrefix, "/") {
+		return nil, fmt.Errorf("invalid resourcePrefix %q", resourcePrefix)
+	}
+	return &store{
+		backend:        backend,
+		codec:          codec,
+		versioner:      storage.APIObjectVersioner{},
+		pathPrefix:     pathPrefix,
+		resourcePrefix: resourcePrefix,
+		groupResource:  groupResource,
+		newFunc:        newFunc,
+		newListFunc:    newListFunc,
+	}, nil
+}
+
+func (s *store) Versioner() storage.Versioner {
+	return s.versioner
+}
+
+func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	preparedKey, err := s.prepareKey(key, false)
+	if err != nil {
+		return err
+	}
+	if version, err := s.versioner.ObjectResourceVersion(obj); err == nil && version != 0 {
+		return storage.ErrResourceVersionSetOnCreate
+	}
+	if err := s.versioner.PrepareObjectForStorage(obj); err != nil {
+		return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+	data, err := runtime.Encode(s.codec, obj)
+	if err != nil {
+		return err
+	}
+
+	revision, err := s.backend.write(ctx, func(tx *sql.Tx, revision int64) error {
+		current, err := s.backend.latest(ctx, tx, preparedKey)
+		if err != nil {
+			return err
+		}
+		if current != nil && !current.deleted {
+			return storage.NewKeyExistsError(preparedKey, 0)
+		}
+		return s.backend.insert(ctx, tx, &row{
+			revision:       revision,
+			key:            preparedKey,
+			createRevision: revision,
+			value:          data,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if out != nil {
+		return s.decode(data, out, revision)
+	}
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object, opts storage.DeleteOptions) error {
+	preparedKey, err := s.prepareKey(key, false)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(out)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	for {
+		current, obj, err := s.getState(ctx, preparedKey, v, false)
+		if err != nil {
+			return err
+		}
+		if preconditions != nil {
+			if err := preconditions.Check(preparedKey, obj); err != nil {
+				return err
+			}
+		}
+		if err := validateDeletion(ctx, obj); err != nil {
+			return err
+		}
+
+		revision, err := s.backend.write(ctx, func(tx *sql.Tx, revision int64) error {
+			if err := s.checkRevision(ctx, tx, preparedKey, current.revision); err != nil {
+				return err
+			}
+			return s.backend.insert(ctx, tx, &row{
+				revision:       revision,
+				key:            preparedKey,
+				createRevision: current.createRevision,
+				deleted:        true,
+				value:          current.value,
+				prevValue:      current.value,
+			})
+		})
+		if errors.Is(err, errConflict) {
+			klog.V(4).Infof("deletion of %s failed because of a conflict, going to retry", preparedKey)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return s.decode(current.value, out, revision)
+	}
+}
+
+func (s *store) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	preparedKey, err := s.prepareKey(key, opts.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	return s.watch(ctx, preparedKey, int64(revision), opts)
+}
+
+func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
+	preparedKey, err := s.prepareKey(key, false)
+	if err != nil {
+		return err
+	}
+	current, _, err := s.backend.revisions(ctx, s.backend.db)
+	if err != nil {
+		return err
+	}
+	if err := s.validateMinimumResourceVersion(opts.ResourceVersion, current); err != nil {
+		return err
+	}
+	r, err := s.backend.latest(ctx, s.backend.db, preparedKey)
+	if err != nil {
+		return err
+	}
+	if r == nil || r.deleted {
+		if opts.IgnoreNotFound {
+			return runtime.SetZeroValue(out)
+		}
+		return storage.NewKeyNotFoundError(preparedKey, 0)
+	}
+	return s.decode(r.value, out, r.revision)
+}
+
+func (s *store) GetList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	keyPrefix, err := s.prepareKey(key, opts.Recursive)
+	if err != nil {
+		return err
+	}
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+	withRev, continueKey, err := storage.ValidateListOptions(keyPrefix, s.versioner, opts)
+	if err != nil {
+		return err
+	}
+
+	current, compactRevision, err := s.backend.revisions(ctx, s.backend.db)
+	if err != nil {
+		return err
+	}
+	if withRev == 0 {
+		withRev = current
+		if err := s.validateMinimumResourceVersion(opts.ResourceVersion, current); err != nil {
+			return err
+		}
+	}
+	if withRev > current {
+		return storage.NewTooLargeResourceVersionError(uint64(withRev), uint64(current), 0)
+	}
+	if withRev < compactRevision {
+		if len(continueKey) > 0 {
+			return apierrors.NewResourceExpired(continueExpired)
+		}
+		return apierrors.NewResourceExpired(expired)
+	}
+
+	// a non recursive list returns the object of the key
+	from, end := keyPrefix, keyPrefix+"\x00"
+	if opts.Recursive {
+		end = prefixEnd(keyPrefix)
+	}
+	if len(continueKey) > 0 {
+		from = continueKey
+	}
+	limit := opts.Predicate.Limit
+	paging := limit > 0
+	newItemFunc := getNewItemFunc(v)
+
+	var lastKey string
+	var hasMore bool
+	for next := from; ; {
+		rows, err := s.backend.list(ctx, s.backend.db, next, end, withRev, limit)
+		if err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if paging && int64(v.Len()) >= opts.Predicate.Limit {
+				hasMore = true
+				break
+			}
+			lastKey = r.key
+			obj := newItemFunc()
+			if err := s.decode(r.value, obj, r.revision); err != nil {
+				return err
+			}
+			if matched, err := opts.Predicate.Matches(obj); err == nil && matched {
+				v.Set(reflect.Append(v, reflect.ValueOf(obj).Elem()))
+			}
+		}
+		// no more keys remain, we didn't request paging or we have filled our bucket
+		if hasMore || !paging || int64(len(rows)) < limit {
+			break
+		}
+		next = lastKey + "\x00"
+		// the selectors dropped some of the objects, double the page size to reduce the number of queries
+		limit *= 2
+	}
+	if v.IsNil() {
+		// Ensure that we never return a nil Items pointer in the result for consistency.
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+
+	var count int64
+	if hasMore && opts.Predicate.Empty() {
+		if count, err = s.backend.count(ctx, s.backend.db, from, end, withRev); err != nil {
+			return err
+		}
+	}
+	continueValue, remainingItemCount, err := storage.PrepareContinueToken(lastKey, keyPrefix, withRev, count, hasMore, opts)
+	if err != nil {
+		return err
+	}
+	return s.versioner.UpdateList(listObj, uint64(withRev), continueValue, remainingItemCount)
+}
+
+func (s *store) GuaranteedUpdate(ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	preparedKey, err := s.prepareKey(key, false)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(destination)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	for {
+		current, obj, err := s.getState(ctx, preparedKey, v, ignoreNotFound)
+		if err != nil {
+			return err
+		}
+		if err := preconditions.Check(preparedKey, obj); err != nil {
+			return err
+		}
+		ret, _, err := tryUpdate(obj, storage.ResponseMeta{ResourceVersion: uint64(current.revision)})
+		if err != nil {
+			return err
+		}
+		if err := s.versioner.PrepareObjectForStorage(ret); err != nil {
+			return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+		}
+		data, err := runtime.Encode(s.codec, ret)
+		if err != nil {
+			return err
+		}
+		if current.revision != 0 && bytes.Equal(data, current.value) {
+			// nothing changed, the write is skipped
+			return s.decode(current.value, destination, current.revision)
+		}
+
+		revision, err := s.backend.write(ctx, func(tx *sql.Tx, revision int64) error {
+			if err := s.checkRevision(ctx, tx, preparedKey, current.revision); err != nil {
+				return err
+			}
+			r := &row{
+				revision:       revision,
+				key:            preparedKey,
+				createRevision: current.createRevision,
+				value:          data,
+				prevValue:      current.value,
+			}
+			if current.revision == 0 {
+				r.createRevision = revision
+			}
+			return s.backend.insert(ctx, tx, r)
+		})
+		if errors.Is(err, errConflict) {
+			klog.V(4).Infof("GuaranteedUpdate of %s failed because of a conflict, going to retry", preparedKey)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return s.decode(data, destination, revision)
+	}
+}
+
+func (s *store) Stats(ctx context.Context) (storage.Stats, error) {
+	prefix, err := s.prepareKey(s.resourcePrefix, true)
+	if err != nil {
+		return storage.Stats{}, err
+	}
+	current, _, err := s.backend.revisions(ctx, s.backend.db)
+	if err != nil {
+		return storage.Stats{}, err
+	}
+	count, err := s.backend.count(ctx, s.backend.db, prefix, prefixEnd(prefix), current)
+	if err != nil {
+		return storage.Stats{}, err
+	}
+	return storage.Stats{ObjectCount: count}, nil
+}
+
+func (s *store) ReadinessCheck() error {
+	return s.backend.db.Ping()
+}
+
+// RequestWatchProgress is a no-op, the watchers do not send progress notifications.
+func (s *store) RequestWatchProgress(ctx context.Context) error {
+	return nil
+}
+
+func (s *store) GetCurrentResourceVersion(ctx context.Context) (uint64, error) {
+	current, _, err := s.backend.revisions(ctx, s.backend.db)
+	return uint64(current), err
+}
+
+// EnableResourceSizeEstimation is a no-op, the object count is read from the database.
+func (s *store) EnableResourceSizeEstimation(storage.KeysFunc) error {
+	return nil
+}
+
+func (s *store) CompactRevision() int64 {
+	return s.backend.compactRevision.Load()
+}
+
+// getState returns the latest revision of the key and its object. If the key does not exist and
+// ignoreNotFound is set an empty revision and a zero object are returned.
+func (s *store) getState(ctx context.Context, key string, v reflect.Value, ignoreNotFound bool) (*row, runtime.Object, error) {
+	r, err := s.backend.latest(ctx, s.backend.db, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r == nil || r.deleted {
+		if !ignoreNotFound {
+			return nil, nil, storage.NewKeyNotFoundError(key, 0)
+		}
+		return &row{}, reflect.New(v.Type()).Interface().(runtime.Object), nil
+	}
+	obj := reflect.New(v.Type()).Interface().(runtime.Object)
+	if err := s.decode(r.value, obj, r.revision); err != nil {
+		return nil, nil, err
+	}
+	return r, obj, nil
+}
+
+// checkRevision returns errConflict when the latest revision of the key is not the revision, a revision of
+// 0 expects the key to not exist.
+func (s *store) checkRevision(ctx context.Context, tx *sql.Tx, key string, revision int64) error {
+	latest, err := s.backend.latest(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+	switch {
+	case latest == nil || latest.deleted:
+		if revision != 0 {
+			return errConflict
+		}
+	case latest.revision != revision:
+		return errConflict
+	}
+	return nil
+}
+
+func (s *store) decode(data []byte, into runtime.Object, revision int64) error {
+	if _, err := conversion.EnforcePtr(into); err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	if _, _, err := s.codec.Decode(data, nil, into); err != nil {
+		return err
+	}
+	return s.versioner.UpdateObject(into, uint64(revision))
+}
+
+func (s *store) validateMinimumResourceVersion(minimumResourceVersion string, actualRevision int64) error {
+	if minimumResourceVersion == "" {
+		return nil
+	}
+	minimumRV, err := s.versioner.ParseResourceVersion(minimumResourceVersion)
+	if err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid resource version: %v", err))
+	}
+	if minimumRV > uint64(actualRevision) {
+		return storage.NewTooLargeResourceVersionError(minimumRV, uint64(actualRevision), 0)
+	}
+	return nil
+}
+
+func (s *store) prepareKey(key string, recursive bool) (string, error) {
+	key, err := storage.PrepareKey(s.resourcePrefix, key, recursive)
+	if err != nil {
+		return "", err
+	}
+	return s.pathPrefix + strings.TrimPrefix(key, "/"), nil
+}
+
+// prefixEnd returns the end of the range of keys starting with the prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// the prefix is all 0xff bytes, the range has no end
+	return "\xff"
+}
+
+func getNewItemFunc(v reflect.Value) func() runtime.Object {
+	elem := v.Type().Elem()
+	return func() runtime.Object {
+		return reflect.New(elem).Interface().(runtime.Object)
+	}
+}
@@ -0,0 +1,230 @@
+package sqlstorage
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func newTestStore(t *testing.T) (*Backend, *store) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_busy_timeout=5000")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	backend, err := NewBackend(ctx, db, Options{
+		Dialect:            SQLite,
+		PollInterval:       10 * time.Millisecond,
+		CompactionInterval: -1,
+	})
+	require.NoError(t, err)
+
+	getter := NewRESTOptionsGetter(backend, scheme.Codecs.LegacyCodec(corev1.SchemeGroupVersion))
+	opts, err := getter.GetRESTOptions(schema.GroupResource{Resource: "configmaps"}, nil)
+	require.NoError(t, err)
+	s, _, err := opts.Decorator(opts.StorageConfig, opts.ResourcePrefix, nil,
+		func() runtime.Object { return &corev1.ConfigMap{} },
+		func() runtime.Object { return &corev1.ConfigMapList{} },
+		nil, nil, nil)
+	require.NoError(t, err)
+	return backend, s.(*store)
+}
+
+func configMap(name, value string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Data:       map[string]string{"key": value},
+	}
+}
+
+func key(name string) string {
+	return "/configmaps/default/" + name
+}
+
+func update(value string) storage.UpdateFunc {
+	return func(input runtime.Object, res storage.ResponseMeta) (runtime.Object, *uint64, error) {
+		cm := input.(*corev1.ConfigMap).DeepCopy()
+		cm.Data = map[string]string{"key": value}
+		return cm, nil, nil
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create, update and delete should bump the resourceVersion", func(t *testing.T) {
+		_, s := newTestStore(t)
+
+		created := &corev1.ConfigMap{}
+		require.NoError(t, s.Create(ctx, key("a"), configMap("a", "1"), created, 0))
+		assert.Equal(t, "1", created.ResourceVersion)
+		assert.True(t, storage.IsExist(s.Create(ctx, key("a"), configMap("a", "1"), nil, 0)))
+
+		updated := &corev1.ConfigMap{}
+		require.NoError(t, s.GuaranteedUpdate(ctx, key("a"), updated, false, nil, update("2"), nil))
+		assert.Equal(t, "2", updated.ResourceVersion)
+		assert.Equal(t, "2", updated.Data["key"])
+
+		// an update without changes is not written
+		require.NoError(t, s.GuaranteedUpdate(ctx, key("a"), updated, false, nil, update("2"), nil))
+		assert.Equal(t, "2", updated.ResourceVersion)
+
+		got := &corev1.ConfigMap{}
+		require.NoError(t, s.Get(ctx, key("a"), storage.GetOptions{}, got))
+		assert.Equal(t, updated, got)
+
+		deleted := &corev1.ConfigMap{}
+		require.NoError(t, s.Delete(ctx, key("a"), deleted, nil, storage.ValidateAllObjectFunc, nil, storage.DeleteOptions{}))
+		assert.Equal(t, "3", deleted.ResourceVersion)
+		assert.True(t, storage.IsNotFound(s.Get(ctx, key("a"), storage.GetOptions{}, got)))
+		assert.True(t, storage.IsNotFound(s.GuaranteedUpdate(ctx, key("a"), updated, false, nil, update("3"), nil)))
+
+		// the key can be created again after the deletion
+		require.NoError(t, s.Create(ctx, key("a"), configMap("a", "4"), created, 0))
+		assert.Equal(t, "4", created.ResourceVersion)
+	})
+
+	t.Run("preconditions should be checked on delete", func(t *testing.T) {
+		_, s := newTestStore(t)
+
+		require.NoError(t, s.Create(ctx, key("a"), configMap("a", "1"), nil, 0))
+		rv := "5"
+		err := s.Delete(ctx, key("a"), &corev1.ConfigMap{}, &storage.Preconditions{ResourceVersion: &rv},
+			storage.ValidateAllObjectFunc, nil, storage.DeleteOptions{})
+		assert.True(t, storage.IsInvalidObj(err))
+	})
+
+	t.Run("list should be paginated at the resourceVersion of the first page", func(t *testing.T) {
+		_, s := newTestStore(t)
+
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			require.NoError(t, s.Create(ctx, key(name), configMap(name, "1"), nil, 0))
+		}
+		pred := storage.Everything
+		pred.Limit = 2
+
+		list := &corev1.ConfigMapList{}
+		require.NoError(t, s.GetList(ctx, "/configmaps", storage.ListOptions{Recursive: true, Predicate: pred}, list))
+		assert.Equal(t, []string{"a", "b"}, names(list))
+		assert.Equal(t, "5", list.ResourceVersion)
+		require.NotNil(t, list.RemainingItemCount)
+		assert.Equal(t, int64(3), *list.RemainingItemCount)
+
+		// changes after the first page are not observed by the next pages
+		require.NoError(t, s.Delete(ctx, key("c"), &corev1.ConfigMap{}, nil, storage.ValidateAllObjectFunc, nil, storage.DeleteOptions{}))
+
+		pred.Continue = list.Continue
+		list = &corev1.ConfigMapList{}
+		require.NoError(t, s.GetList(ctx, "/configmaps", storage.ListOptions{Recursive: true, Predicate: pred}, list))
+		assert.Equal(t, []string{"c", "d"}, names(list))
+
+		pred.Continue = list.Continue
+		list = &corev1.ConfigMapList{}
+		require.NoError(t, s.GetList(ctx, "/configmaps", storage.ListOptions{Recursive: true, Predicate: pred}, list))
+		assert.Equal(t, []string{"e"}, names(list))
+		assert.Empty(t, list.Continue)
+
+		list = &corev1.ConfigMapList{}
+		require.NoError(t, s.GetList(ctx, "/configmaps", storage.ListOptions{Recursive: true, Predicate: storage.Everything}, list))
+		assert.Equal(t, []string{"a", "b", "d", "e"}, names(list))
+		assert.Equal(t, "6", list.ResourceVersion)
+	})
+
+	t.Run("watch should replay the changes after the resourceVersion", func(t *testing.T) {
+		_, s := newTestStore(t)
+
+		require.NoError(t, s.Create(ctx, key("a"), configMap("a", "1"), nil, 0))
+		require.NoError(t, s.Create(ctx, key("b"), configMap("b", "1"), nil, 0))
+		w, err := s.Watch(ctx, "/configmaps", storage.ListOptions{ResourceVersion: "1", Recursive: true, Predicate: storage.Everything})
+		require.NoError(t, err)
+		defer w.Stop()
+
+		require.NoError(t, s.GuaranteedUpdate(ctx, key("a"), &corev1.ConfigMap{}, false, nil, update("2"), nil))
+		require.NoError(t, s.Delete(ctx, key("b"), &corev1.ConfigMap{}, nil, storage.ValidateAllObjectFunc, nil, storage.DeleteOptions{}))
+
+		expected := []struct {
+			eventType       watch.EventType
+			name            string
+			resourceVersion string
+		}{
+			{watch.Added, "b", "2"},
+			{watch.Modified, "a", "3"},
+			{watch.Deleted, "b", "4"},
+		}
+		for _, e := range expected {
+			event := nextEvent(t, w)
+			assert.Equal(t, e.eventType, event.Type)
+			cm := event.Object.(*corev1.ConfigMap)
+			assert.Equal(t, e.name, cm.Name)
+			assert.Equal(t, e.resourceVersion, cm.ResourceVersion)
+		}
+	})
+
+	t.Run("compaction should expire lists and watches before the compacted revision", func(t *testing.T) {
+		backend, s := newTestStore(t)
+
+		require.NoError(t, s.Create(ctx, key("a"), configMap("a", "1"), nil, 0))
+		require.NoError(t, s.GuaranteedUpdate(ctx, key("a"), &corev1.ConfigMap{}, false, nil, update("2"), nil))
+		require.NoError(t, s.Create(ctx, key("b"), configMap("b", "1"), nil, 0))
+		require.NoError(t, s.Delete(ctx, key("b"), &corev1.ConfigMap{}, nil, storage.ValidateAllObjectFunc, nil, storage.DeleteOptions{}))
+		require.NoError(t, backend.Compact(ctx, 3))
+		assert.Equal(t, int64(3), s.CompactRevision())
+
+		list := &corev1.ConfigMapList{}
+		err := s.GetList(ctx, "/configmaps", storage.ListOptions{
+			ResourceVersion:      "1",
+			ResourceVersionMatch: metav1.ResourceVersionMatchExact,
+			Recursive:            true,
+			Predicate:            storage.Everything,
+		}, list)
+		assert.True(t, apierrors.IsResourceExpired(err))
+
+		w, err := s.Watch(ctx, "/configmaps", storage.ListOptions{ResourceVersion: "1", Recursive: true, Predicate: storage.Everything})
+		require.NoError(t, err)
+		defer w.Stop()
+		event := nextEvent(t, w)
+		assert.Equal(t, watch.Error, event.Type)
+
+		// the latest revision of the keys is kept
+		got := &corev1.ConfigMap{}
+		require.NoError(t, s.Get(ctx, key("a"), storage.GetOptions{}, got))
+		assert.Equal(t, "2", got.Data["key"])
+		assert.True(t, storage.IsNotFound(s.Get(ctx, key("b"), storage.GetOptions{}, got)))
+	})
+}
+
+func names(list *corev1.ConfigMapList) []string {
+	var result []string
+	for _, item := range list.Items {
+		result = append(result, item.Name)
+	}
+	return result
+}
+
+func nextEvent(t *testing.T, w watch.Interface) watch.Event {
+	t.Helper()
+	select {
+	case event, ok := <-w.ResultChan():
+		require.True(t, ok, "watch closed")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a watch event")
+	}
+	return watch.Event{}
+}
@@ -0,0 +1,284 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/features"
+	"k8s.io/apiserver/pkg/storage"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/klog/v2"
+)
+
+// outgoingBufSize is the size of the result channel of the watchers
+const outgoingBufSize = 100
+
+var _ watch.Interface = &watcher{}
+
+// watcher polls the change log of the keys in [from, end) and sends the changes matching the predicate.
+type watcher struct {
+	store    *store
+	from     string
+	end      string
+	revision int64
+	pred     storage.SelectionPredicate
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	resultChan chan watch.Event
+}
+
+func (s *store) watch(ctx context.Context, key string, revision int64, opts storage.ListOptions) (watch.Interface, error) {
+	w := &watcher{
+		store:      s,
+		from:       key,
+		end:        key + "\x00",
+		revision:   revision,
+		pred:       opts.Predicate,
+		resultChan: make(chan watch.Event, outgoingBufSize),
+	}
+	if opts.Recursive {
+		w.end = prefixEnd(key)
+	}
+	if opts.Predicate.Empty() {
+		w.pred = storage.Everything
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
+
+	initialEvents := areInitialEventsRequired(revision, opts)
+	switch {
+	case initialEvents && revision > 0:
+		current, _, err := s.backend.revisions(ctx, s.backend.db)
+		if err != nil {
+			return nil, err
+		}
+		if revision > current {
+			return nil, storage.NewTooLargeResourceVersionError(uint64(revision), uint64(current), 1)
+		}
+	case !initialEvents && revision == 0:
+		// the watch starts at the most recent revision
+		current, _, err := s.backend.revisions(ctx, s.backend.db)
+		if err != nil {
+			return nil, err
+		}
+		w.revision = current
+	}
+	go w.run(initialEvents, isInitialEventsEndBookmarkRequired(opts))
+	return w, nil
+}
+
+func (w *watcher) Stop() {
+	w.cancel()
+}
+
+func (w *watcher) ResultChan() <-chan watch.Event {
+	return w.resultChan
+}
+
+func (w *watcher) run(initialEvents, initialEventsEndBookmark bool) {
+	defer close(w.resultChan)
+
+	if initialEvents {
+		if err := w.sync(); err != nil {
+			klog.Errorf("failed to sync with latest state: %v", err)
+			w.sendError(err)
+			return
+		}
+	}
+	if initialEventsEndBookmark {
+		obj := w.store.newFunc()
+		if err := w.store.versioner.UpdateObject(obj, uint64(w.revision)); err != nil {
+			w.sendError(err)
+			return
+		}
+		if err := storage.AnnotateInitialEventsEndBookmark(obj); err != nil {
+			w.sendError(err)
+			return
+		}
+		if !w.send(watch.Event{Type: watch.Bookmark, Object: obj}) {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(w.store.backend.pollInterval)
+	defer ticker.Stop()
+	for {
+		more, err := w.poll()
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				w.sendError(err)
+			}
+			return
+		}
+		if more {
+			// the batch was full, read the next batch right away
+			continue
+		}
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync sends the objects existing at the current revision as added events.
+func (w *watcher) sync() error {
+	current, _, err := w.store.backend.revisions(w.ctx, w.store.backend.db)
+	if err != nil {
+		return err
+	}
+	rows, err := w.store.backend.list(w.ctx, w.store.backend.db, w.from, w.end, current, 0)
+	if err != nil {
+		return err
+	}
+	w.revision = current
+	for _, r := range rows {
+		obj, err := w.decode(r.value, r.revision)
+		if err != nil {
+			return err
+		}
+		if !w.matches(obj) {
+			continue
+		}
+		if !w.send(watch.Event{Type: watch.Added, Object: obj}) {
+			return context.Canceled
+		}
+	}
+	return nil
+}
+
+// poll sends the changes after the revision of the watcher and reports whether more changes may be waiting.
+func (w *watcher) poll() (bool, error) {
+	rows, err := w.store.backend.changes(w.ctx, w.store.backend.db, w.from, w.end, w.revision)
+	if err != nil {
+		return false, err
+	}
+	// the changes after the revision of the watcher are incomplete once the revision is compacted
+	_, compactRevision, err := w.store.backend.revisions(w.ctx, w.store.backend.db)
+	if err != nil {
+		return false, err
+	}
+	if w.revision < compactRevision {
+		return false, apierrors.NewResourceExpired("The resourceVersion for the provided watch is too old.")
+	}
+	for _, r := range rows {
+		event, err := w.event(r)
+		if err != nil {
+			return false, err
+		}
+		w.revision = r.revision
+		if event == nil {
+			continue
+		}
+		if !w.send(*event) {
+			return false, context.Canceled
+		}
+	}
+	return len(rows) == watchBatchSize, nil
+}
+
+// event returns the event of the row as observed by the predicate of the watcher, nil if the watcher is not
+// interested in the change.
+func (w *watcher) event(r *row) (*watch.Event, error) {
+	var obj, oldObj runtime.Object
+	var err error
+	if !r.deleted {
+		if obj, err = w.decode(r.value, r.revision); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.prevValue) != 0 {
+		// the previous object is reported with the revision of the change
+		if oldObj, err = w.decode(r.prevValue, r.revision); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case r.deleted:
+		if !w.matches(oldObj) {
+			return nil, nil
+		}
+		return &watch.Event{Type: watch.Deleted, Object: oldObj}, nil
+	case r.createRevision == r.revision || oldObj == nil:
+		if !w.matches(obj) {
+			return nil, nil
+		}
+		return &watch.Event{Type: watch.Added, Object: obj}, nil
+	}
+	curObjPasses := w.matches(obj)
+	oldObjPasses := w.matches(oldObj)
+	switch {
+	case curObjPasses && oldObjPasses:
+		return &watch.Event{Type: watch.Modified, Object: obj}, nil
+	case curObjPasses && !oldObjPasses:
+		return &watch.Event{Type: watch.Added, Object: obj}, nil
+	case !curObjPasses && oldObjPasses:
+		return &watch.Event{Type: watch.Deleted, Object: oldObj}, nil
+	}
+	return nil, nil
+}
+
+func (w *watcher) decode(data []byte, revision int64) (runtime.Object, error) {
+	obj := w.store.newFunc()
+	if err := w.store.decode(data, obj, revision); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (w *watcher) matches(obj runtime.Object) bool {
+	if obj == nil {
+		return false
+	}
+	matched, err := w.pred.Matches(obj)
+	return err == nil && matched
+}
+
+// send returns false when the watcher is stopped before the event is sent.
+func (w *watcher) send(event watch.Event) bool {
+	select {
+	case w.resultChan <- event:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
+
+func (w *watcher) sendError(err error) {
+	if w.ctx.Err() != nil {
+		// the watcher is stopped
+		return
+	}
+	var status *apierrors.StatusError
+	if !errors.As(err, &status) {
+		status = apierrors.NewInternalError(err)
+	}
+	w.send(watch.Event{Type: watch.Error, Object: &status.ErrStatus})
+}
+
+// areInitialEventsRequired returns true if the objects existing at the start of the watch are sent as added
+// events, see the etcd3 watcher.
+func areInitialEventsRequired(revision int64, opts storage.ListOptions) bool {
+	if opts.SendInitialEvents == nil && revision == 0 {
+		return true // legacy case
+	}
+	if !utilfeature.DefaultFeatureGate.Enabled(features.WatchList) {
+		return false
+	}
+	return opts.SendInitialEvents != nil && *opts.SendInitialEvents
+}
+
+// isInitialEventsEndBookmarkRequired returns true if the end of the initial events is marked with a
+// bookmark, see the etcd3 watcher.
+func isInitialEventsEndBookmarkRequired(opts storage.ListOptions) bool {
+	if !utilfeature.DefaultFeatureGate.Enabled(features.WatchList) {
+		return false
+	}
+	return opts.SendInitialEvents != nil && *opts.SendInitialEvents && opts.Predicate.AllowWatchBookmarks
+}
@@ -15,21 +15,16 @@ func WithParentStorage(ctx context.Context, storage rest.Storage) context.Contex
 	return context.WithValue(ctx, parentStorageContextKey, storage)
 }
 
-// GetParentStorage tries getting the parent storage from context
+// GetParentStorage tries getting the parent storage from context, it returns false if no parent storage
+// is set or the parent storage does not implement rest.StandardStorage.
 func GetParentStorage(ctx context.Context) (rest.StandardStorage, bool) {
-	parentStorage := ctx.Value(parentStorageContextKey)
-	if parentStorage == nil {
-		return nil, false
-	}
-	return parentStorage.(rest.StandardStorage), true
+	parentStorage, ok := ctx.Value(parentStorageContextKey).(rest.StandardStorage)
+	return parentStorage, ok
 }
 
 // GetParentStorageGetter tries getting the get-only parent storage from
 // context.
 func GetParentStorageGetter(ctx context.Context) (rest.Getter, bool) {
-	parentStorage := ctx.Value(parentStorageContextKey)
-	if parentStorage == nil {
-		return nil, false
-	}
-	return parentStorage.(rest.Getter), true
+	parentStorage, ok := ctx.Value(parentStorageContextKey).(rest.Getter)
+	return parentStorage, ok
 }
@@ -1,38 +0,0 @@
-/*
-Copyright 2020 The Kubernetes Authors.
-
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    http://www.apache.org/licenses/LICENSE-2.0
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-package loopback
-
-import (
-	"sync"
-
-	"k8s.io/apiserver/pkg/authorization/authorizer"
-)
-
-var authzOnce sync.Once
-var authz authorizer.Authorizer
-
-// SetAuthorizer provides loopback authorizer for one time
-func SetAuthorizer(c authorizer.Authorizer) {
-	authzOnce.Do(func() {
-		authz = c
-	})
-}
-
-// GetAuthorizer gets loopback authorizer performing delegated authorization.
-func GetAuthorizer() authorizer.Authorizer {
-	return authz
-}
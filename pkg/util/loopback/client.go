@@ -17,22 +17,16 @@ limitations under the License.
 package loopback
 
 import (
-	"sync"
-
+	"k8s.io/apiserver/pkg/authorization/authorizer"
 	"k8s.io/client-go/rest"
 )
 
-var setLoopbackClientOnce sync.Once
-var loopbackClientConfig *rest.Config
-
-// SetLoopbackClientConfig provides loopback client config for one time
-func SetLoopbackClientConfig(c *rest.Config) {
-	setLoopbackClientOnce.Do(func() {
-		loopbackClientConfig = c
-	})
-}
-
-// GetLoopbackClientConfig gets loopback client config
-func GetLoopbackClientConfig() *rest.Config {
-	return loopbackClientConfig
+// Config holds the loopback connections of an apiserver, it is filled when the apiserver is created.
+type Config struct {
+	// ClientConfig is the client config of the apiserver itself.
+	ClientConfig *rest.Config
+	// MasterClientConfig is the client config of the master kube-apiserver.
+	MasterClientConfig *rest.Config
+	// Authorizer performs the delegated authorization of the apiserver.
+	Authorizer authorizer.Authorizer
 }
@@ -1,39 +0,0 @@
-/*
-Copyright 2021 The Kubernetes Authors.
-
-Licensed under the Apache License, Version 2.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    http://www.apache.org/licenses/LICENSE-2.0
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
-package loopback
-
-import (
-	"sync"
-
-	"k8s.io/client-go/rest"
-)
-
-var setLoopbackMasterClientOnce sync.Once
-var loopbackMasterClientConfig *rest.Config
-
-// SetLoopbackMasterClientConfig provides loopback client config for one time
-func SetLoopbackMasterClientConfig(c *rest.Config) {
-	setLoopbackMasterClientOnce.Do(func() {
-		loopbackMasterClientConfig = c
-	})
-}
-
-// GetLoopbackMasterClientConfig gets loopback client config for the
-// master kube-apiserver.
-func GetLoopbackMasterClientConfig() *rest.Config {
-	return loopbackMasterClientConfig
-}